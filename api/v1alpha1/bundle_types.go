@@ -19,6 +19,7 @@ package v1alpha1
 import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 var (
@@ -29,11 +30,15 @@ var (
 type SourceType string
 
 const (
-	SourceTypeImage      SourceType = "image"
-	SourceTypeGit        SourceType = "git"
-	SourceTypeConfigMaps SourceType = "configMaps"
-	SourceTypeUpload     SourceType = "upload"
-	SourceTypeHTTP       SourceType = "http"
+	SourceTypeImage          SourceType = "image"
+	SourceTypeGit            SourceType = "git"
+	SourceTypeConfigMaps     SourceType = "configMaps"
+	SourceTypeSecrets        SourceType = "secrets"
+	SourceTypeUpload         SourceType = "upload"
+	SourceTypeHTTP           SourceType = "http"
+	SourceTypeS3             SourceType = "s3"
+	SourceTypeHelmRepository SourceType = "helmRepository"
+	SourceTypeComposite      SourceType = "composite"
 
 	TypeUnpacked = "Unpacked"
 
@@ -41,6 +46,10 @@ const (
 	ReasonUnpacking                 = "Unpacking"
 	ReasonUnpackSuccessful          = "UnpackSuccessful"
 	ReasonUnpackFailed              = "UnpackFailed"
+	ReasonUnpackUploadFailed        = "UnpackUploadFailed"
+	ReasonUnpackContentTooLarge     = "UnpackContentTooLarge"
+	ReasonUnpackTimedOut            = "UnpackTimedOut"
+	ReasonVerificationFailed        = "VerificationFailed"
 	ReasonProcessingFinalizerFailed = "ProcessingFinalizerFailed"
 
 	PhasePending   = "Pending"
@@ -68,6 +77,11 @@ type BundleSource struct {
 	// ConfigMaps is a list of config map references and their relative
 	// directory paths that represent a bundle filesystem.
 	ConfigMaps []ConfigMapSource `json:"configMaps,omitempty"`
+	// Secrets is a list of secret references and their relative
+	// directory paths that represent a bundle filesystem. The contents of the
+	// secrets are stored and served as bundle content in plaintext, so anyone
+	// who can read the bundle content can read them.
+	Secrets []SecretSource `json:"secrets,omitempty"`
 	// Upload is a source that enables this Bundle's content to be uploaded
 	// via Rukpak's bundle upload service. This source type is primarily useful
 	// with bundle development workflows because it enables bundle developers
@@ -75,6 +89,20 @@ type BundleSource struct {
 	Upload *UploadSource `json:"upload,omitempty"`
 	//  HTTP is the remote location that backs the content of this Bundle.
 	HTTP *HTTPSource `json:"http,omitempty"`
+	// S3 is the object in S3-compatible object storage that backs the content of this Bundle.
+	S3 *S3Source `json:"s3,omitempty"`
+	// HelmRepository is the chart in a helm chart repository that backs the content of this Bundle.
+	HelmRepository *HelmRepositorySource `json:"helmRepository,omitempty"`
+	// Composite is an ordered list of sources whose contents are layered into
+	// the content of this Bundle.
+	Composite *CompositeSource `json:"composite,omitempty"`
+	// Plugin configures a source that is unpacked by an out-of-tree source plugin.
+	// The plugin is selected by Type, which must not be a built-in source type.
+	Plugin *PluginSource `json:"plugin,omitempty"`
+	// Verification configures the verification of the bundle content's
+	// signature before the bundle is unpacked. Verification is supported by
+	// the image and http source types.
+	Verification *Verification `json:"verification,omitempty"`
 }
 
 type ImageSource struct {
@@ -82,6 +110,28 @@ type ImageSource struct {
 	Ref string `json:"ref"`
 	// ImagePullSecretName contains the name of the image pull secret in the namespace that the provisioner is deployed.
 	ImagePullSecretName string `json:"pullSecret,omitempty"`
+	// PodOverrides configures the pod that unpacks the image. PodOverrides is optional and if set, it is
+	// merged into the unpack pod after the cluster-wide unpack pod template of the provisioner. It is
+	// ignored when the provisioner unpacks images without pods. Only labels, annotations and resources
+	// can be overridden unless the unpack pod template configmap of the provisioner allows other fields.
+	PodOverrides *UnpackPodOverrides `json:"podOverrides,omitempty"`
+}
+
+type UnpackPodOverrides struct {
+	// Labels are added to the labels of the unpack pod.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations are added to the annotations of the unpack pod.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// NodeSelector is the node selector of the unpack pod.
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Tolerations are the tolerations of the unpack pod.
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// PriorityClassName is the priority class of the unpack pod.
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// Resources are the compute resources of each container of the unpack pod.
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// Env is added to the environment of each container of the unpack pod, e.g. to configure a proxy.
+	Env []corev1.EnvVar `json:"env,omitempty"`
 }
 
 type GitSource struct {
@@ -98,6 +148,27 @@ type GitSource struct {
 	Ref GitRef `json:"ref"`
 	// Auth configures the authorization method if necessary.
 	Auth Authorization `json:"auth,omitempty"`
+	// Submodules configures whether the submodules of the repository are checked out, recursively.
+	// Submodules on the same host as the repository are fetched with the repository's authorization,
+	// and other submodules are fetched anonymously.
+	Submodules bool `json:"submodules,omitempty"`
+	// SparseCheckout configures whether only Directory, rather than the whole repository, is checked out.
+	// Only the submodules within Directory are checked out when both SparseCheckout and Submodules are set.
+	// SparseCheckout only limits the files that are checked out: the objects of the whole commit are still
+	// fetched, because filtered (partial) fetches are not supported, so it does not reduce the data that is
+	// downloaded from the repository.
+	SparseCheckout bool `json:"sparseCheckout,omitempty"`
+	// Verify configures the verification of the signature of the checked out commit, or of the tag
+	// when Ref.Tag is set. Verify is optional and if set, the bundle is only unpacked if the commit
+	// or tag has a valid signature created by any of the trusted keys.
+	Verify *GitVerification `json:"verify,omitempty"`
+}
+
+type GitVerification struct {
+	// PublicKeys references the keys that are trusted to sign commits and tags. Each data value of the
+	// configmap or secret contains an ASCII-armored GPG public key block, or SSH public keys in the
+	// authorized_keys format, one per line.
+	PublicKeys PublicKeysSource `json:"publicKeys"`
 }
 
 type ConfigMapSource struct {
@@ -108,13 +179,177 @@ type ConfigMapSource struct {
 	Path string `json:"path,omitempty"`
 }
 
+type SecretSource struct {
+	// Secret is a reference to a secret in the rukpak system namespace
+	Secret corev1.LocalObjectReference `json:"secret"`
+	// Path is the relative directory path within the bundle where the files
+	// from the secret will be present when the bundle is unpacked.
+	Path string `json:"path,omitempty"`
+}
+
 type HTTPSource struct {
 	// URL is where the bundle contents is.
 	URL string `json:"url"`
 	// Auth configures the authorization method if necessary.
 	Auth Authorization `json:"auth,omitempty"`
+	//+kubebuilder:validation:Pattern:=`^sha256:[a-f0-9]{64}$`
+	// Digest is the expected digest of the bundle contents, in the form `sha256:<hex>`.
+	// Digest is optional and if set, the bundle contents are rejected if their digest does not match.
+	// The digest of the unpacked bundle contents is always recorded in the resolved source.
+	Digest string `json:"digest,omitempty"`
+	// Format is the format of the bundle contents. Format is optional and if not set, the format is
+	// detected from the contents and the Content-Type of the response.
+	Format ArchiveFormat `json:"format,omitempty"`
+}
+
+type S3Source struct {
+	// Endpoint is the URL of the S3-compatible object storage service, e.g. `https://minio.example.com:9000`.
+	// Endpoint is optional and if not set defaults to `https://s3.amazonaws.com`. Endpoints with a path
+	// are not supported.
+	Endpoint string `json:"endpoint,omitempty"`
+	// Region is the region of the bucket. Region is optional and if not set defaults to `us-east-1`.
+	Region string `json:"region,omitempty"`
+	// Bucket is the name of the bucket that contains the bundle contents.
+	Bucket string `json:"bucket"`
+	// Key is the key of the object that contains the bundle contents.
+	Key string `json:"key"`
+	// VersionID is the version of the object to unpack. VersionID is optional and if not set, the latest
+	// version of the object is unpacked. The version of the unpacked object is recorded in the resolved source
+	// when the bucket is versioned.
+	VersionID string `json:"versionID,omitempty"`
+	// ETag is the expected entity tag of the object. ETag is optional and if set, the object is only unpacked
+	// if its entity tag matches. The entity tag of the unpacked object is always recorded in the resolved source.
+	ETag string `json:"etag,omitempty"`
+	// CredentialsSecret is a reference to a secret, in the namespace that the provisioner is deployed, that
+	// contains the `accessKeyID` and `secretAccessKey` keys, and optionally the `sessionToken` key, of the
+	// credentials used to access the bucket. CredentialsSecret is optional and if not set, the object is
+	// accessed anonymously.
+	CredentialsSecret corev1.LocalObjectReference `json:"credentialsSecret,omitempty"`
+	// Format is the format of the bundle contents. Format is optional and if not set, the format is
+	// detected from the contents and the Content-Type of the object.
+	Format ArchiveFormat `json:"format,omitempty"`
+}
+
+// CompositeConflictPolicy determines how a composite source handles files
+// that are provided at the same path by more than one of its sources.
+type CompositeConflictPolicy string
+
+const (
+	// CompositeConflictPolicyError rejects files that are provided at the same path by more than one source.
+	CompositeConflictPolicyError CompositeConflictPolicy = "Error"
+	// CompositeConflictPolicyOverride replaces files provided by a source with the files that later
+	// sources provide at the same path.
+	CompositeConflictPolicyOverride CompositeConflictPolicy = "Override"
+)
+
+type CompositeSource struct {
+	//+kubebuilder:validation:MinItems:=1
+	// Sources is the ordered list of sources whose contents are layered into the bundle. The bundle
+	// is only unpacked once all of its sources have been unpacked.
+	Sources []CompositeLayer `json:"sources"`
+	//+kubebuilder:validation:Enum:=Error;Override
+	// ConflictPolicy determines how files that more than one source provides at the same path are handled.
+	// ConflictPolicy is optional and if not set defaults to `Error`, which fails to unpack the bundle. With
+	// `Override`, the files of later sources replace the files of earlier sources. A source that provides
+	// a file at the path of a directory of another source, or vice versa, always fails to unpack the bundle.
+	ConflictPolicy CompositeConflictPolicy `json:"conflictPolicy,omitempty"`
 }
 
+// CompositeLayer is a source that is layered into the content of a composite
+// source. Composite and upload sources cannot be layered.
+type CompositeLayer struct {
+	// Path is the relative directory path within the bundle where the contents of the source
+	// will be present when the bundle is unpacked. Path is optional and if not set defaults to
+	// the root of the bundle.
+	Path string `json:"path,omitempty"`
+	// Type defines the kind of content being sourced.
+	Type SourceType `json:"type"`
+	// Image is the bundle image that backs the content of this source.
+	Image *ImageSource `json:"image,omitempty"`
+	// Git is the git repository that backs the content of this source.
+	Git *GitSource `json:"git,omitempty"`
+	// ConfigMaps is a list of config map references and their relative
+	// directory paths that back the content of this source.
+	ConfigMaps []ConfigMapSource `json:"configMaps,omitempty"`
+	// Secrets is a list of secret references and their relative
+	// directory paths that back the content of this source. The contents of
+	// the secrets are stored and served as bundle content in plaintext.
+	Secrets []SecretSource `json:"secrets,omitempty"`
+	// HTTP is the remote location that backs the content of this source.
+	HTTP *HTTPSource `json:"http,omitempty"`
+	// S3 is the object in S3-compatible object storage that backs the content of this source.
+	S3 *S3Source `json:"s3,omitempty"`
+	// HelmRepository is the chart in a helm chart repository that backs the content of this source.
+	HelmRepository *HelmRepositorySource `json:"helmRepository,omitempty"`
+	// Plugin configures a source that is unpacked by an out-of-tree source plugin.
+	Plugin *PluginSource `json:"plugin,omitempty"`
+	// Verification configures the verification of the signature of the content of this source
+	// before it is unpacked. Verification is supported by the image and http source types.
+	Verification *Verification `json:"verification,omitempty"`
+}
+
+// BundleSource returns the source of the layer as a bundle source.
+func (l *CompositeLayer) BundleSource() BundleSource {
+	return BundleSource{
+		Type:           l.Type,
+		Image:          l.Image,
+		Git:            l.Git,
+		ConfigMaps:     l.ConfigMaps,
+		Secrets:        l.Secrets,
+		HTTP:           l.HTTP,
+		S3:             l.S3,
+		HelmRepository: l.HelmRepository,
+		Plugin:         l.Plugin,
+		Verification:   l.Verification,
+	}
+}
+
+type PluginSource struct {
+	// Parameters are the parameters of the source, which are passed to the plugin as is.
+	// Their schema is defined by the plugin.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Parameters runtime.RawExtension `json:"parameters,omitempty"`
+}
+
+type HelmRepositorySource struct {
+	// URL is the URL of the helm chart repository, i.e. the URL that its `index.yaml` is served under.
+	URL string `json:"url"`
+	// Chart is the name of the chart in the repository.
+	Chart string `json:"chart"`
+	// Version is the exact version or the semver constraint, e.g. `>=1.2.0 <2.0.0`, of the chart.
+	// Version is optional and if not set, the latest stable version of the chart is used. If Version
+	// is a constraint, the latest version of the chart that satisfies it is used. The version of the
+	// unpacked chart is recorded in the resolved source.
+	Version string `json:"version,omitempty"`
+	//+kubebuilder:validation:Pattern:=`^sha256:[a-f0-9]{64}$`
+	// Digest is the expected digest of the chart archive, in the form `sha256:<hex>`.
+	// Digest is optional and if set, the chart is rejected if its digest does not match.
+	// The digest of the unpacked chart archive is always recorded in the resolved source.
+	Digest string `json:"digest,omitempty"`
+	// Auth configures the authorization method if necessary. It is used for the requests
+	// for both the repository index and the chart archive.
+	Auth Authorization `json:"auth,omitempty"`
+}
+
+//+kubebuilder:validation:Enum:=tar.gz;tar;tar.zst;zip;yaml
+
+// ArchiveFormat is the format of bundle contents that are downloaded or uploaded as a single file.
+type ArchiveFormat string
+
+const (
+	// ArchiveFormatTarGz is a gzip-compressed tar archive of the bundle root directory.
+	ArchiveFormatTarGz ArchiveFormat = "tar.gz"
+	// ArchiveFormatTar is an uncompressed tar archive of the bundle root directory.
+	ArchiveFormatTar ArchiveFormat = "tar"
+	// ArchiveFormatTarZstd is a zstd-compressed tar archive of the bundle root directory.
+	ArchiveFormatTarZstd ArchiveFormat = "tar.zst"
+	// ArchiveFormatZip is a zip archive of the bundle root directory.
+	ArchiveFormatZip ArchiveFormat = "zip"
+	// ArchiveFormatYAML is a single YAML manifest file, which is unpacked as
+	// the only manifest of a plain bundle at manifests/manifest.yaml.
+	ArchiveFormatYAML ArchiveFormat = "yaml"
+)
+
 type GitRef struct {
 	// Branch refers to the branch to checkout from the repository.
 	// The Branch should contain the bundle manifests in the specified directory.
@@ -125,6 +360,11 @@ type GitRef struct {
 	// Commit refers to the commit to checkout from the repository.
 	// The Commit should contain the bundle manifests in the specified directory.
 	Commit string `json:"commit,omitempty"`
+	// Semver is a semver constraint, e.g. `>=1.2.0 <2.0.0`, that selects the tag to checkout from the repository.
+	// The tags of the repository are listed when the bundle is unpacked, and the highest tag whose name is a semver
+	// version (optionally prefixed with "v") that satisfies the constraint is checked out. The chosen tag and its
+	// commit are recorded in the resolved source.
+	Semver string `json:"semver,omitempty"`
 }
 
 type Authorization struct {
@@ -139,9 +379,66 @@ type Authorization struct {
 	// certificate. In this mode, TLS is susceptible to machine-in-the-middle attacks unless custom verification is
 	// used. This should be used only for testing.
 	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+	// CertificateAuthority references the certificate authorities that are trusted to sign the server's certificate,
+	// in addition to the certificate authorities trusted by the provisioner. It is only used for http(s) URLs.
+	CertificateAuthority *CertificateAuthoritySource `json:"certificateAuthority,omitempty"`
+	// BearerToken selects a key of a secret, in the namespace that the provisioner is deployed, whose value is sent as a
+	// bearer token in the Authorization header. It is only used for http(s) URLs, and cannot be combined with the
+	// basic authentication credentials of Secret.
+	BearerToken *corev1.SecretKeySelector `json:"bearerToken,omitempty"`
+	// Headers are additional headers that are sent with each request. They are only used for http(s) URLs.
+	Headers []HTTPHeader `json:"headers,omitempty"`
+	// ClientCertificate references a secret of type `kubernetes.io/tls`, in the namespace that the provisioner is
+	// deployed, whose certificate and key are presented to the server for mutual TLS authentication. It is only used
+	// for http(s) URLs.
+	ClientCertificate *corev1.LocalObjectReference `json:"clientCertificate,omitempty"`
+}
+
+type CertificateAuthoritySource struct {
+	// ConfigMap is a reference to a configmap, in the namespace that the provisioner is deployed,
+	// whose `ca.crt` key contains PEM-encoded certificate authorities.
+	ConfigMap *corev1.LocalObjectReference `json:"configMap,omitempty"`
+	// Secret is a reference to a secret, in the namespace that the provisioner is deployed,
+	// whose `ca.crt` key contains PEM-encoded certificate authorities.
+	Secret *corev1.LocalObjectReference `json:"secret,omitempty"`
+}
+
+type HTTPHeader struct {
+	// Name is the name of the header.
+	Name string `json:"name"`
+	// Value is the value of the header.
+	Value string `json:"value,omitempty"`
+	// SecretKeyRef selects a key of a secret, in the namespace that the provisioner is deployed,
+	// whose value is the value of the header. It takes precedence over Value.
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
 }
 
-type UploadSource struct{}
+type UploadSource struct {
+	// Format is the format of the uploaded bundle contents. Format is optional and if not set,
+	// the format is detected from the contents.
+	Format ArchiveFormat `json:"format,omitempty"`
+}
+
+type Verification struct {
+	// PublicKeys references the public keys that are trusted to sign the bundle content.
+	// The bundle content is verified if it has a valid signature created by any of the keys.
+	PublicKeys PublicKeysSource `json:"publicKeys"`
+	// SignatureURL is the URL of the detached signature of the bundle content of an http source.
+	// SignatureURL is optional and if not set defaults to the bundle URL with a ".sig" suffix.
+	// The signature is expected to be the base64-encoded signature of the bundle content, as
+	// generated by `cosign sign-blob`. Image sources do not use SignatureURL: their signatures
+	// are looked up in the image's repository, as generated by `cosign sign`.
+	SignatureURL string `json:"signatureURL,omitempty"`
+}
+
+type PublicKeysSource struct {
+	// ConfigMap is a reference to a configmap, in the namespace that the provisioner is deployed,
+	// whose data values are PEM-encoded public keys.
+	ConfigMap *corev1.LocalObjectReference `json:"configMap,omitempty"`
+	// Secret is a reference to a secret, in the namespace that the provisioner is deployed,
+	// whose data values are PEM-encoded public keys.
+	Secret *corev1.LocalObjectReference `json:"secret,omitempty"`
+}
 
 type ProvisionerID string
 
@@ -152,6 +449,19 @@ type BundleStatus struct {
 	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
 	Conditions         []metav1.Condition `json:"conditions,omitempty"`
 	ContentURL         string             `json:"contentURL,omitempty"`
+	// ContentDigest is the digest of the stored content of the bundle, i.e. of the tar.gz archive
+	// that is served at the content URL, in the form "sha256:<hex>". Provisioners verify the content
+	// against it whenever they load it.
+	ContentDigest string `json:"contentDigest,omitempty"`
+	// UnpackAttempts is the number of consecutive failed attempts to unpack the bundle.
+	// It is reset when the bundle is unpacked.
+	UnpackAttempts int32 `json:"unpackAttempts,omitempty"`
+	// LastUnpackAttemptTime is the time of the last failed attempt to unpack the bundle.
+	LastUnpackAttemptTime *metav1.Time `json:"lastUnpackAttemptTime,omitempty"`
+	// NextUnpackRetryTime is the time at which unpacking the bundle is retried after a failed attempt.
+	// It is unset if the bundle failed with a permanent error, e.g. an invalid reference, rejected
+	// credentials or invalid content, in which case unpacking is not retried until the bundle changes.
+	NextUnpackRetryTime *metav1.Time `json:"nextUnpackRetryTime,omitempty"`
 }
 
 //+kubebuilder:object:root=true
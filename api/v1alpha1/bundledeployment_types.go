@@ -31,6 +31,7 @@ const (
 	TypeInstalled      = "Installed"
 
 	ReasonBundleLoadFailed         = "BundleLoadFailed"
+	ReasonContentDigestMismatch    = "ContentDigestMismatch"
 	ReasonReadingContentFailed     = "ReadingContentFailed"
 	ReasonErrorGettingClient       = "ErrorGettingClient"
 	ReasonErrorGettingReleaseState = "ErrorGettingReleaseState"
@@ -22,7 +22,8 @@ limitations under the License.
 package v1alpha1
 
 import (
-	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -30,6 +31,28 @@ import (
 func (in *Authorization) DeepCopyInto(out *Authorization) {
 	*out = *in
 	out.Secret = in.Secret
+	if in.CertificateAuthority != nil {
+		in, out := &in.CertificateAuthority, &out.CertificateAuthority
+		*out = new(CertificateAuthoritySource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.BearerToken != nil {
+		in, out := &in.BearerToken, &out.BearerToken
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Headers != nil {
+		in, out := &in.Headers, &out.Headers
+		*out = make([]HTTPHeader, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ClientCertificate != nil {
+		in, out := &in.ClientCertificate, &out.ClientCertificate
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Authorization.
@@ -154,7 +177,7 @@ func (in *BundleDeploymentStatus) DeepCopyInto(out *BundleDeploymentStatus) {
 	*out = *in
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
-		*out = make([]v1.Condition, len(*in))
+		*out = make([]metav1.Condition, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
@@ -209,18 +232,23 @@ func (in *BundleSource) DeepCopyInto(out *BundleSource) {
 	if in.Image != nil {
 		in, out := &in.Image, &out.Image
 		*out = new(ImageSource)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.Git != nil {
 		in, out := &in.Git, &out.Git
 		*out = new(GitSource)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.ConfigMaps != nil {
 		in, out := &in.ConfigMaps, &out.ConfigMaps
 		*out = make([]ConfigMapSource, len(*in))
 		copy(*out, *in)
 	}
+	if in.Secrets != nil {
+		in, out := &in.Secrets, &out.Secrets
+		*out = make([]SecretSource, len(*in))
+		copy(*out, *in)
+	}
 	if in.Upload != nil {
 		in, out := &in.Upload, &out.Upload
 		*out = new(UploadSource)
@@ -229,8 +257,33 @@ func (in *BundleSource) DeepCopyInto(out *BundleSource) {
 	if in.HTTP != nil {
 		in, out := &in.HTTP, &out.HTTP
 		*out = new(HTTPSource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.S3 != nil {
+		in, out := &in.S3, &out.S3
+		*out = new(S3Source)
 		**out = **in
 	}
+	if in.HelmRepository != nil {
+		in, out := &in.HelmRepository, &out.HelmRepository
+		*out = new(HelmRepositorySource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Composite != nil {
+		in, out := &in.Composite, &out.Composite
+		*out = new(CompositeSource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Plugin != nil {
+		in, out := &in.Plugin, &out.Plugin
+		*out = new(PluginSource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Verification != nil {
+		in, out := &in.Verification, &out.Verification
+		*out = new(Verification)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BundleSource.
@@ -269,11 +322,19 @@ func (in *BundleStatus) DeepCopyInto(out *BundleStatus) {
 	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
-		*out = make([]v1.Condition, len(*in))
+		*out = make([]metav1.Condition, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.LastUnpackAttemptTime != nil {
+		in, out := &in.LastUnpackAttemptTime, &out.LastUnpackAttemptTime
+		*out = (*in).DeepCopy()
+	}
+	if in.NextUnpackRetryTime != nil {
+		in, out := &in.NextUnpackRetryTime, &out.NextUnpackRetryTime
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BundleStatus.
@@ -303,6 +364,113 @@ func (in *BundleTemplate) DeepCopy() *BundleTemplate {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertificateAuthoritySource) DeepCopyInto(out *CertificateAuthoritySource) {
+	*out = *in
+	if in.ConfigMap != nil {
+		in, out := &in.ConfigMap, &out.ConfigMap
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
+	if in.Secret != nil {
+		in, out := &in.Secret, &out.Secret
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertificateAuthoritySource.
+func (in *CertificateAuthoritySource) DeepCopy() *CertificateAuthoritySource {
+	if in == nil {
+		return nil
+	}
+	out := new(CertificateAuthoritySource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CompositeLayer) DeepCopyInto(out *CompositeLayer) {
+	*out = *in
+	if in.Image != nil {
+		in, out := &in.Image, &out.Image
+		*out = new(ImageSource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Git != nil {
+		in, out := &in.Git, &out.Git
+		*out = new(GitSource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ConfigMaps != nil {
+		in, out := &in.ConfigMaps, &out.ConfigMaps
+		*out = make([]ConfigMapSource, len(*in))
+		copy(*out, *in)
+	}
+	if in.Secrets != nil {
+		in, out := &in.Secrets, &out.Secrets
+		*out = make([]SecretSource, len(*in))
+		copy(*out, *in)
+	}
+	if in.HTTP != nil {
+		in, out := &in.HTTP, &out.HTTP
+		*out = new(HTTPSource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.S3 != nil {
+		in, out := &in.S3, &out.S3
+		*out = new(S3Source)
+		**out = **in
+	}
+	if in.HelmRepository != nil {
+		in, out := &in.HelmRepository, &out.HelmRepository
+		*out = new(HelmRepositorySource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Plugin != nil {
+		in, out := &in.Plugin, &out.Plugin
+		*out = new(PluginSource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Verification != nil {
+		in, out := &in.Verification, &out.Verification
+		*out = new(Verification)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CompositeLayer.
+func (in *CompositeLayer) DeepCopy() *CompositeLayer {
+	if in == nil {
+		return nil
+	}
+	out := new(CompositeLayer)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CompositeSource) DeepCopyInto(out *CompositeSource) {
+	*out = *in
+	if in.Sources != nil {
+		in, out := &in.Sources, &out.Sources
+		*out = make([]CompositeLayer, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CompositeSource.
+func (in *CompositeSource) DeepCopy() *CompositeSource {
+	if in == nil {
+		return nil
+	}
+	out := new(CompositeSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ConfigMapSource) DeepCopyInto(out *ConfigMapSource) {
 	*out = *in
@@ -338,7 +506,12 @@ func (in *GitRef) DeepCopy() *GitRef {
 func (in *GitSource) DeepCopyInto(out *GitSource) {
 	*out = *in
 	out.Ref = in.Ref
-	out.Auth = in.Auth
+	in.Auth.DeepCopyInto(&out.Auth)
+	if in.Verify != nil {
+		in, out := &in.Verify, &out.Verify
+		*out = new(GitVerification)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GitSource.
@@ -351,10 +524,46 @@ func (in *GitSource) DeepCopy() *GitSource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GitVerification) DeepCopyInto(out *GitVerification) {
+	*out = *in
+	in.PublicKeys.DeepCopyInto(&out.PublicKeys)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GitVerification.
+func (in *GitVerification) DeepCopy() *GitVerification {
+	if in == nil {
+		return nil
+	}
+	out := new(GitVerification)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPHeader) DeepCopyInto(out *HTTPHeader) {
+	*out = *in
+	if in.SecretKeyRef != nil {
+		in, out := &in.SecretKeyRef, &out.SecretKeyRef
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPHeader.
+func (in *HTTPHeader) DeepCopy() *HTTPHeader {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPHeader)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HTTPSource) DeepCopyInto(out *HTTPSource) {
 	*out = *in
-	out.Auth = in.Auth
+	in.Auth.DeepCopyInto(&out.Auth)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPSource.
@@ -367,9 +576,30 @@ func (in *HTTPSource) DeepCopy() *HTTPSource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HelmRepositorySource) DeepCopyInto(out *HelmRepositorySource) {
+	*out = *in
+	in.Auth.DeepCopyInto(&out.Auth)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HelmRepositorySource.
+func (in *HelmRepositorySource) DeepCopy() *HelmRepositorySource {
+	if in == nil {
+		return nil
+	}
+	out := new(HelmRepositorySource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ImageSource) DeepCopyInto(out *ImageSource) {
 	*out = *in
+	if in.PodOverrides != nil {
+		in, out := &in.PodOverrides, &out.PodOverrides
+		*out = new(UnpackPodOverrides)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageSource.
@@ -382,6 +612,134 @@ func (in *ImageSource) DeepCopy() *ImageSource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PluginSource) DeepCopyInto(out *PluginSource) {
+	*out = *in
+	in.Parameters.DeepCopyInto(&out.Parameters)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PluginSource.
+func (in *PluginSource) DeepCopy() *PluginSource {
+	if in == nil {
+		return nil
+	}
+	out := new(PluginSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PublicKeysSource) DeepCopyInto(out *PublicKeysSource) {
+	*out = *in
+	if in.ConfigMap != nil {
+		in, out := &in.ConfigMap, &out.ConfigMap
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
+	if in.Secret != nil {
+		in, out := &in.Secret, &out.Secret
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PublicKeysSource.
+func (in *PublicKeysSource) DeepCopy() *PublicKeysSource {
+	if in == nil {
+		return nil
+	}
+	out := new(PublicKeysSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *S3Source) DeepCopyInto(out *S3Source) {
+	*out = *in
+	out.CredentialsSecret = in.CredentialsSecret
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new S3Source.
+func (in *S3Source) DeepCopy() *S3Source {
+	if in == nil {
+		return nil
+	}
+	out := new(S3Source)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretSource) DeepCopyInto(out *SecretSource) {
+	*out = *in
+	out.Secret = in.Secret
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretSource.
+func (in *SecretSource) DeepCopy() *SecretSource {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UnpackPodOverrides) DeepCopyInto(out *UnpackPodOverrides) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]v1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(v1.ResourceRequirements)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Env != nil {
+		in, out := &in.Env, &out.Env
+		*out = make([]v1.EnvVar, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UnpackPodOverrides.
+func (in *UnpackPodOverrides) DeepCopy() *UnpackPodOverrides {
+	if in == nil {
+		return nil
+	}
+	out := new(UnpackPodOverrides)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *UploadSource) DeepCopyInto(out *UploadSource) {
 	*out = *in
@@ -396,3 +754,19 @@ func (in *UploadSource) DeepCopy() *UploadSource {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Verification) DeepCopyInto(out *Verification) {
+	*out = *in
+	in.PublicKeys.DeepCopyInto(&out.PublicKeys)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Verification.
+func (in *Verification) DeepCopy() *Verification {
+	if in == nil {
+		return nil
+	}
+	out := new(Verification)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"crypto/tls"
 	"crypto/x509"
 	"flag"
 	"fmt"
@@ -78,8 +79,33 @@ func main() {
 		baseUploadManagerURL        string
 		rukpakVersion               bool
 		provisionerStorageDirectory string
+		contentAddressableStorage   bool
+		storageBackend              string
+		storageS3Endpoint           string
+		storageS3Region             string
+		storageS3Bucket             string
+		storageS3Prefix             string
+		storageS3PresignExpiry      time.Duration
 		uploadStorageDirectory      string
 		uploadStorageSyncInterval   time.Duration
+		uploadMaxSize               int64
+		uploadProxyUserHeader       string
+		uploadProxyGroupsHeader     string
+		imageUnpackMethod           string
+		unpackStorageDirectory      string
+		unpackMaxContentSize        int64
+		gitCacheDirectory           string
+		gitCacheMaxSize             int64
+		sourcePluginsConfig         string
+		unpackPodTemplate           string
+		unpackPodCleanupPolicy      string
+		unpackPodFailedTTL          time.Duration
+		unpackMaxConcurrentPods     int
+		unpackTimeout               time.Duration
+		unpackRetryInitialInterval  time.Duration
+		unpackRetryMaxInterval      time.Duration
+		unpackRetryMaxAttempts      int
+		unpackRetrySourceBackoffs   string
 	)
 	flag.StringVar(&httpBindAddr, "http-bind-address", ":8080", "The address the http server binds to.")
 	flag.StringVar(&httpExternalAddr, "http-external-address", "http://localhost:8080", "The external address at which the http server is reachable.")
@@ -93,8 +119,33 @@ func main() {
 			"Enabling this will ensure there is only one active controller manager.")
 	flag.BoolVar(&rukpakVersion, "version", false, "Displays rukpak version information")
 	flag.StringVar(&provisionerStorageDirectory, "provisioner-storage-dir", storage.DefaultBundleCacheDir, "The directory that is used to store bundle contents.")
+	flag.BoolVar(&contentAddressableStorage, "content-addressable-storage", false, "Store bundle contents by digest, so that bundles with the same contents share a single copy of them.")
+	flag.StringVar(&storageBackend, "storage-backend", string(storage.BackendLocal), fmt.Sprintf("The backend that is used to store bundle contents. One of %v.", storage.Backends))
+	flag.StringVar(&storageS3Endpoint, "storage-s3-endpoint", "https://s3.amazonaws.com", "The endpoint of the S3-compatible object storage service in which bundle contents are stored when the s3 storage backend is used.")
+	flag.StringVar(&storageS3Region, "storage-s3-region", "us-east-1", "The region of the bucket in which bundle contents are stored when the s3 storage backend is used.")
+	flag.StringVar(&storageS3Bucket, "storage-s3-bucket", "", "The bucket in which bundle contents are stored when the s3 storage backend is used.")
+	flag.StringVar(&storageS3Prefix, "storage-s3-prefix", "", "The prefix of the object keys of bundle contents when the s3 storage backend is used.")
+	flag.DurationVar(&storageS3PresignExpiry, "storage-s3-presign-expiry", 0, "How long the presigned URLs of bundle contents are valid for when the s3 storage backend is used. If 0, bundle contents are served from the bucket by the http server instead.")
 	flag.StringVar(&uploadStorageDirectory, "upload-storage-dir", uploadmgr.DefaultBundleCacheDir, "The directory that is used to store bundle uploads.")
 	flag.DurationVar(&uploadStorageSyncInterval, "upload-storage-sync-interval", time.Minute, "Interval on which to garbage collect unused uploaded bundles")
+	flag.Int64Var(&uploadMaxSize, "upload-max-size", uploadmgr.DefaultMaxUploadSize, "The maximum size, in bytes, of uploaded bundle content.")
+	flag.StringVar(&uploadProxyUserHeader, "upload-proxy-user-header", "", "The header in which the authenticating proxy in front of the http server passes the name of the users that upload bundle content. If empty, users are authenticated with their bearer tokens.")
+	flag.StringVar(&uploadProxyGroupsHeader, "upload-proxy-groups-header", "", "The header in which the authenticating proxy in front of the http server passes the groups, separated by \"|\", of the users that upload bundle content.")
+	flag.StringVar(&imageUnpackMethod, "image-unpack-method", string(source.ImageUnpackMethodPod), fmt.Sprintf("The method used to unpack image bundles. One of %v.", source.ImageUnpackMethods))
+	flag.StringVar(&unpackStorageDirectory, "unpack-storage-dir", source.DefaultImageContentDir, "The directory that is used to stage bundle contents uploaded by image unpack pods.")
+	flag.Int64Var(&unpackMaxContentSize, "unpack-max-content-size", source.DefaultImageContentMaxSize, "The maximum size, in bytes, of the gzipped bundle contents that image unpack pods may upload.")
+	flag.StringVar(&gitCacheDirectory, "git-cache-dir", source.DefaultGitCacheDir, "The directory that is used to cache the objects of git repositories. If empty, the objects are not cached.")
+	flag.Int64Var(&gitCacheMaxSize, "git-cache-max-size", source.DefaultGitCacheMaxSize, "The maximum size, in bytes, of the git object cache.")
+	flag.StringVar(&sourcePluginsConfig, "source-plugins-config", "", "The file containing the configuration of the out-of-tree source plugins, which maps source types to plugin endpoints.")
+	flag.StringVar(&unpackPodTemplate, "unpack-pod-template-configmap", "", fmt.Sprintf("The name of the configmap, in the system namespace, whose %q key holds a pod template that is merged into the pods that unpack image bundles.", source.UnpackPodTemplateKey))
+	flag.StringVar(&unpackPodCleanupPolicy, "unpack-pod-cleanup-policy", string(source.ImagePodCleanupPolicyRetain), fmt.Sprintf("The policy for the pods that successfully unpacked image bundles. One of %v.", source.ImagePodCleanupPolicies))
+	flag.DurationVar(&unpackPodFailedTTL, "unpack-pod-failed-ttl", 0, "How long image unpack pods that failed or timed out are kept before they are deleted and the bundle is unpacked again.")
+	flag.IntVar(&unpackMaxConcurrentPods, "unpack-max-concurrent-pods", 0, "The maximum number of image unpack pods that may be pending or running at the same time. If 0, the number of pods is not limited.")
+	flag.DurationVar(&unpackTimeout, "unpack-timeout", 0, "How long an image unpack pod may be pending or running before the bundle fails. If 0, unpack pods never time out.")
+	flag.DurationVar(&unpackRetryInitialInterval, "unpack-retry-initial-interval", bundle.DefaultBackoff.InitialInterval, "The delay before the first retry of a bundle that failed to unpack. The delay doubles with each consecutive failed attempt.")
+	flag.DurationVar(&unpackRetryMaxInterval, "unpack-retry-max-interval", bundle.DefaultBackoff.MaxInterval, "The maximum delay between retries of a bundle that failed to unpack.")
+	flag.IntVar(&unpackRetryMaxAttempts, "unpack-retry-max-attempts", 0, "The number of consecutive failed attempts to unpack a bundle after which it is no longer retried until it changes. If 0, failed unpacks are retried until they succeed or fail with a permanent error.")
+	flag.StringVar(&unpackRetrySourceBackoffs, "unpack-retry-source-backoffs", "", "A comma-separated list of <source type>=<initial interval>/<max interval>[/<max attempts>] entries that override the unpack retry flags for bundles of the given source types, e.g. \"git=30s/30m/5\".")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -162,38 +213,90 @@ func main() {
 		os.Exit(1)
 	}
 
-	localStorage := &storage.LocalDirectory{
-		RootDirectory: provisionerStorageDirectory,
-		URL:           *storageURL,
-	}
-
-	var rootCAs *x509.CertPool
+	var (
+		rootCAs *x509.CertPool
+		caData  []byte
+	)
 	if bundleCAFile != "" {
 		var err error
 		if rootCAs, err = util.LoadCertPool(bundleCAFile); err != nil {
 			setupLog.Error(err, "unable to parse bundle certificate authority file")
 			os.Exit(1)
 		}
+		if caData, err = os.ReadFile(bundleCAFile); err != nil {
+			setupLog.Error(err, "unable to read bundle certificate authority file")
+			os.Exit(1)
+		}
+	}
+
+	var contentStorage storage.Storage
+	switch storage.Backend(storageBackend) {
+	case storage.BackendLocal:
+		contentStorage = &storage.LocalDirectory{
+			RootDirectory: provisionerStorageDirectory,
+			URL:           *storageURL,
+		}
+		if contentAddressableStorage {
+			contentStorage = &storage.ContentAddressableDirectory{
+				RootDirectory: provisionerStorageDirectory,
+				URL:           *storageURL,
+			}
+		}
+	case storage.BackendS3:
+		s3Transport := http.DefaultTransport.(*http.Transport).Clone()
+		if rootCAs != nil {
+			s3Transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}
+		}
+		s3Storage, err := storage.NewS3(storage.S3Config{
+			Endpoint:      storageS3Endpoint,
+			Region:        storageS3Region,
+			Bucket:        storageS3Bucket,
+			Prefix:        storageS3Prefix,
+			PresignExpiry: storageS3PresignExpiry,
+		}, *storageURL, s3Transport)
+		if err != nil {
+			setupLog.Error(err, "unable to create s3 bundle storage")
+			os.Exit(1)
+		}
+		contentStorage = s3Storage
+	default:
+		setupLog.Error(fmt.Errorf("unknown storage backend %q", storageBackend), "unable to create bundle storage", "backends", storage.Backends)
+		os.Exit(1)
+	}
+
+	imageUploadConfig := source.ImageUploadConfig{
+		BaseURL:        httpExternalAddr,
+		CAData:         caData,
+		ContentDir:     unpackStorageDirectory,
+		MaxContentSize: unpackMaxContentSize,
 	}
 
 	httpLoader := storage.NewHTTP(
 		storage.WithRootCAs(rootCAs),
 		storage.WithBearerToken(cfg.BearerToken),
 	)
-	bundleStorage := storage.WithFallbackLoader(localStorage, httpLoader)
+	bundleStorage := storage.WithFallbackLoader(contentStorage, httpLoader)
 
 	// NOTE: AddMetricsExtraHandler isn't actually metrics-specific. We can run
 	// whatever handlers we want on the existing webserver that
 	// controller-runtime runs when MetricsBindAddress is configured on the
 	// manager.
-	if err := mgr.AddMetricsExtraHandler("/bundles/", httpLogger(localStorage)); err != nil {
+	if err := mgr.AddMetricsExtraHandler("/bundles/", httpLogger(contentStorage)); err != nil {
 		setupLog.Error(err, "unable to add bundles http handler to manager")
 		os.Exit(1)
 	}
-	if err := mgr.AddMetricsExtraHandler("/uploads/", httpLogger(uploadmgr.NewUploadHandler(mgr.GetClient(), uploadStorageDirectory))); err != nil {
+	uploadHandler := uploadmgr.NewUploadHandler(mgr.GetClient(), uploadStorageDirectory,
+		uploadmgr.WithMaxUploadSize(uploadMaxSize),
+		uploadmgr.WithProxyAuthConfig(uploadmgr.ProxyAuthConfig{UserHeader: uploadProxyUserHeader, GroupsHeader: uploadProxyGroupsHeader}),
+	)
+	if err := mgr.AddMetricsExtraHandler("/uploads/", httpLogger(uploadHandler)); err != nil {
 		setupLog.Error(err, "unable to add uploads http handler to manager")
 		os.Exit(1)
 	}
+	if err := mgr.AddMetricsExtraHandler(source.ImageUploadPathPrefix, httpLogger(source.NewImageUploadHandler(systemNsCluster.GetClient(), systemNamespace, imageUploadConfig))); err != nil {
+		setupLog.Error(err, "unable to add unpacks http handler to manager")
+		os.Exit(1)
+	}
 	if err := mgr.Add(uploadmgr.NewBundleGC(mgr.GetCache(), uploadStorageDirectory, uploadStorageSyncInterval)); err != nil {
 		setupLog.Error(err, "unable to add bundle garbage collector to manager")
 		os.Exit(1)
@@ -217,17 +320,58 @@ func main() {
 		setupLog.Error(err, "unable to register finalizer", "finalizerKey", finalizer.DeleteCachedBundleKey)
 		os.Exit(1)
 	}
+	if err := bundleFinalizers.Register(finalizer.DeleteImageContentKey, &finalizer.DeleteImageContent{ContentDir: unpackStorageDirectory}); err != nil {
+		setupLog.Error(err, "unable to register finalizer", "finalizerKey", finalizer.DeleteImageContentKey)
+		os.Exit(1)
+	}
+
+	var pluginConfig source.PluginConfig
+	if sourcePluginsConfig != "" {
+		cfg, err := source.LoadPluginConfig(sourcePluginsConfig)
+		if err != nil {
+			setupLog.Error(err, "unable to load source plugin configuration")
+			os.Exit(1)
+		}
+		pluginConfig = *cfg
+	}
 
-	unpacker, err := source.NewDefaultUnpacker(systemNsCluster, systemNamespace, unpackImage, baseUploadManagerURL, rootCAs)
+	source.InstallGitHTTPTransport()
+	unpacker, err := source.NewDefaultUnpacker(systemNsCluster, systemNamespace, unpackImage, baseUploadManagerURL, rootCAs,
+		source.WithImageUnpackMethod(source.ImageUnpackMethod(imageUnpackMethod)),
+		source.WithImageUploadConfig(imageUploadConfig),
+		source.WithSourceCAData(caData),
+		source.WithGitCacheConfig(source.GitCacheConfig{Dir: gitCacheDirectory, MaxSize: gitCacheMaxSize}),
+		source.WithPluginConfig(pluginConfig),
+		source.WithImagePodTemplateConfigMap(unpackPodTemplate),
+		source.WithImagePodLifecycleConfig(source.ImagePodLifecycleConfig{
+			CleanupPolicy:     source.ImagePodCleanupPolicy(unpackPodCleanupPolicy),
+			FailedPodTTL:      unpackPodFailedTTL,
+			MaxConcurrentPods: unpackMaxConcurrentPods,
+			UnpackTimeout:     unpackTimeout,
+		}),
+	)
 	if err != nil {
 		setupLog.Error(err, "unable to setup bundle unpacker")
 		os.Exit(1)
 	}
 
+	sourceBackoffs, err := bundle.ParseSourceBackoffs(unpackRetrySourceBackoffs)
+	if err != nil {
+		setupLog.Error(err, "unable to parse unpack retry source backoffs")
+		os.Exit(1)
+	}
+
 	commonBundleProvisionerOptions := []bundle.Option{
 		bundle.WithUnpacker(unpacker),
 		bundle.WithFinalizers(bundleFinalizers),
 		bundle.WithStorage(bundleStorage),
+		bundle.WithUnpackPodTemplateConfigMap(unpackPodTemplate),
+		bundle.WithBackoff(bundle.Backoff{
+			InitialInterval: unpackRetryInitialInterval,
+			MaxInterval:     unpackRetryMaxInterval,
+			MaxAttempts:     int32(unpackRetryMaxAttempts),
+		}),
+		bundle.WithSourceBackoffs(sourceBackoffs),
 	}
 
 	cfgGetter := helmclient.NewActionConfigGetter(mgr.GetConfig(), mgr.GetRESTMapper(), mgr.GetLogger())
@@ -2,35 +2,32 @@ package main
 
 import (
 	"archive/tar"
-	"bytes"
 	"compress/gzip"
-	"encoding/json"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
-	"k8s.io/apimachinery/pkg/util/sets"
 
+	"github.com/operator-framework/rukpak/internal/unpack"
 	"github.com/operator-framework/rukpak/internal/version"
 )
 
 func main() {
 	var bundleDir string
+	var uploadURL string
+	var uploadTokenFile string
+	var uploadCAFile string
 	var rukpakVersion bool
 
-	skipRootPaths := sets.NewString(
-		"/dev",
-		"/etc",
-		"/proc",
-		"/product_name",
-		"/product_uuid",
-		"/sys",
-		"/bin",
-	)
 	cmd := &cobra.Command{
 		Use:  "unpack",
 		Args: cobra.ExactArgs(0),
@@ -45,78 +42,157 @@ func main() {
 				log.Fatalf("get absolute path of bundle directory %q: %v", bundleDir, err)
 			}
 
-			bundleFS := os.DirFS(bundleDir)
-			buf := &bytes.Buffer{}
-			gzw := gzip.NewWriter(buf)
-			tw := tar.NewWriter(gzw)
-			if err := fs.WalkDir(bundleFS, ".", func(path string, d fs.DirEntry, err error) error {
-				if err != nil {
-					return err
-				}
+			httpClient, token, err := uploadClient(uploadTokenFile, uploadCAFile)
+			if err != nil {
+				log.Fatalf("configure upload client: %v", err)
+			}
 
-				if d.Type()&os.ModeSymlink != 0 {
-					return nil
-				}
-				if bundleDir == "/" {
-					// If bundleDir is the filesystem root, skip some known unrelated directories
-					fullPath := filepath.Join(bundleDir, path)
-					if skipRootPaths.Has(fullPath) {
-						return filepath.SkipDir
-					}
-				}
-				info, err := d.Info()
-				if err != nil {
-					return fmt.Errorf("get file info for %q: %v", path, err)
-				}
+			// Stream the tar.gz directly into the upload request body so that
+			// the bundle contents never need to be buffered in memory.
+			pr, pw := io.Pipe()
+			go func() {
+				pw.CloseWithError(writeBundleTarGZ(pw, bundleDir))
+			}()
 
-				h, err := tar.FileInfoHeader(info, "")
-				if err != nil {
-					return fmt.Errorf("build tar file info header for %q: %v", path, err)
-				}
-				h.Uid = 0
-				h.Gid = 0
-				h.Uname = ""
-				h.Gname = ""
-				h.Name = path
-
-				if err := tw.WriteHeader(h); err != nil {
-					return fmt.Errorf("write tar header for %q: %v", path, err)
-				}
-				if d.IsDir() {
-					return nil
-				}
-				f, err := bundleFS.Open(path)
-				if err != nil {
-					return fmt.Errorf("open file %q: %v", path, err)
-				}
-				if _, err := io.Copy(tw, f); err != nil {
-					return fmt.Errorf("write tar data for %q: %v", path, err)
-				}
-				return nil
-			}); err != nil {
-				log.Fatalf("generate tar.gz for bundle dir %q: %v", bundleDir, err)
+			req, err := http.NewRequestWithContext(cmd.Context(), http.MethodPut, uploadURL, pr)
+			if err != nil {
+				log.Fatalf("create upload request: %v", err)
 			}
-			if err := tw.Close(); err != nil {
-				log.Fatal(err)
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+			req.Header.Set("Content-Type", "application/gzip")
+
+			resp, err := httpClient.Do(req)
+			if err != nil {
+				return &exitError{code: unpack.ExitCodeUploadFailed, err: fmt.Errorf("upload bundle contents to %q: %v", uploadURL, err)}
 			}
-			if err := gzw.Close(); err != nil {
-				log.Fatal(err)
+			defer resp.Body.Close()
+			if resp.StatusCode == http.StatusCreated {
+				return nil
 			}
 
-			bundleMap := map[string]interface{}{
-				"content": buf.Bytes(),
+			body, _ := io.ReadAll(resp.Body)
+			code := unpack.ExitCodeUploadFailed
+			if resp.StatusCode == http.StatusRequestEntityTooLarge {
+				code = unpack.ExitCodeContentTooLarge
 			}
-			enc := json.NewEncoder(os.Stdout)
-			if err := enc.Encode(bundleMap); err != nil {
-				log.Fatalf("encode bundle map as JSON: %v", err)
-			}
-			return nil
+			return &exitError{code: code, err: fmt.Errorf("upload bundle contents to %q: unexpected response %q: %s", uploadURL, resp.Status, strings.TrimSpace(string(body)))}
 		},
+		SilenceUsage:  true,
+		SilenceErrors: true,
 	}
 	cmd.Flags().StringVar(&bundleDir, "bundle-dir", "", "directory in which the bundle can be found")
+	cmd.Flags().StringVar(&uploadURL, "upload-url", "", "URL to which the bundle contents are uploaded")
+	cmd.Flags().StringVar(&uploadTokenFile, "upload-token-file", "", "file containing the bearer token used to authenticate the upload")
+	cmd.Flags().StringVar(&uploadCAFile, "upload-ca-file", "", "file containing the certificate authority used to verify the upload server (optional)")
 	cmd.Flags().BoolVar(&rukpakVersion, "version", false, "displays rukpak version information")
 
 	if err := cmd.Execute(); err != nil {
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			log.Print(exitErr)
+			os.Exit(exitErr.code)
+		}
 		log.Fatal(err)
 	}
 }
+
+// exitError is an error after which the command exits with a specific code,
+// so that the provisioner can tell why the upload failed.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func uploadClient(tokenFile, caFile string) (*http.Client, string, error) {
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, "", fmt.Errorf("read upload token: %v", err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile != "" {
+		caData, err := os.ReadFile(caFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, "", fmt.Errorf("read upload certificate authority: %v", err)
+		}
+		// An empty certificate authority file means that the system
+		// certificate authorities should be used.
+		if len(caData) > 0 {
+			rootCAs := x509.NewCertPool()
+			if !rootCAs.AppendCertsFromPEM(caData) {
+				return nil, "", fmt.Errorf("parse upload certificate authority %q: no certificates found", caFile)
+			}
+			transport.TLSClientConfig = &tls.Config{
+				MinVersion: tls.VersionTLS12,
+				RootCAs:    rootCAs,
+			}
+		}
+	}
+	return &http.Client{Transport: transport}, strings.TrimSpace(string(token)), nil
+}
+
+func writeBundleTarGZ(w io.Writer, bundleDir string) error {
+	bundleFS := os.DirFS(bundleDir)
+	gzw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gzw)
+	if err := fs.WalkDir(bundleFS, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.Type()&os.ModeSymlink != 0 {
+			return nil
+		}
+		if bundleDir == "/" {
+			// If bundleDir is the filesystem root, skip some known unrelated directories
+			if unpack.IsSkippedRootPath(path) {
+				// SkipDir would skip the rest of the root directory if
+				// returned for a file.
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("get file info for %q: %v", path, err)
+		}
+
+		h, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return fmt.Errorf("build tar file info header for %q: %v", path, err)
+		}
+		h.Uid = 0
+		h.Gid = 0
+		h.Uname = ""
+		h.Gname = ""
+		h.Name = path
+
+		if err := tw.WriteHeader(h); err != nil {
+			return fmt.Errorf("write tar header for %q: %v", path, err)
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, err := bundleFS.Open(path)
+		if err != nil {
+			return fmt.Errorf("open file %q: %v", path, err)
+		}
+		defer f.Close()
+		if _, err := io.Copy(tw, f); err != nil {
+			return fmt.Errorf("write tar data for %q: %v", path, err)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("generate tar.gz for bundle dir %q: %v", bundleDir, err)
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gzw.Close()
+}
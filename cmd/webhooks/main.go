@@ -87,6 +87,7 @@ func main() {
 	if err = (&webhook.Bundle{
 		Client:          mgr.GetClient(),
 		SystemNamespace: systemNamespace,
+		APIReader:       mgr.GetAPIReader(),
 	}).SetupWebhookWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create webhook", "webhook", rukpakv1alpha1.BundleKind)
 		os.Exit(1)
@@ -0,0 +1,97 @@
+package bundle
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+// Backoff configures how bundles that failed to unpack are retried.
+type Backoff struct {
+	// InitialInterval is the delay before the first retry. The delay doubles
+	// with each consecutive failed attempt.
+	InitialInterval time.Duration
+
+	// MaxInterval is the maximum delay between retries.
+	MaxInterval time.Duration
+
+	// MaxAttempts is the number of consecutive failed attempts after which
+	// unpacking is no longer retried until the bundle changes, as if it had
+	// failed with a permanent error. If zero, unpacking is retried until it
+	// succeeds or fails with a permanent error.
+	MaxAttempts int32
+}
+
+// DefaultBackoff is the backoff of bundle controllers that are not
+// configured with WithBackoff.
+var DefaultBackoff = Backoff{
+	InitialInterval: 10 * time.Second,
+	MaxInterval:     10 * time.Minute,
+}
+
+// delay returns the delay before the retry that follows the given number of
+// consecutive failed attempts.
+func (b Backoff) delay(attempts int32) time.Duration {
+	delay := b.InitialInterval
+	for i := int32(1); i < attempts && delay < b.MaxInterval; i++ {
+		delay *= 2
+	}
+	if delay > b.MaxInterval {
+		delay = b.MaxInterval
+	}
+	return delay
+}
+
+// exhausted returns true if no more attempts are retried after the given
+// number of consecutive failed attempts.
+func (b Backoff) exhausted(attempts int32) bool {
+	return b.MaxAttempts > 0 && attempts >= b.MaxAttempts
+}
+
+// ParseSourceBackoffs parses per-source backoffs from a comma-separated list
+// of "<source type>=<initial interval>/<max interval>[/<max attempts>]"
+// entries, e.g. "git=30s/30m/5,http=10s/5m". Sources whose failures are
+// mostly transient (e.g. registries that rate-limit pulls) can be retried
+// more patiently than the default, and sources whose failures rarely recover
+// can be given up on sooner.
+func ParseSourceBackoffs(value string) (map[rukpakv1alpha1.SourceType]Backoff, error) {
+	backoffs := map[rukpakv1alpha1.SourceType]Backoff{}
+	if strings.TrimSpace(value) == "" {
+		return backoffs, nil
+	}
+	for _, entry := range strings.Split(value, ",") {
+		sourceType, policy, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || sourceType == "" {
+			return nil, fmt.Errorf("invalid source backoff %q: expected <source type>=<initial interval>/<max interval>[/<max attempts>]", entry)
+		}
+		if _, ok := backoffs[rukpakv1alpha1.SourceType(sourceType)]; ok {
+			return nil, fmt.Errorf("invalid source backoff %q: duplicate source type %q", entry, sourceType)
+		}
+		fields := strings.Split(policy, "/")
+		if len(fields) != 2 && len(fields) != 3 {
+			return nil, fmt.Errorf("invalid source backoff %q: expected <source type>=<initial interval>/<max interval>[/<max attempts>]", entry)
+		}
+		var (
+			b   Backoff
+			err error
+		)
+		if b.InitialInterval, err = time.ParseDuration(fields[0]); err != nil || b.InitialInterval <= 0 {
+			return nil, fmt.Errorf("invalid source backoff %q: invalid initial interval %q", entry, fields[0])
+		}
+		if b.MaxInterval, err = time.ParseDuration(fields[1]); err != nil || b.MaxInterval < b.InitialInterval {
+			return nil, fmt.Errorf("invalid source backoff %q: invalid max interval %q", entry, fields[1])
+		}
+		if len(fields) == 3 {
+			attempts, err := strconv.ParseInt(fields[2], 10, 32)
+			if err != nil || attempts < 0 {
+				return nil, fmt.Errorf("invalid source backoff %q: invalid max attempts %q", entry, fields[2])
+			}
+			b.MaxAttempts = int32(attempts)
+		}
+		backoffs[rukpakv1alpha1.SourceType(sourceType)] = b
+	}
+	return backoffs, nil
+}
@@ -0,0 +1,62 @@
+package bundle
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+var _ = Describe("Backoff", func() {
+	backoff := Backoff{InitialInterval: 10 * time.Second, MaxInterval: time.Minute, MaxAttempts: 5}
+
+	DescribeTable("delay",
+		func(attempts int32, expected time.Duration) {
+			Expect(backoff.delay(attempts)).To(Equal(expected))
+		},
+		Entry("first attempt", int32(1), 10*time.Second),
+		Entry("second attempt", int32(2), 20*time.Second),
+		Entry("third attempt", int32(3), 40*time.Second),
+		Entry("capped at the maximum interval", int32(4), time.Minute),
+		Entry("many attempts", int32(1000), time.Minute),
+	)
+
+	DescribeTable("exhausted",
+		func(b Backoff, attempts int32, expected bool) {
+			Expect(b.exhausted(attempts)).To(Equal(expected))
+		},
+		Entry("below the maximum attempts", backoff, int32(4), false),
+		Entry("at the maximum attempts", backoff, int32(5), true),
+		Entry("above the maximum attempts", backoff, int32(6), true),
+		Entry("without a maximum", DefaultBackoff, int32(1000), false),
+	)
+
+	DescribeTable("ParseSourceBackoffs",
+		func(value string, expected map[rukpakv1alpha1.SourceType]Backoff, expectErr bool) {
+			backoffs, err := ParseSourceBackoffs(value)
+			if expectErr {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backoffs).To(Equal(expected))
+		},
+		Entry("empty", "", map[rukpakv1alpha1.SourceType]Backoff{}, false),
+		Entry("without max attempts", "git=30s/30m", map[rukpakv1alpha1.SourceType]Backoff{
+			rukpakv1alpha1.SourceTypeGit: {InitialInterval: 30 * time.Second, MaxInterval: 30 * time.Minute},
+		}, false),
+		Entry("several source types", "git=30s/30m/5, http=1s/1m/0", map[rukpakv1alpha1.SourceType]Backoff{
+			rukpakv1alpha1.SourceTypeGit:  {InitialInterval: 30 * time.Second, MaxInterval: 30 * time.Minute, MaxAttempts: 5},
+			rukpakv1alpha1.SourceTypeHTTP: {InitialInterval: time.Second, MaxInterval: time.Minute},
+		}, false),
+		Entry("without a source type", "=30s/30m", nil, true),
+		Entry("without a policy", "git", nil, true),
+		Entry("without a max interval", "git=30s", nil, true),
+		Entry("with an invalid initial interval", "git=soon/30m", nil, true),
+		Entry("with a max interval less than the initial interval", "git=30m/30s", nil, true),
+		Entry("with negative max attempts", "git=30s/30m/-1", nil, true),
+		Entry("with a duplicate source type", "git=30s/30m,git=1s/1m", nil, true),
+	)
+})
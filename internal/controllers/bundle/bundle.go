@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
@@ -58,6 +59,31 @@ func WithFinalizers(f crfinalizer.Finalizers) Option {
 	}
 }
 
+// WithUnpackPodTemplateConfigMap configures the name of the configmap, in the
+// system namespace, that holds the pod template of the image unpack pods, so
+// that bundles that are not unpacked yet are reconciled when it changes.
+func WithUnpackPodTemplateConfigMap(name string) Option {
+	return func(c *controller) {
+		c.unpackPodTemplateConfigMap = name
+	}
+}
+
+// WithBackoff configures how bundles that failed to unpack are retried.
+func WithBackoff(b Backoff) Option {
+	return func(c *controller) {
+		c.backoff = b
+	}
+}
+
+// WithSourceBackoffs configures how bundles of the given source types that
+// failed to unpack are retried, overriding the backoff that is configured
+// with WithBackoff for them.
+func WithSourceBackoffs(backoffs map[rukpakv1alpha1.SourceType]Backoff) Option {
+	return func(c *controller) {
+		c.sourceBackoffs = backoffs
+	}
+}
+
 func SetupWithManager(mgr manager.Manager, systemNsCache cache.Cache, systemNamespace string, opts ...Option) error {
 	c := &controller{
 		cl: mgr.GetClient(),
@@ -75,7 +101,7 @@ func SetupWithManager(mgr manager.Manager, systemNsCache cache.Cache, systemName
 
 	controllerName := fmt.Sprintf("controller.bundle.%s", c.provisionerID)
 	l := mgr.GetLogger().WithName(controllerName)
-	return ctrl.NewControllerManagedBy(mgr).
+	b := ctrl.NewControllerManagedBy(mgr).
 		Named(controllerName).
 		For(&rukpakv1alpha1.Bundle{}, builder.WithPredicates(
 			util.BundleProvisionerFilter(c.provisionerID),
@@ -85,13 +111,20 @@ func SetupWithManager(mgr manager.Manager, systemNsCache cache.Cache, systemName
 		// pods.
 		Watches(crsource.NewKindWithCache(&corev1.Pod{}, systemNsCache), util.MapOwneeToOwnerProvisionerHandler(context.Background(), mgr.GetClient(), l, c.provisionerID, &rukpakv1alpha1.Bundle{})).
 		Watches(crsource.NewKindWithCache(&corev1.ConfigMap{}, systemNsCache), util.MapConfigMapToBundlesHandler(context.Background(), mgr.GetClient(), systemNamespace, c.provisionerID)).
-		Complete(c)
+		Watches(crsource.NewKindWithCache(&corev1.Secret{}, systemNsCache), util.MapSecretToBundlesHandler(context.Background(), mgr.GetClient(), systemNamespace, c.provisionerID))
+	if c.unpackPodTemplateConfigMap != "" {
+		b = b.Watches(crsource.NewKindWithCache(&corev1.ConfigMap{}, systemNsCache), util.MapUnpackPodTemplateToBundlesHandler(context.Background(), mgr.GetClient(), systemNamespace, c.unpackPodTemplateConfigMap, c.provisionerID))
+	}
+	return b.Complete(c)
 }
 
 func (c *controller) setDefaults() {
 	if c.handler == nil {
 		c.handler = HandlerFunc(func(_ context.Context, fsys fs.FS, _ *rukpakv1alpha1.Bundle) (fs.FS, error) { return fsys, nil })
 	}
+	if c.backoff.InitialInterval <= 0 {
+		c.backoff = DefaultBackoff
+	}
 }
 
 func (c *controller) validateConfig() error {
@@ -111,6 +144,14 @@ func (c *controller) validateConfig() error {
 	if c.finalizers == nil {
 		errs = append(errs, errors.New("finalizer handler is unset"))
 	}
+	if c.backoff.MaxInterval < c.backoff.InitialInterval {
+		errs = append(errs, errors.New("backoff max interval is less than its initial interval"))
+	}
+	for sourceType, b := range c.sourceBackoffs {
+		if b.InitialInterval <= 0 || b.MaxInterval < b.InitialInterval {
+			errs = append(errs, fmt.Errorf("backoff of source type %q is invalid", sourceType))
+		}
+	}
 	return apimacherrors.NewAggregate(errs)
 }
 
@@ -122,6 +163,10 @@ type controller struct {
 	storage    storage.Storage
 	finalizers crfinalizer.Finalizers
 	unpacker   source.Unpacker
+
+	unpackPodTemplateConfigMap string
+	backoff                    Backoff
+	sourceBackoffs             map[rukpakv1alpha1.SourceType]Backoff
 }
 
 //+kubebuilder:rbac:groups=core.rukpak.io,resources=bundles,verbs=list;watch;update;patch
@@ -130,6 +175,7 @@ type controller struct {
 //+kubebuilder:rbac:verbs=get,urls=/bundles/*;/uploads/*
 //+kubebuilder:rbac:groups=core,resources=pods,verbs=list;watch;create;delete
 //+kubebuilder:rbac:groups=core,resources=configmaps,verbs=list;watch
+//+kubebuilder:rbac:groups=core,namespace=rukpak-system,resources=secrets,verbs=list;watch;create
 //+kubebuilder:rbac:groups=core,resources=pods/log,verbs=get
 //+kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
 //+kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
@@ -171,11 +217,8 @@ func (c *controller) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resu
 	return res, reconcileErr
 }
 
-// nolint:unparam
-// Today we always return ctrl.Result{} and an error.
-// But in the future we might update this function
-// to return different results (e.g. requeue).
 func (c *controller) reconcile(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (ctrl.Result, error) {
+	changed := bundle.Status.ObservedGeneration != bundle.Generation
 	bundle.Status.ObservedGeneration = bundle.Generation
 
 	finalizedBundle := bundle.DeepCopy()
@@ -183,6 +226,7 @@ func (c *controller) reconcile(ctx context.Context, bundle *rukpakv1alpha1.Bundl
 	if err != nil {
 		bundle.Status.ResolvedSource = nil
 		bundle.Status.ContentURL = ""
+		bundle.Status.ContentDigest = ""
 		bundle.Status.Phase = rukpakv1alpha1.PhaseFailing
 		meta.SetStatusCondition(&bundle.Status.Conditions, metav1.Condition{
 			Type:    rukpakv1alpha1.TypeUnpacked,
@@ -205,42 +249,145 @@ func (c *controller) reconcile(ctx context.Context, bundle *rukpakv1alpha1.Bundl
 		return ctrl.Result{}, nil
 	}
 
+	// Bundle specs are immutable, so once a bundle has been unpacked and its
+	// content is still available in storage, there is nothing left to do.
+	// Skipping the unpack here avoids re-cloning git repositories and
+	// re-downloading bundle content every time the bundle is resynced.
+	if c.isUnpacked(ctx, bundle) {
+		contentURL, err := c.storage.URLFor(ctx, bundle)
+		if err != nil {
+			return ctrl.Result{}, updateStatusUnpackFailing(&bundle.Status, fmt.Errorf("get content URL: %v", err))
+		}
+		bundle.Status.ContentURL = contentURL
+		return ctrl.Result{RequeueAfter: storage.URLRefreshAfter(c.storage, bundle)}, nil
+	}
+
+	if changed {
+		resetUnpackAttempts(&bundle.Status)
+	}
+	if retryAfter, retry := c.unpackRetry(bundle); !retry {
+		return ctrl.Result{RequeueAfter: retryAfter}, nil
+	}
+
 	unpackResult, err := c.unpacker.Unpack(ctx, bundle)
 	if err != nil {
-		return ctrl.Result{}, updateStatusUnpackFailing(&bundle.Status, fmt.Errorf("source bundle content: %v", err))
+		return c.unpackFailed(ctx, bundle, updateStatusUnpackFailing(&bundle.Status, fmt.Errorf("source bundle content: %w", err)))
 	}
 	switch unpackResult.State {
 	case source.StatePending:
 		updateStatusUnpackPending(&bundle.Status, unpackResult)
-		return ctrl.Result{}, nil
+		return ctrl.Result{RequeueAfter: unpackResult.RequeueAfter}, nil
 	case source.StateUnpacking:
 		updateStatusUnpacking(&bundle.Status, unpackResult)
-		return ctrl.Result{}, nil
+		return ctrl.Result{RequeueAfter: unpackResult.RequeueAfter}, nil
 	case source.StateUnpacked:
 		storeFS, err := c.handler.Handle(ctx, unpackResult.Bundle, bundle)
 		if err != nil {
-			return ctrl.Result{}, updateStatusUnpackFailing(&bundle.Status, err)
+			// Handlers deterministically convert the unpacked content, so
+			// content that they reject will not become valid.
+			return c.unpackFailed(ctx, bundle, updateStatusUnpackFailing(&bundle.Status, &source.PermanentError{Err: err}))
 		}
 
-		if err := c.storage.Store(ctx, bundle, storeFS); err != nil {
-			return ctrl.Result{}, updateStatusUnpackFailing(&bundle.Status, fmt.Errorf("persist bundle content: %v", err))
+		contentDigest, err := storage.StoreContent(ctx, c.storage, bundle, storeFS)
+		if err != nil {
+			return c.unpackFailed(ctx, bundle, updateStatusUnpackFailing(&bundle.Status, fmt.Errorf("persist bundle content: %v", err)))
 		}
 
 		contentURL, err := c.storage.URLFor(ctx, bundle)
 		if err != nil {
-			return ctrl.Result{}, updateStatusUnpackFailing(&bundle.Status, fmt.Errorf("get content URL: %v", err))
+			return c.unpackFailed(ctx, bundle, updateStatusUnpackFailing(&bundle.Status, fmt.Errorf("get content URL: %v", err)))
 		}
 
-		updateStatusUnpacked(&bundle.Status, unpackResult, contentURL)
-		return ctrl.Result{}, nil
+		updateStatusUnpacked(&bundle.Status, unpackResult, contentURL, contentDigest)
+		// Expiring content URLs are renewed before they expire.
+		return ctrl.Result{RequeueAfter: storage.URLRefreshAfter(c.storage, bundle)}, nil
 	default:
-		return ctrl.Result{}, updateStatusUnpackFailing(&bundle.Status, fmt.Errorf("unknown unpack state %q: %v", unpackResult.State, err))
+		return c.unpackFailed(ctx, bundle, updateStatusUnpackFailing(&bundle.Status, fmt.Errorf("unknown unpack state %q: %v", unpackResult.State, err)))
+	}
+}
+
+// unpackRetry returns whether a bundle that failed to unpack is retried now.
+// If it is retried later, it also returns the time until the next retry.
+// Bundles that failed with a permanent error are not retried until they
+// change.
+func (c *controller) unpackRetry(bundle *rukpakv1alpha1.Bundle) (time.Duration, bool) {
+	status := bundle.Status
+	if status.Phase != rukpakv1alpha1.PhaseFailing || status.UnpackAttempts == 0 {
+		return 0, true
+	}
+	if status.NextUnpackRetryTime == nil {
+		return 0, false
+	}
+	if retryAfter := time.Until(status.NextUnpackRetryTime.Time); retryAfter > 0 {
+		return retryAfter, false
+	}
+	return 0, true
+}
+
+// unpackFailed records a failed attempt to unpack the bundle, and schedules
+// the next attempt unless the error is permanent or the attempts are
+// exhausted. The error is logged rather than returned, so that the retries
+// follow the backoff of the controller rather than its rate limiter.
+func (c *controller) unpackFailed(ctx context.Context, bundle *rukpakv1alpha1.Bundle, err error) (ctrl.Result, error) {
+	now := metav1.Now()
+	status := &bundle.Status
+	status.UnpackAttempts++
+	status.LastUnpackAttemptTime = &now
+	status.NextUnpackRetryTime = nil
+
+	l := log.FromContext(ctx).WithValues("attempts", status.UnpackAttempts)
+	backoff := c.backoffFor(bundle)
+	if source.IsPermanent(err) || backoff.exhausted(status.UnpackAttempts) {
+		l.Error(err, "failed to unpack bundle, not retrying until the bundle changes")
+		return ctrl.Result{}, nil
+	}
+	retryAfter := backoff.delay(status.UnpackAttempts)
+	var unpackErr *source.UnpackError
+	if errors.As(err, &unpackErr) && unpackErr.RetryAfter > retryAfter {
+		// Retrying earlier would only observe the same failure again.
+		retryAfter = unpackErr.RetryAfter
 	}
+	nextRetry := metav1.NewTime(now.Add(retryAfter))
+	status.NextUnpackRetryTime = &nextRetry
+	l.Error(err, "failed to unpack bundle", "retryAfter", retryAfter)
+	return ctrl.Result{RequeueAfter: retryAfter}, nil
+}
+
+// backoffFor returns the backoff of the bundle's source type, or the backoff
+// of the controller if its source type has none.
+func (c *controller) backoffFor(bundle *rukpakv1alpha1.Bundle) Backoff {
+	if b, ok := c.sourceBackoffs[bundle.Spec.Source.Type]; ok {
+		return b
+	}
+	return c.backoff
+}
+
+// isUnpacked returns true when the bundle has already been unpacked and the
+// content that was stored for it is still in storage and matches the digest
+// that it was stored with. If the stored content is missing or was replaced,
+// the bundle needs to be unpacked again.
+func (c *controller) isUnpacked(ctx context.Context, bundle *rukpakv1alpha1.Bundle) bool {
+	if bundle.Status.Phase != rukpakv1alpha1.PhaseUnpacked || bundle.Status.ResolvedSource == nil {
+		return false
+	}
+	if err := storage.CheckContent(ctx, c.storage, bundle); err != nil {
+		log.FromContext(ctx).Info("stored bundle content is unavailable, unpacking bundle again", "error", err.Error())
+		return false
+	}
+	return true
+}
+
+func resetUnpackAttempts(status *rukpakv1alpha1.BundleStatus) {
+	status.UnpackAttempts = 0
+	status.LastUnpackAttemptTime = nil
+	status.NextUnpackRetryTime = nil
 }
 
 func updateStatusUnpackPending(status *rukpakv1alpha1.BundleStatus, result *source.Result) {
+	status.NextUnpackRetryTime = nil
 	status.ResolvedSource = nil
 	status.ContentURL = ""
+	status.ContentDigest = ""
 	status.Phase = rukpakv1alpha1.PhasePending
 	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
 		Type:    rukpakv1alpha1.TypeUnpacked,
@@ -251,8 +398,10 @@ func updateStatusUnpackPending(status *rukpakv1alpha1.BundleStatus, result *sour
 }
 
 func updateStatusUnpacking(status *rukpakv1alpha1.BundleStatus, result *source.Result) {
+	status.NextUnpackRetryTime = nil
 	status.ResolvedSource = nil
 	status.ContentURL = ""
+	status.ContentDigest = ""
 	status.Phase = rukpakv1alpha1.PhaseUnpacking
 	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
 		Type:    rukpakv1alpha1.TypeUnpacked,
@@ -262,9 +411,11 @@ func updateStatusUnpacking(status *rukpakv1alpha1.BundleStatus, result *source.R
 	})
 }
 
-func updateStatusUnpacked(status *rukpakv1alpha1.BundleStatus, result *source.Result, contentURL string) {
+func updateStatusUnpacked(status *rukpakv1alpha1.BundleStatus, result *source.Result, contentURL, contentDigest string) {
+	resetUnpackAttempts(status)
 	status.ResolvedSource = result.ResolvedSource
 	status.ContentURL = contentURL
+	status.ContentDigest = contentDigest
 	status.Phase = rukpakv1alpha1.PhaseUnpacked
 	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
 		Type:    rukpakv1alpha1.TypeUnpacked,
@@ -275,13 +426,20 @@ func updateStatusUnpacked(status *rukpakv1alpha1.BundleStatus, result *source.Re
 }
 
 func updateStatusUnpackFailing(status *rukpakv1alpha1.BundleStatus, err error) error {
+	reason := rukpakv1alpha1.ReasonUnpackFailed
+	var unpackErr *source.UnpackError
+	if errors.As(err, &unpackErr) {
+		reason = unpackErr.Reason
+	}
+
 	status.ResolvedSource = nil
 	status.ContentURL = ""
+	status.ContentDigest = ""
 	status.Phase = rukpakv1alpha1.PhaseFailing
 	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
 		Type:    rukpakv1alpha1.TypeUnpacked,
 		Status:  metav1.ConditionFalse,
-		Reason:  rukpakv1alpha1.ReasonUnpackFailed,
+		Reason:  reason,
 		Message: err.Error(),
 	})
 	return err
@@ -0,0 +1,157 @@
+package bundle
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	crfinalizer "sigs.k8s.io/controller-runtime/pkg/finalizer"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+	"github.com/operator-framework/rukpak/internal/source"
+	"github.com/operator-framework/rukpak/pkg/storage"
+)
+
+func TestBundleController(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Bundle Controller Suite")
+}
+
+type fakeUnpacker struct {
+	result *source.Result
+	err    error
+	calls  int
+}
+
+func (u *fakeUnpacker) Unpack(context.Context, *rukpakv1alpha1.Bundle) (*source.Result, error) {
+	u.calls++
+	return u.result, u.err
+}
+
+var _ = Describe("Bundle controller", func() {
+	var (
+		ctx      context.Context
+		store    *storage.LocalDirectory
+		unpacker *fakeUnpacker
+		c        *controller
+		bundle   *rukpakv1alpha1.Bundle
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		store = &storage.LocalDirectory{
+			RootDirectory: GinkgoT().TempDir(),
+			URL:           url.URL{Scheme: "https", Host: "core.rukpak-system.svc", Path: "/bundles/"},
+		}
+		unpacker = &fakeUnpacker{result: &source.Result{
+			State:          source.StateUnpacked,
+			Bundle:         fstest.MapFS{"manifests/cm.yaml": &fstest.MapFile{Data: []byte("kind: ConfigMap")}},
+			ResolvedSource: &rukpakv1alpha1.BundleSource{Type: rukpakv1alpha1.SourceTypeImage, Image: &rukpakv1alpha1.ImageSource{Ref: "quay.io/test/bundle@sha256:abc"}},
+		}}
+		c = &controller{
+			provisionerID: "core-rukpak-io-plain",
+			storage:       store,
+			unpacker:      unpacker,
+			finalizers:    crfinalizer.NewFinalizers(),
+		}
+		c.setDefaults()
+		bundle = &rukpakv1alpha1.Bundle{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-bundle", Generation: 1},
+			Spec: rukpakv1alpha1.BundleSpec{
+				ProvisionerClassName: "core-rukpak-io-plain",
+				Source:               rukpakv1alpha1.BundleSource{Type: rukpakv1alpha1.SourceTypeImage, Image: &rukpakv1alpha1.ImageSource{Ref: "quay.io/test/bundle:latest"}},
+			},
+		}
+
+		Expect(c.reconcile(ctx, bundle)).To(Equal(ctrl.Result{}))
+		Expect(unpacker.calls).To(Equal(1))
+		Expect(bundle.Status.Phase).To(Equal(rukpakv1alpha1.PhaseUnpacked))
+		Expect(bundle.Status.ContentURL).To(Equal("https://core.rukpak-system.svc/bundles/test-bundle.tgz"))
+		Expect(bundle.Status.ContentDigest).NotTo(BeEmpty())
+	})
+
+	It("should not unpack a bundle again while its content is stored", func() {
+		Expect(c.reconcile(ctx, bundle)).To(Equal(ctrl.Result{}))
+		Expect(unpacker.calls).To(Equal(1))
+		Expect(bundle.Status.Phase).To(Equal(rukpakv1alpha1.PhaseUnpacked))
+	})
+
+	It("should unpack a bundle again when its content is missing", func() {
+		Expect(store.Delete(ctx, bundle)).To(Succeed())
+
+		Expect(c.reconcile(ctx, bundle)).To(Equal(ctrl.Result{}))
+		Expect(unpacker.calls).To(Equal(2))
+		Expect(bundle.Status.Phase).To(Equal(rukpakv1alpha1.PhaseUnpacked))
+		Expect(storage.CheckContent(ctx, store, bundle)).To(Succeed())
+	})
+
+	It("should unpack a bundle again when its content was replaced", func() {
+		Expect(os.WriteFile(filepath.Join(store.RootDirectory, "test-bundle.tgz"), []byte("not a tar.gz"), 0600)).To(Succeed())
+
+		Expect(c.reconcile(ctx, bundle)).To(Equal(ctrl.Result{}))
+		Expect(unpacker.calls).To(Equal(2))
+		Expect(storage.CheckContent(ctx, store, bundle)).To(Succeed())
+	})
+
+	It("should not retry a failed bundle before its failed unpack pod is deleted", func() {
+		Expect(store.Delete(ctx, bundle)).To(Succeed())
+		unpacker.err = &source.UnpackError{Reason: rukpakv1alpha1.ReasonUnpackFailed, Err: errors.New("unpack failed"), RetryAfter: time.Hour}
+
+		result, err := c.reconcile(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(time.Hour))
+		Expect(bundle.Status.Phase).To(Equal(rukpakv1alpha1.PhaseFailing))
+		Expect(time.Until(bundle.Status.NextUnpackRetryTime.Time)).To(BeNumerically("~", time.Hour, time.Minute))
+	})
+
+	It("should retry a failed bundle with the backoff of its source type", func() {
+		c.sourceBackoffs = map[rukpakv1alpha1.SourceType]Backoff{
+			rukpakv1alpha1.SourceTypeImage: {InitialInterval: time.Minute, MaxInterval: time.Hour, MaxAttempts: 2},
+			rukpakv1alpha1.SourceTypeGit:   {InitialInterval: time.Second, MaxInterval: time.Second},
+		}
+		Expect(store.Delete(ctx, bundle)).To(Succeed())
+		unpacker.err = errors.New("unpack failed")
+
+		result, err := c.reconcile(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(time.Minute))
+
+		bundle.Status.NextUnpackRetryTime = timePtr(time.Now().Add(-time.Second))
+		result, err = c.reconcile(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(ctrl.Result{}))
+		Expect(bundle.Status.UnpackAttempts).To(Equal(int32(2)))
+		Expect(bundle.Status.NextUnpackRetryTime).To(BeNil())
+	})
+})
+
+var _ = DescribeTable("unpackRetry",
+	func(status rukpakv1alpha1.BundleStatus, retry bool, retryAfter time.Duration) {
+		c := &controller{}
+		after, ok := c.unpackRetry(&rukpakv1alpha1.Bundle{Status: status})
+		Expect(ok).To(Equal(retry))
+		Expect(after).To(BeNumerically("~", retryAfter, time.Second))
+	},
+	Entry("bundles that did not fail", rukpakv1alpha1.BundleStatus{Phase: rukpakv1alpha1.PhaseUnpacking}, true, time.Duration(0)),
+	Entry("bundles without failed attempts", rukpakv1alpha1.BundleStatus{Phase: rukpakv1alpha1.PhaseFailing}, true, time.Duration(0)),
+	Entry("bundles that failed permanently",
+		rukpakv1alpha1.BundleStatus{Phase: rukpakv1alpha1.PhaseFailing, UnpackAttempts: 1}, false, time.Duration(0)),
+	Entry("bundles before their next retry",
+		rukpakv1alpha1.BundleStatus{Phase: rukpakv1alpha1.PhaseFailing, UnpackAttempts: 1, NextUnpackRetryTime: timePtr(time.Now().Add(time.Minute))}, false, time.Minute),
+	Entry("bundles after their next retry",
+		rukpakv1alpha1.BundleStatus{Phase: rukpakv1alpha1.PhaseFailing, UnpackAttempts: 1, NextUnpackRetryTime: timePtr(time.Now().Add(-time.Minute))}, true, time.Duration(0)),
+)
+
+func timePtr(t time.Time) *metav1.Time {
+	mt := metav1.NewTime(t)
+	return &mt
+}
@@ -239,14 +239,20 @@ func (c *controller) reconcile(ctx context.Context, bd *rukpakv1alpha1.BundleDep
 
 	bundleFS, err := c.storage.Load(ctx, bundle)
 	if err != nil {
+		reason := rukpakv1alpha1.ReasonBundleLoadFailed
+		var mismatchErr *storage.DigestMismatchError
+		if errors.As(err, &mismatchErr) {
+			reason = rukpakv1alpha1.ReasonContentDigestMismatch
+		}
 		meta.SetStatusCondition(&bd.Status.Conditions, metav1.Condition{
 			Type:    rukpakv1alpha1.TypeHasValidBundle,
 			Status:  metav1.ConditionFalse,
-			Reason:  rukpakv1alpha1.ReasonBundleLoadFailed,
+			Reason:  reason,
 			Message: err.Error(),
 		})
 		return ctrl.Result{}, err
 	}
+	defer storage.CloseFS(bundleFS)
 
 	chrt, values, err := c.handler.Handle(ctx, bundleFS, bd)
 	if err != nil {
@@ -0,0 +1,24 @@
+package finalizer
+
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/finalizer"
+
+	"github.com/operator-framework/rukpak/internal/source"
+)
+
+var _ finalizer.Finalizer = &DeleteImageContent{}
+
+const DeleteImageContentKey = "core.rukpak.io/delete-image-content"
+
+// DeleteImageContent deletes the content that image unpack pods uploaded for
+// a bundle that is deleted before its content was unpacked.
+type DeleteImageContent struct {
+	ContentDir string
+}
+
+func (f DeleteImageContent) Finalize(_ context.Context, obj client.Object) (finalizer.Result, error) {
+	return finalizer.Result{}, source.DeleteImageContent(f.ContentDir, obj.GetUID())
+}
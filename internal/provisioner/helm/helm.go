@@ -1,13 +1,14 @@
 package helm
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"io/fs"
+	"strings"
 
-	"golang.org/x/sync/errgroup"
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/chartutil"
@@ -21,6 +22,9 @@ const (
 	ProvisionerID = "core-rukpak-io-helm"
 )
 
+// utf8BOM is the byte order mark that helm strips from the files of charts.
+var utf8BOM = []byte("\xEF\xBB\xBF")
+
 func HandleBundle(_ context.Context, fsys fs.FS, _ *rukpakv1alpha1.Bundle) (fs.FS, error) {
 	// Helm expects an FS whose root contains a single chart directory. Depending on how
 	// the bundle is sourced, the FS may or may not contain this single chart directory in
@@ -72,23 +76,45 @@ func loadValues(bd *rukpakv1alpha1.BundleDeployment) (chartutil.Values, error) {
 	return values, nil
 }
 
+// getChart loads the chart in the base directory of chartfs. The files are
+// read from chartfs directly, rather than from a tar.gz archive of it, so that
+// the chart is not held in memory a second time while it is loaded.
 func getChart(chartfs fs.FS) (*chart.Chart, error) {
-	pr, pw := io.Pipe()
-	var eg errgroup.Group
-	eg.Go(func() error {
-		return pw.CloseWithError(util.FSToTarGZ(pw, chartfs))
-	})
-
-	var chrt *chart.Chart
-	eg.Go(func() error {
-		var err error
-		chrt, err = loader.LoadArchive(pr)
+	var files []*loader.BufferedFile
+	if err := fs.WalkDir(chartfs, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		// Like helm archives, the FS contains the chart in its base directory,
+		// and file names are relative to it.
+		baseDir, name, ok := strings.Cut(path, "/")
+		if !ok {
+			if baseDir == chartutil.ChartfileName {
+				return errors.New("chart yaml not in base directory")
+			}
+			return fmt.Errorf("chart illegally contains content outside the base directory: %q", path)
+		}
+		data, err := fs.ReadFile(chartfs, path)
 		if err != nil {
 			return err
 		}
-		return chrt.Validate()
-	})
-	if err := eg.Wait(); err != nil {
+		files = append(files, &loader.BufferedFile{Name: name, Data: bytes.TrimPrefix(data, utf8BOM)})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no files in chart archive")
+	}
+
+	chrt, err := loader.LoadFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	if err := chrt.Validate(); err != nil {
 		return nil, err
 	}
 	return chrt, nil
@@ -2,12 +2,16 @@ package rukpakctl
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/sync/errgroup"
@@ -15,9 +19,22 @@ import (
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/rest"
 
+	"github.com/operator-framework/rukpak/internal/uploadmgr"
 	"github.com/operator-framework/rukpak/internal/util"
 )
 
+const (
+	// uploadChunkSize is the maximum size of the chunks in which bundle
+	// content is uploaded.
+	uploadChunkSize = 4 << 20
+
+	// uploadAttempts is the number of times an upload is attempted, or
+	// resumed after it was interrupted, before it fails.
+	uploadAttempts = 5
+
+	uploadRequestTimeout = time.Minute
+)
+
 // BundleUploader uploads bundle filesystems to rukpak's upload service.
 type BundleUploader struct {
 	UploadServiceName      string
@@ -34,6 +51,12 @@ type BundleUploader struct {
 // the uploader service to the local machine. Once the port has been forwarded, Upload
 // uploads the bundleFS as the content for the bundle named by the provided bundleName.
 //
+// The content is uploaded in chunks with the resumable upload protocol of the upload service,
+// along with its digest, which the upload service verifies. If the upload is interrupted, e.g.
+// because the port-forward is closed, Upload forwards a new port and resumes the upload from
+// where it stopped. Upload services that do not support the resumable protocol are sent the
+// content in a single request.
+//
 // Upload returns a boolean value indicating if the bundle content was modified on the server and
 // an error value that will convey any errors that occurred during the upload.
 //
@@ -41,10 +64,64 @@ type BundleUploader struct {
 // which means this function is idempotent. Running this function multiple times with the same input
 // does not result in any change to the cluster state after the initial upload.
 func (bu *BundleUploader) Upload(ctx context.Context, bundleName string, bundleFS fs.FS) (bool, error) {
-	pf, err := NewServicePortForwarder(bu.Cfg, types.NamespacedName{Namespace: bu.UploadServiceNamespace, Name: bu.UploadServiceName}, intstr.FromString("https"))
+	// The content is staged in a temporary file, so that its length and
+	// digest are known before it is uploaded, and so that an interrupted
+	// upload can be resumed from any offset.
+	content, err := os.CreateTemp("", "rukpakctl-upload-*.tgz")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(content.Name())
+	defer content.Close()
+
+	hasher := sha256.New()
+	if err := util.FSToTarGZ(io.MultiWriter(content, hasher), bundleFS); err != nil {
+		return false, fmt.Errorf("archive bundle content: %v", err)
+	}
+	info, err := content.Stat()
 	if err != nil {
 		return false, err
 	}
+	u := contentUpload{
+		bundleName: bundleName,
+		content:    content,
+		length:     info.Size(),
+		digest:     fmt.Sprintf("sha256:%x", hasher.Sum(nil)),
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= uploadAttempts; attempt++ {
+		var bundleModified bool
+		err := bu.withPortForward(ctx, func(ctx context.Context, httpClient *http.Client, localPort uint16) error {
+			var err error
+			bundleModified, err = u.upload(ctx, httpClient, bu.Cfg.BearerToken, localPort)
+			return err
+		})
+		if err == nil {
+			return bundleModified, nil
+		}
+		if statusErr := (*uploadStatusError)(nil); errors.As(err, &statusErr) && statusErr.permanent() || ctx.Err() != nil {
+			return false, err
+		}
+		lastErr = err
+
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+	return false, fmt.Errorf("upload failed after %d attempts: %v", uploadAttempts, lastErr)
+}
+
+// withPortForward forwards a local port to the upload service and calls fn
+// with an http client for the upload service and the local port. The port
+// is closed once fn returns.
+func (bu *BundleUploader) withPortForward(ctx context.Context, fn func(context.Context, *http.Client, uint16) error) error {
+	pf, err := NewServicePortForwarder(bu.Cfg, types.NamespacedName{Namespace: bu.UploadServiceNamespace, Name: bu.UploadServiceName}, intstr.FromString("https"))
+	if err != nil {
+		return err
+	}
 
 	// cancel is called by the upload goroutine before it returns, thus ensuring
 	// the port-forwarding goroutine exits, which allows the errgroup.Wait() call
@@ -55,25 +132,8 @@ func (bu *BundleUploader) Upload(ctx context.Context, bundleName string, bundleF
 	eg.Go(func() error {
 		return pf.Start(ctx)
 	})
-
-	// Create a pipe to conserve memory. We don't need to buffer the entire bundle
-	// tar.gz prior to sending it. To use a pipe, we start a writer goroutine and
-	// a reader goroutine such that the reader reads as soon as the writer writes.
-	// The reader continues reading until it receives io.EOF, so we need to close
-	// writer (triggering the io.EOF) as soon as we finish writing. We close the
-	// writer with `bundleWriter.CloseWithError` so that an error encountered
-	// writing the FS to a tar.gz stream can be processed by the reader.
-	bundleReader, bundleWriter := io.Pipe()
-	eg.Go(func() error {
-		return bundleWriter.CloseWithError(util.FSToTarGZ(bundleWriter, bundleFS))
-	})
-
-	var bundleModified bool
 	eg.Go(func() error {
-		defer func() {
-			cancel()
-			bundleWriter.Close()
-		}()
+		defer cancel()
 
 		// get the local port. this will wait until the port forwarder is ready.
 		localPort, err := pf.LocalPort(ctx)
@@ -81,11 +141,6 @@ func (bu *BundleUploader) Upload(ctx context.Context, bundleName string, bundleF
 			return err
 		}
 
-		req, err := http.NewRequestWithContext(ctx, http.MethodPut, proxyBundleURL(bundleName, localPort), bundleReader)
-		if err != nil {
-			return err
-		}
-
 		transport := http.DefaultTransport.(*http.Transport).Clone()
 		transport.TLSClientConfig, err = rest.TLSConfigFor(bu.Cfg)
 		if err != nil {
@@ -94,42 +149,147 @@ func (bu *BundleUploader) Upload(ctx context.Context, bundleName string, bundleF
 		if bu.RootCAs != nil {
 			transport.TLSClientConfig.RootCAs = bu.RootCAs
 		}
-		if bu.Cfg.BearerToken != "" {
-			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", bu.Cfg.BearerToken))
+		httpClient := &http.Client{
+			Transport: transport,
+			Timeout:   uploadRequestTimeout,
 		}
+		return fn(ctx, httpClient, localPort)
+	})
+	if err := eg.Wait(); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
 
-		httpClient := http.Client{
-			Transport: transport,
-			Timeout:   10 * time.Second,
+// contentUpload is the content of a bundle that is uploaded.
+type contentUpload struct {
+	bundleName string
+	content    io.ReaderAt
+	length     int64
+	digest     string
+}
+
+// upload uploads the content in chunks, starting at the offset at which the
+// upload service continues the upload, and returns whether the bundle content
+// was modified.
+func (u *contentUpload) upload(ctx context.Context, httpClient *http.Client, bearerToken string, localPort uint16) (bool, error) {
+	bundleURL := proxyBundleURL(u.bundleName, localPort)
+	newRequest := func(method, url string, body io.Reader) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, method, url, body)
+		if err != nil {
+			return nil, err
+		}
+		if bearerToken != "" {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", bearerToken))
+		}
+		req.Header.Set(uploadmgr.DigestHeader, u.digest)
+		return req, nil
+	}
+
+	req, err := newRequest(http.MethodHead, bundleURL+"/partial", nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return false, nil
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		// The upload service does not support the resumable upload protocol.
+		req, err := newRequest(http.MethodPut, bundleURL, io.NewSectionReader(u.content, 0, u.length))
+		if err != nil {
+			return false, err
 		}
+		return doUploadRequest(httpClient, req)
+	default:
+		return false, newUploadStatusError(resp)
+	}
 
+	offset, err := strconv.ParseInt(resp.Header.Get(uploadmgr.OffsetHeader), 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("invalid upload offset: %v", err)
+	}
+	for {
+		chunkSize := u.length - offset
+		if chunkSize > uploadChunkSize {
+			chunkSize = uploadChunkSize
+		}
+		req, err := newRequest(http.MethodPatch, bundleURL+"/partial", io.NewSectionReader(u.content, offset, chunkSize))
+		if err != nil {
+			return false, err
+		}
+		req.Header.Set(uploadmgr.OffsetHeader, strconv.FormatInt(offset, 10))
+		req.Header.Set(uploadmgr.LengthHeader, strconv.FormatInt(u.length, 10))
 		resp, err := httpClient.Do(req)
 		if err != nil {
-			return err
+			return false, err
 		}
-		defer resp.Body.Close()
+		resp.Body.Close()
 
 		switch resp.StatusCode {
 		case http.StatusCreated:
-			bundleModified = true
+			return true, nil
 		case http.StatusNoContent:
-			bundleModified = false
-		default:
-			body, err := io.ReadAll(resp.Body)
+			return false, nil
+		case http.StatusAccepted, http.StatusConflict:
+			// The chunk was accepted, or it was not at the offset at which
+			// the upload continues, e.g. because the response to a previous
+			// chunk was lost. In either case, the upload continues from the
+			// returned offset.
+			next, err := strconv.ParseInt(resp.Header.Get(uploadmgr.OffsetHeader), 10, 64)
 			if err != nil {
-				return err
+				return false, newUploadStatusError(resp)
 			}
-			if len(string(body)) > 0 {
-				return errors.New(string(body))
-			}
-			return fmt.Errorf("unexpected response %q", resp.Status)
+			offset = next
+		default:
+			return false, newUploadStatusError(resp)
 		}
-		return nil
-	})
-	if err := eg.Wait(); err != nil && !errors.Is(err, context.Canceled) {
+	}
+}
+
+func doUploadRequest(httpClient *http.Client, req *http.Request) (bool, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
 		return false, err
 	}
-	return bundleModified, nil
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return true, nil
+	case http.StatusNoContent:
+		return false, nil
+	default:
+		return false, newUploadStatusError(resp)
+	}
+}
+
+// uploadStatusError is an unexpected response of the upload service.
+type uploadStatusError struct {
+	statusCode int
+	message    string
+}
+
+func newUploadStatusError(resp *http.Response) *uploadStatusError {
+	message := fmt.Sprintf("unexpected response %q", resp.Status)
+	if body, err := io.ReadAll(resp.Body); err == nil && len(body) > 0 {
+		message = strings.TrimSpace(string(body))
+	}
+	return &uploadStatusError{statusCode: resp.StatusCode, message: message}
+}
+
+func (e *uploadStatusError) Error() string {
+	return e.message
+}
+
+// permanent returns true if resuming the upload cannot resolve the error.
+func (e *uploadStatusError) permanent() bool {
+	return e.statusCode >= 400 && e.statusCode < 500 && e.statusCode != http.StatusRequestTimeout && e.statusCode != http.StatusTooManyRequests
 }
 
 func proxyBundleURL(bundleName string, port uint16) string {
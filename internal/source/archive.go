@@ -0,0 +1,117 @@
+package source
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"testing/fstest"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/nlepage/go-tarfs"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+// yamlManifestPath is the path within the bundle filesystem at which a
+// single YAML manifest is unpacked, so that it forms a plain bundle.
+const yamlManifestPath = "manifests/manifest.yaml"
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagic  = []byte("PK\x03\x04")
+	// tarMagic is the magic of POSIX (ustar) and GNU tar headers, which is
+	// found at offset 257 of the first header.
+	tarMagic       = []byte("ustar")
+	tarMagicOffset = 257
+)
+
+// archiveFS returns the filesystem of the bundle contents read from r. If
+// format is empty, the format is detected from the leading bytes of the
+// contents and, failing that, from the given Content-Type.
+func archiveFS(r io.Reader, format rukpakv1alpha1.ArchiveFormat, contentType string) (fs.FS, error) {
+	br := bufio.NewReader(r)
+	if format == "" {
+		var err error
+		format, err = detectArchiveFormat(br, contentType)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch format {
+	case rukpakv1alpha1.ArchiveFormatTarGz:
+		gzr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("read bundle contents as gzip: %v", err)
+		}
+		return newTarFS(gzr)
+	case rukpakv1alpha1.ArchiveFormatTar:
+		return newTarFS(br)
+	case rukpakv1alpha1.ArchiveFormatTarZstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("read bundle contents as zstd: %v", err)
+		}
+		defer zr.Close()
+		return newTarFS(zr)
+	case rukpakv1alpha1.ArchiveFormatZip:
+		// Reading a zip archive requires random access to its central
+		// directory, which is at the end of the archive.
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return nil, fmt.Errorf("read bundle contents: %v", err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("read bundle contents as zip: %v", err)
+		}
+		return zr, nil
+	case rukpakv1alpha1.ArchiveFormatYAML:
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return nil, fmt.Errorf("read bundle contents: %v", err)
+		}
+		return fstest.MapFS{yamlManifestPath: &fstest.MapFile{Data: data, Mode: 0644}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported bundle contents format %q", format)
+	}
+}
+
+func newTarFS(r io.Reader) (fs.FS, error) {
+	bundleFS, err := tarfs.New(r)
+	if err != nil {
+		return nil, fmt.Errorf("untar bundle contents: %v", err)
+	}
+	return bundleFS, nil
+}
+
+// detectArchiveFormat detects the format of the contents read by br, without
+// consuming them.
+func detectArchiveFormat(br *bufio.Reader, contentType string) (rukpakv1alpha1.ArchiveFormat, error) {
+	// Peek returns fewer bytes along with an error if the contents are
+	// shorter than requested, which is fine for detection purposes.
+	head, _ := br.Peek(tarMagicOffset + len(tarMagic))
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return rukpakv1alpha1.ArchiveFormatTarGz, nil
+	case bytes.HasPrefix(head, zstdMagic):
+		return rukpakv1alpha1.ArchiveFormatTarZstd, nil
+	case bytes.HasPrefix(head, zipMagic):
+		return rukpakv1alpha1.ArchiveFormatZip, nil
+	case len(head) >= tarMagicOffset+len(tarMagic) && bytes.Equal(head[tarMagicOffset:], tarMagic):
+		return rukpakv1alpha1.ArchiveFormatTar, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return rukpakv1alpha1.ArchiveFormatYAML, nil
+	}
+	return "", fmt.Errorf("unable to detect the format of the bundle contents (content type %q): set the format explicitly", contentType)
+}
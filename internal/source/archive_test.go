@@ -0,0 +1,81 @@
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io/fs"
+
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+var _ = Describe("archiveFS", func() {
+	const manifest = "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: test\n"
+	files := map[string]string{"manifests/configmap.yaml": manifest}
+
+	DescribeTable("should unpack bundle contents",
+		func(content func() []byte, format rukpakv1alpha1.ArchiveFormat, contentType, path string) {
+			bundleFS, err := archiveFS(bytes.NewReader(content()), format, contentType)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := fs.ReadFile(bundleFS, path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(manifest))
+		},
+		Entry("detected tar.gz", func() []byte { return tarGZ(files) }, rukpakv1alpha1.ArchiveFormat(""), "", "manifests/configmap.yaml"),
+		Entry("detected tar", func() []byte { return tarBytes(files) }, rukpakv1alpha1.ArchiveFormat(""), "", "manifests/configmap.yaml"),
+		Entry("detected tar.zst", func() []byte { return tarZstd(files) }, rukpakv1alpha1.ArchiveFormat(""), "", "manifests/configmap.yaml"),
+		Entry("detected zip", func() []byte { return zipBytes(files) }, rukpakv1alpha1.ArchiveFormat(""), "", "manifests/configmap.yaml"),
+		Entry("yaml detected from content type", func() []byte { return []byte(manifest) }, rukpakv1alpha1.ArchiveFormat(""), "application/yaml; charset=utf-8", yamlManifestPath),
+		Entry("explicit yaml", func() []byte { return []byte(manifest) }, rukpakv1alpha1.ArchiveFormatYAML, "text/plain", yamlManifestPath),
+		Entry("explicit zip", func() []byte { return zipBytes(files) }, rukpakv1alpha1.ArchiveFormatZip, "application/octet-stream", "manifests/configmap.yaml"),
+	)
+
+	It("should fail when the format cannot be detected", func() {
+		_, err := archiveFS(bytes.NewReader([]byte(manifest)), "", "text/plain")
+		Expect(err).To(MatchError(ContainSubstring("unable to detect the format")))
+	})
+
+	It("should fail when the contents do not match the explicit format", func() {
+		_, err := archiveFS(bytes.NewReader(zipBytes(files)), rukpakv1alpha1.ArchiveFormatTarGz, "")
+		Expect(err).To(MatchError(ContainSubstring("read bundle contents as gzip")))
+	})
+})
+
+func tarBytes(files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for path, content := range files {
+		Expect(tw.WriteHeader(&tar.Header{Name: path, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg, Format: tar.FormatPAX})).To(Succeed())
+		_, err := tw.Write([]byte(content))
+		Expect(err).NotTo(HaveOccurred())
+	}
+	Expect(tw.Close()).To(Succeed())
+	return buf.Bytes()
+}
+
+func tarZstd(files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	zw, err := zstd.NewWriter(buf)
+	Expect(err).NotTo(HaveOccurred())
+	_, err = zw.Write(tarBytes(files))
+	Expect(err).NotTo(HaveOccurred())
+	Expect(zw.Close()).To(Succeed())
+	return buf.Bytes()
+}
+
+func zipBytes(files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for path, content := range files {
+		w, err := zw.Create(path)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = w.Write([]byte(content))
+		Expect(err).NotTo(HaveOccurred())
+	}
+	Expect(zw.Close()).To(Succeed())
+	return buf.Bytes()
+}
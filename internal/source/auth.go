@@ -0,0 +1,163 @@
+package source
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+// certificateAuthorityKey is the key of the configmap or secret referenced by
+// a certificate authority source that holds the PEM-encoded certificates.
+const certificateAuthorityKey = "ca.crt"
+
+// getTLSConfig returns the TLS configuration for connecting to the server of
+// a bundle source with the given authorization. The server's certificate is
+// verified with rootCAs and the certificate authorities referenced by the
+// authorization. If rootCAs is nil, the system certificate authorities are
+// used.
+func getTLSConfig(ctx context.Context, cl client.Reader, namespace string, auth rukpakv1alpha1.Authorization, rootCAs *x509.CertPool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		RootCAs:            rootCAs,
+		InsecureSkipVerify: auth.InsecureSkipVerify, // nolint:gosec
+	}
+
+	if caSource := auth.CertificateAuthority; caSource != nil {
+		caData, err := getCertificateAuthorityData(ctx, cl, namespace, *caSource)
+		if err != nil {
+			return nil, err
+		}
+		if rootCAs != nil {
+			tlsConfig.RootCAs = rootCAs.Clone()
+		} else if tlsConfig.RootCAs, err = x509.SystemCertPool(); err != nil {
+			return nil, fmt.Errorf("load system certificate authorities: %v", err)
+		}
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no certificates found in certificate authority %q", certificateAuthorityKey)
+		}
+	}
+
+	if auth.ClientCertificate != nil {
+		secret := &corev1.Secret{}
+		if err := cl.Get(ctx, client.ObjectKey{Namespace: namespace, Name: auth.ClientCertificate.Name}, secret); err != nil {
+			return nil, fmt.Errorf("get client certificate secret %s/%s: %v", namespace, auth.ClientCertificate.Name, err)
+		}
+		cert, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+		if err != nil {
+			return nil, fmt.Errorf("parse client certificate secret %s/%s: %v", namespace, auth.ClientCertificate.Name, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func getCertificateAuthorityData(ctx context.Context, cl client.Reader, namespace string, caSource rukpakv1alpha1.CertificateAuthoritySource) ([]byte, error) {
+	switch {
+	case caSource.ConfigMap != nil:
+		cm := &corev1.ConfigMap{}
+		if err := cl.Get(ctx, client.ObjectKey{Namespace: namespace, Name: caSource.ConfigMap.Name}, cm); err != nil {
+			return nil, fmt.Errorf("get certificate authority configmap %s/%s: %v", namespace, caSource.ConfigMap.Name, err)
+		}
+		if data, ok := cm.Data[certificateAuthorityKey]; ok {
+			return []byte(data), nil
+		}
+		return cm.BinaryData[certificateAuthorityKey], nil
+	case caSource.Secret != nil:
+		secret := &corev1.Secret{}
+		if err := cl.Get(ctx, client.ObjectKey{Namespace: namespace, Name: caSource.Secret.Name}, secret); err != nil {
+			return nil, fmt.Errorf("get certificate authority secret %s/%s: %v", namespace, caSource.Secret.Name, err)
+		}
+		return secret.Data[certificateAuthorityKey], nil
+	}
+	return nil, fmt.Errorf("certificate authority configmap or secret must be set")
+}
+
+// authorizeRequest adds the basic authentication credentials, bearer token
+// and custom headers configured by auth to req.
+func authorizeRequest(ctx context.Context, cl client.Reader, namespace string, auth rukpakv1alpha1.Authorization, req *http.Request) error {
+	if auth.Secret.Name != "" {
+		secret := &corev1.Secret{}
+		if err := cl.Get(ctx, client.ObjectKey{Namespace: namespace, Name: auth.Secret.Name}, secret); err != nil {
+			return err
+		}
+		req.SetBasicAuth(string(secret.Data["username"]), string(secret.Data["password"]))
+	}
+	header, err := getAuthHeaders(ctx, cl, namespace, auth)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return nil
+}
+
+// authRedirectPolicy returns the redirect policy of the http clients that
+// send requests authorized by auth. It removes the credentials and custom
+// headers of auth from requests that are redirected to another host, which
+// http clients do only for the Authorization and Cookie headers, so that
+// secret header values are not sent to the targets of redirects. Like the
+// default policy, it stops after 10 redirects.
+func authRedirectPolicy(auth rukpakv1alpha1.Authorization) func(*http.Request, []*http.Request) error {
+	headers := []string{"Authorization"}
+	for _, h := range auth.Headers {
+		headers = append(headers, h.Name)
+	}
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if !sameHost(via[0].URL.String(), req.URL) {
+			for _, k := range headers {
+				req.Header.Del(k)
+			}
+		}
+		return nil
+	}
+}
+
+// getAuthHeaders returns the headers that are sent with each request to the
+// server of a bundle source with the given authorization, i.e. the bearer
+// token and the custom headers. Basic authentication credentials are not
+// included.
+func getAuthHeaders(ctx context.Context, cl client.Reader, namespace string, auth rukpakv1alpha1.Authorization) (http.Header, error) {
+	header := http.Header{}
+	for _, h := range auth.Headers {
+		value := h.Value
+		if h.SecretKeyRef != nil {
+			var err error
+			if value, err = getSecretKey(ctx, cl, namespace, *h.SecretKeyRef); err != nil {
+				return nil, fmt.Errorf("get value of header %q: %v", h.Name, err)
+			}
+		}
+		header.Add(h.Name, value)
+	}
+	if auth.BearerToken != nil {
+		token, err := getSecretKey(ctx, cl, namespace, *auth.BearerToken)
+		if err != nil {
+			return nil, fmt.Errorf("get bearer token: %v", err)
+		}
+		header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+	return header, nil
+}
+
+func getSecretKey(ctx context.Context, cl client.Reader, namespace string, selector corev1.SecretKeySelector) (string, error) {
+	secret := &corev1.Secret{}
+	if err := cl.Get(ctx, client.ObjectKey{Namespace: namespace, Name: selector.Name}, secret); err != nil {
+		return "", fmt.Errorf("get secret %s/%s: %v", namespace, selector.Name, err)
+	}
+	value, ok := secret.Data[selector.Key]
+	if !ok {
+		return "", fmt.Errorf("secret %s/%s does not contain key %q", namespace, selector.Name, selector.Key)
+	}
+	return string(value), nil
+}
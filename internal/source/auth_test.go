@@ -0,0 +1,174 @@
+package source
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+var _ = Describe("HTTP authorization", func() {
+	var (
+		ctx    context.Context
+		server *httptest.Server
+		source *HTTP
+		bundle *rukpakv1alpha1.Bundle
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		content := tarGZ(map[string]string{"manifests/configmap.yaml": "kind: ConfigMap\n"})
+
+		clientCA, clientCAKey := newCA("client-ca")
+		clientCertPEM, clientKeyPEM := newCert(clientCA, clientCAKey, "client")
+		clientCAs := x509.NewCertPool()
+		clientCAs.AddCert(clientCA)
+
+		server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer secret-token" || r.Header.Get("X-Api-Key") != "secret-key" || r.Header.Get("X-Team") != "rukpak" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write(content)
+		}))
+		server.TLS = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  clientCAs,
+		}
+		server.StartTLS()
+		serverCAPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+		clientCertSecret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "client-cert", Namespace: "rukpak-system"},
+			Type:       corev1.SecretTypeTLS,
+			Data:       map[string][]byte{corev1.TLSCertKey: clientCertPEM, corev1.TLSPrivateKeyKey: clientKeyPEM},
+		}
+		credentialsSecret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "credentials", Namespace: "rukpak-system"},
+			Data:       map[string][]byte{"token": []byte("secret-token"), "api-key": []byte("secret-key")},
+		}
+		caConfigMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "server-ca", Namespace: "rukpak-system"},
+			Data:       map[string]string{certificateAuthorityKey: string(serverCAPEM)},
+		}
+		cl := fake.NewClientBuilder().WithObjects(caConfigMap, clientCertSecret, credentialsSecret).Build()
+
+		source = &HTTP{Reader: cl, SecretNamespace: "rukpak-system"}
+		bundle = &rukpakv1alpha1.Bundle{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-bundle"},
+			Spec: rukpakv1alpha1.BundleSpec{
+				Source: rukpakv1alpha1.BundleSource{
+					Type: rukpakv1alpha1.SourceTypeHTTP,
+					HTTP: &rukpakv1alpha1.HTTPSource{
+						URL: server.URL + "/bundle.tgz",
+						Auth: rukpakv1alpha1.Authorization{
+							CertificateAuthority: &rukpakv1alpha1.CertificateAuthoritySource{
+								ConfigMap: &corev1.LocalObjectReference{Name: "server-ca"},
+							},
+							BearerToken: &corev1.SecretKeySelector{
+								LocalObjectReference: corev1.LocalObjectReference{Name: "credentials"},
+								Key:                  "token",
+							},
+							Headers: []rukpakv1alpha1.HTTPHeader{
+								{Name: "X-Team", Value: "rukpak"},
+								{Name: "X-Api-Key", SecretKeyRef: &corev1.SecretKeySelector{
+									LocalObjectReference: corev1.LocalObjectReference{Name: "credentials"},
+									Key:                  "api-key",
+								}},
+							},
+							ClientCertificate: &corev1.LocalObjectReference{Name: "client-cert"},
+						},
+					},
+				},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should unpack content using the configured certificate authority, credentials and client certificate", func() {
+		result, err := source.Unpack(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.State).To(Equal(StateUnpacked))
+	})
+
+	It("should fail to verify the server without the certificate authority", func() {
+		bundle.Spec.Source.HTTP.Auth.CertificateAuthority = nil
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring("certificate signed by unknown authority")))
+	})
+
+	It("should fail without the client certificate", func() {
+		bundle.Spec.Source.HTTP.Auth.ClientCertificate = nil
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring("http request for bundle content failed")))
+	})
+
+	It("should fail without the bearer token", func() {
+		bundle.Spec.Source.HTTP.Auth.BearerToken = nil
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring("401 Unauthorized")))
+	})
+
+	It("should fail when a header secret key is missing", func() {
+		bundle.Spec.Source.HTTP.Auth.Headers[1].SecretKeyRef.Key = "missing"
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring(`get value of header "X-Api-Key"`)))
+	})
+})
+
+func newCA(commonName string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	Expect(err).NotTo(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	return cert, key
+}
+
+func newCert(ca *x509.Certificate, caKey *ecdsa.PrivateKey, commonName string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, key.Public(), caKey)
+	Expect(err).NotTo(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
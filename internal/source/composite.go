@@ -0,0 +1,231 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing/fstest"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+// compositeLayersTTL is how long the unpacked layers of a bundle are kept
+// while its other layers are not unpacked, e.g. because the bundle was
+// deleted before it was unpacked.
+const compositeLayersTTL = time.Hour
+
+// Composite unpacks bundles whose content is layered from multiple sources.
+// Each source is unpacked by the unpacker of its type in Sources, and their
+// contents are merged, in order, into a single filesystem.
+type Composite struct {
+	Sources map[rukpakv1alpha1.SourceType]Unpacker
+
+	mu sync.Mutex
+	// layers are the results of the layers that were unpacked for bundles
+	// whose other layers are not unpacked yet, by bundle UID. Bundle specs
+	// are immutable, so the layers of a bundle never change. Unpacked layers
+	// are not unpacked again, because sources may not be able to (e.g. image
+	// sources consume the content uploaded by their unpack pods), and to
+	// avoid fetching their content again.
+	layers map[types.UID]*compositeLayers
+}
+
+type compositeLayers struct {
+	results  map[int]*Result
+	lastUsed time.Time
+}
+
+// unpackedLayers returns the layers of the bundle that were unpacked before,
+// and removes the layers of other bundles that have not been used within
+// compositeLayersTTL.
+func (c *Composite) unpackedLayers(uid types.UID) *compositeLayers {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.layers == nil {
+		c.layers = map[types.UID]*compositeLayers{}
+	}
+	for otherUID, layers := range c.layers {
+		if otherUID != uid && time.Since(layers.lastUsed) > compositeLayersTTL {
+			delete(c.layers, otherUID)
+		}
+	}
+	layers, ok := c.layers[uid]
+	if !ok {
+		layers = &compositeLayers{results: map[int]*Result{}}
+		c.layers[uid] = layers
+	}
+	layers.lastUsed = time.Now()
+	return layers
+}
+
+func (c *Composite) forgetLayers(uid types.UID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.layers, uid)
+}
+
+func (c *Composite) Unpack(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (*Result, error) {
+	if bundle.Spec.Source.Type != rukpakv1alpha1.SourceTypeComposite {
+		return nil, fmt.Errorf("bundle source type %q not supported", bundle.Spec.Source.Type)
+	}
+	if bundle.Spec.Source.Composite == nil {
+		return nil, fmt.Errorf("bundle source composite configuration is unset")
+	}
+	composite := bundle.Spec.Source.Composite
+
+	// Every source that is not unpacked yet is unpacked on each call, rather
+	// than one at a time, so that asynchronous sources make progress
+	// concurrently.
+	layers := c.unpackedLayers(bundle.UID)
+	results := make([]*Result, 0, len(composite.Sources))
+	var (
+		state        = StateUnpacked
+		pending      []string
+		requeueAfter time.Duration
+	)
+	for i, layer := range composite.Sources {
+		if layer.Type == rukpakv1alpha1.SourceTypeComposite || layer.Type == rukpakv1alpha1.SourceTypeUpload {
+			// This should never happen because the validation webhook rejects these source types
+			return nil, fmt.Errorf("unpack sources[%d]: source type %q cannot be layered", i, layer.Type)
+		}
+		unpacker, ok := c.Sources[layer.Type]
+		if !ok {
+			return nil, fmt.Errorf("unpack sources[%d]: source type %q not supported", i, layer.Type)
+		}
+		c.mu.Lock()
+		result, ok := layers.results[i]
+		c.mu.Unlock()
+		if !ok {
+			// The layer is unpacked as the bundle itself, so that the objects
+			// that asynchronous sources create for it are owned by the bundle.
+			layerBundle := bundle.DeepCopy()
+			layerBundle.Spec.Source = layer.BundleSource()
+			var err error
+			if result, err = unpacker.Unpack(ctx, layerBundle); err != nil {
+				return nil, fmt.Errorf("unpack sources[%d]: %w", i, err)
+			}
+		}
+		switch result.State {
+		case StateUnpacked:
+			c.mu.Lock()
+			layers.results[i] = result
+			c.mu.Unlock()
+		case StateUnpacking:
+			state = StateUnpacking
+			pending = append(pending, fmt.Sprintf("sources[%d]: %s", i, result.Message))
+		default:
+			if state != StateUnpacking {
+				state = StatePending
+			}
+			pending = append(pending, fmt.Sprintf("sources[%d]: %s", i, result.Message))
+		}
+		if result.State != StateUnpacked && result.RequeueAfter > 0 && (requeueAfter == 0 || result.RequeueAfter < requeueAfter) {
+			requeueAfter = result.RequeueAfter
+		}
+		results = append(results, result)
+	}
+	if state != StateUnpacked {
+		return &Result{State: state, Message: strings.Join(pending, "; "), RequeueAfter: requeueAfter}, nil
+	}
+
+	c.forgetLayers(bundle.UID)
+	bundleFS, err := mergeLayers(composite, results)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvedComposite := &rukpakv1alpha1.CompositeSource{ConflictPolicy: composite.ConflictPolicy}
+	for i, result := range results {
+		resolvedComposite.Sources = append(resolvedComposite.Sources, compositeLayer(composite.Sources[i].Path, result.ResolvedSource))
+	}
+	resolvedSource := &rukpakv1alpha1.BundleSource{
+		Type:      rukpakv1alpha1.SourceTypeComposite,
+		Composite: resolvedComposite,
+	}
+
+	message := generateMessage("composite")
+	return &Result{Bundle: bundleFS, ResolvedSource: resolvedSource, State: StateUnpacked, Message: message}, nil
+}
+
+// mergeLayers merges the unpacked contents of the sources of a composite
+// source, in order, into a single filesystem, at the paths of the sources.
+func mergeLayers(composite *rukpakv1alpha1.CompositeSource, results []*Result) (fs.FS, error) {
+	bundleFS := fstest.MapFS{}
+	providers := map[string]int{}
+	for i, result := range results {
+		dir := filepath.ToSlash(filepath.Clean(composite.Sources[i].Path))
+		err := fs.WalkDir(result.Bundle, ".", func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			name := path.Join(dir, p)
+			if name == "." {
+				return nil
+			}
+			existing, exists := bundleFS[name]
+			if exists && existing.Mode.IsDir() != d.IsDir() {
+				return fmt.Errorf("path %q is a file in one of sources[%d] and sources[%d], and a directory in the other", name, providers[name], i)
+			}
+			if d.IsDir() {
+				if !exists {
+					bundleFS[name] = &fstest.MapFile{Mode: fs.ModeDir | 0755}
+					providers[name] = i
+				}
+				return nil
+			}
+			if exists && composite.ConflictPolicy != rukpakv1alpha1.CompositeConflictPolicyOverride {
+				return fmt.Errorf("path %q is provided by both sources[%d] and sources[%d]", name, providers[name], i)
+			}
+			data, err := fs.ReadFile(result.Bundle, p)
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			bundleFS[name] = &fstest.MapFile{Data: data, Mode: info.Mode().Perm()}
+			providers[name] = i
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("merge sources[%d]: %v", i, err)
+		}
+	}
+
+	// The directories that contain the paths of the sources are not walked,
+	// so check that none of them is a file of another source.
+	for name := range bundleFS {
+		for parent := path.Dir(name); parent != "."; parent = path.Dir(parent) {
+			if f, ok := bundleFS[parent]; ok && !f.Mode.IsDir() {
+				return nil, fmt.Errorf("merge sources[%d]: path %q is a file in sources[%d] and a directory in sources[%d]", providers[name], parent, providers[parent], providers[name])
+			}
+		}
+	}
+	return bundleFS, nil
+}
+
+// compositeLayer returns the layer of a composite source with the given path
+// and source.
+func compositeLayer(path string, source *rukpakv1alpha1.BundleSource) rukpakv1alpha1.CompositeLayer {
+	return rukpakv1alpha1.CompositeLayer{
+		Path:           path,
+		Type:           source.Type,
+		Image:          source.Image,
+		Git:            source.Git,
+		ConfigMaps:     source.ConfigMaps,
+		Secrets:        source.Secrets,
+		HTTP:           source.HTTP,
+		S3:             source.S3,
+		HelmRepository: source.HelmRepository,
+		Plugin:         source.Plugin,
+		Verification:   source.Verification,
+	}
+}
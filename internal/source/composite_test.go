@@ -0,0 +1,184 @@
+package source
+
+import (
+	"context"
+	"io/fs"
+	"testing/fstest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+type unpackerFunc func(context.Context, *rukpakv1alpha1.Bundle) (*Result, error)
+
+func (f unpackerFunc) Unpack(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (*Result, error) {
+	return f(ctx, bundle)
+}
+
+var _ = Describe("Composite", func() {
+	var (
+		ctx         context.Context
+		imageResult *Result
+		source      *Composite
+		bundle      *rukpakv1alpha1.Bundle
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		cl := fake.NewClientBuilder().WithObjects(
+			&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "values", Namespace: "rukpak-system"},
+				Data:       map[string]string{"values.yaml": "replicas: 3\n"},
+			},
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "tls", Namespace: "rukpak-system"},
+				Data:       map[string][]byte{"tls.crt": []byte("certificate")},
+			},
+		).Build()
+
+		imageResult = &Result{
+			Bundle: fstest.MapFS{
+				"chart/Chart.yaml":  &fstest.MapFile{Data: []byte("name: chart\n")},
+				"chart/values.yaml": &fstest.MapFile{Data: []byte("replicas: 1\n")},
+			},
+			ResolvedSource: &rukpakv1alpha1.BundleSource{
+				Type:  rukpakv1alpha1.SourceTypeImage,
+				Image: &rukpakv1alpha1.ImageSource{Ref: "quay.io/operator-framework/chart@sha256:0000000000000000000000000000000000000000000000000000000000000000"},
+			},
+			State: StateUnpacked,
+		}
+		source = &Composite{Sources: map[rukpakv1alpha1.SourceType]Unpacker{
+			rukpakv1alpha1.SourceTypeImage: unpackerFunc(func(_ context.Context, b *rukpakv1alpha1.Bundle) (*Result, error) {
+				Expect(b.Name).To(Equal("test-bundle"))
+				Expect(b.Spec.Source.Type).To(Equal(rukpakv1alpha1.SourceTypeImage))
+				return imageResult, nil
+			}),
+			rukpakv1alpha1.SourceTypeConfigMaps: &ConfigMaps{Reader: cl, ConfigMapNamespace: "rukpak-system"},
+			rukpakv1alpha1.SourceTypeSecrets:    &Secrets{Reader: cl, SecretNamespace: "rukpak-system"},
+		}}
+		bundle = &rukpakv1alpha1.Bundle{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-bundle"},
+			Spec: rukpakv1alpha1.BundleSpec{
+				Source: rukpakv1alpha1.BundleSource{
+					Type: rukpakv1alpha1.SourceTypeComposite,
+					Composite: &rukpakv1alpha1.CompositeSource{
+						Sources: []rukpakv1alpha1.CompositeLayer{
+							{
+								Type:  rukpakv1alpha1.SourceTypeImage,
+								Image: &rukpakv1alpha1.ImageSource{Ref: "quay.io/operator-framework/chart:latest"},
+							},
+							{
+								Path:       "chart",
+								Type:       rukpakv1alpha1.SourceTypeConfigMaps,
+								ConfigMaps: []rukpakv1alpha1.ConfigMapSource{{ConfigMap: corev1.LocalObjectReference{Name: "values"}}},
+							},
+							{
+								Path:    "chart/certs",
+								Type:    rukpakv1alpha1.SourceTypeSecrets,
+								Secrets: []rukpakv1alpha1.SecretSource{{Secret: corev1.LocalObjectReference{Name: "tls"}}},
+							},
+						},
+						ConflictPolicy: rukpakv1alpha1.CompositeConflictPolicyOverride,
+					},
+				},
+			},
+		}
+	})
+
+	It("should layer the sources in order and record their resolved sources", func() {
+		result, err := source.Unpack(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.State).To(Equal(StateUnpacked))
+
+		data, err := fs.ReadFile(result.Bundle, "chart/values.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("replicas: 3\n"))
+		data, err = fs.ReadFile(result.Bundle, "chart/certs/tls.crt")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("certificate"))
+		_, err = fs.Stat(result.Bundle, "chart/Chart.yaml")
+		Expect(err).NotTo(HaveOccurred())
+
+		resolved := result.ResolvedSource.Composite
+		Expect(resolved.ConflictPolicy).To(Equal(rukpakv1alpha1.CompositeConflictPolicyOverride))
+		Expect(resolved.Sources).To(HaveLen(3))
+		Expect(resolved.Sources[0].Image).To(Equal(imageResult.ResolvedSource.Image))
+		Expect(resolved.Sources[1].Path).To(Equal("chart"))
+		Expect(resolved.Sources[2].Secrets).To(Equal(bundle.Spec.Source.Composite.Sources[2].Secrets))
+	})
+
+	It("should reject files that are provided by more than one source by default", func() {
+		bundle.Spec.Source.Composite.ConflictPolicy = ""
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring(`path "chart/values.yaml" is provided by both sources[0] and sources[1]`)))
+	})
+
+	It("should reject a file that is a directory in another source", func() {
+		bundle.Spec.Source.Composite.Sources[2].Path = "chart/values.yaml"
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring(`path "chart/values.yaml" is a file`)))
+	})
+
+	It("should not be unpacked until every source is unpacked", func() {
+		imageResult = &Result{State: StateUnpacking, Message: "unpacking image"}
+		result, err := source.Unpack(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.State).To(Equal(StateUnpacking))
+		Expect(result.Message).To(Equal("sources[0]: unpacking image"))
+		Expect(result.Bundle).To(BeNil())
+	})
+
+	It("should not unpack the layers that were unpacked again while other layers are unpacking", func() {
+		bundle.UID = "test-bundle-uid"
+		imageCalls := 0
+		source.Sources[rukpakv1alpha1.SourceTypeImage] = unpackerFunc(func(context.Context, *rukpakv1alpha1.Bundle) (*Result, error) {
+			// Image sources consume the uploaded content once it is unpacked,
+			// and would start to unpack the image again on the next call.
+			imageCalls++
+			if imageCalls > 1 {
+				return &Result{State: StatePending, Message: "unpack pod created"}, nil
+			}
+			return imageResult, nil
+		})
+		pluginState := StateUnpacking
+		source.Sources["example"] = unpackerFunc(func(context.Context, *rukpakv1alpha1.Bundle) (*Result, error) {
+			if pluginState != StateUnpacked {
+				return &Result{State: pluginState, Message: "downloading", RequeueAfter: time.Minute}, nil
+			}
+			return &Result{
+				Bundle:         fstest.MapFS{"plugin.yaml": &fstest.MapFile{Data: []byte("kind: ConfigMap\n")}},
+				ResolvedSource: &rukpakv1alpha1.BundleSource{Type: "example", Plugin: &rukpakv1alpha1.PluginSource{}},
+				State:          StateUnpacked,
+			}, nil
+		})
+		bundle.Spec.Source.Composite.Sources = append(bundle.Spec.Source.Composite.Sources[:1], rukpakv1alpha1.CompositeLayer{
+			Type:   "example",
+			Plugin: &rukpakv1alpha1.PluginSource{},
+		})
+
+		for i := 0; i < 3; i++ {
+			result, err := source.Unpack(ctx, bundle)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.State).To(Equal(StateUnpacking))
+			Expect(result.Message).To(Equal("sources[1]: downloading"))
+			Expect(result.RequeueAfter).To(Equal(time.Minute))
+		}
+
+		pluginState = StateUnpacked
+		result, err := source.Unpack(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.State).To(Equal(StateUnpacked))
+		Expect(imageCalls).To(Equal(1))
+		_, err = fs.Stat(result.Bundle, "chart/Chart.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = fs.Stat(result.Bundle, "plugin.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(source.layers).To(BeEmpty())
+	})
+})
@@ -71,7 +71,9 @@ func (o *ConfigMaps) Unpack(ctx context.Context, bundle *rukpakv1alpha1.Bundle)
 		}
 	}
 	if len(errs) > 0 {
-		return nil, utilerrors.NewAggregate(errs)
+		// Only immutable configmaps and secrets are admitted, so their
+		// paths will not stop conflicting.
+		return nil, permanentError(utilerrors.NewAggregate(errs))
 	}
 
 	resolvedSource := &rukpakv1alpha1.BundleSource{
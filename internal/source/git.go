@@ -3,6 +3,8 @@ package source
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
@@ -12,13 +14,16 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	sshgit "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage"
 	"github.com/go-git/go-git/v5/storage/memory"
 	"golang.org/x/crypto/ssh"
 	corev1 "k8s.io/api/core/v1"
@@ -30,6 +35,15 @@ import (
 type Git struct {
 	client.Reader
 	SecretNamespace string
+
+	// RootCAs are the certificate authorities used to verify the certificate
+	// of http(s) repository servers. If nil, the system certificate
+	// authorities are used.
+	RootCAs *x509.CertPool
+
+	// cache is the on-disk cache of the objects of the repositories that
+	// commits are fetched from. If nil, they are fetched into memory.
+	cache *gitCache
 }
 
 func (r *Git) Unpack(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (*Result, error) {
@@ -48,73 +62,135 @@ func (r *Git) Unpack(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (*Resul
 	// Set options for clone
 	progress := bytes.Buffer{}
 	cloneOpts := git.CloneOptions{
-		URL:             gitsource.Repository,
-		Progress:        &progress,
-		Tags:            git.NoTags,
-		InsecureSkipTLS: bundle.Spec.Source.Git.Auth.InsecureSkipVerify,
+		URL:      gitsource.Repository,
+		Progress: &progress,
+		Tags:     git.NoTags,
 	}
 
-	if bundle.Spec.Source.Git.Auth.Secret.Name != "" {
-		auth, err := r.configAuth(ctx, bundle)
+	var tlsConfig *tls.Config
+	if isHTTPRepository(gitsource.Repository) {
+		if !gitHTTPTransportInstalled.Load() {
+			return nil, errors.New("the git http transport is not installed")
+		}
+		// InsecureSkipVerify is part of the TLS configuration, so it is not
+		// set on the clone options.
+		var err error
+		tlsConfig, err = getTLSConfig(ctx, r.Reader, r.SecretNamespace, gitsource.Auth, r.RootCAs)
 		if err != nil {
-			return nil, fmt.Errorf("configuring Auth error: %w", err)
+			return nil, fmt.Errorf("configuring TLS error: %w", err)
 		}
-		cloneOpts.Auth = auth
 	}
 
-	if gitsource.Ref.Branch != "" {
-		cloneOpts.ReferenceName = plumbing.ReferenceName(fmt.Sprintf("refs/heads/%s", gitsource.Ref.Branch))
-		cloneOpts.SingleBranch = true
-		cloneOpts.Depth = 1
-	} else if gitsource.Ref.Tag != "" {
-		cloneOpts.ReferenceName = plumbing.ReferenceName(fmt.Sprintf("refs/tags/%s", gitsource.Ref.Tag))
-		cloneOpts.SingleBranch = true
-		cloneOpts.Depth = 1
-	}
-
-	// Clone
-	repo, err := git.CloneContext(ctx, memory.NewStorage(), memfs.New(), &cloneOpts)
+	auth, err := r.configAuth(ctx, bundle)
 	if err != nil {
-		return nil, fmt.Errorf("bundle unpack git clone error: %v - %s", err, progress.String())
+		return nil, fmt.Errorf("configuring Auth error: %w", err)
 	}
-	wt, err := repo.Worktree()
-	if err != nil {
-		return nil, fmt.Errorf("bundle unpack error: %v", err)
+	cloneOpts.Auth = auth
+
+	if tlsConfig != nil {
+		var closeIdle func()
+		ctx, closeIdle = withGitHTTPScope(ctx, gitsource.Repository, tlsConfig, auth)
+		defer closeIdle()
 	}
 
-	// Checkout commit
+	var (
+		storer     storage.Storer = memory.NewStorage()
+		commitHash plumbing.Hash
+		semverTag  string
+	)
 	if gitsource.Ref.Commit != "" {
-		commitHash := plumbing.NewHash(gitsource.Ref.Commit)
-		if err := wt.Reset(&git.ResetOptions{
-			Commit: commitHash,
-			Mode:   git.HardReset,
-		}); err != nil {
-			return nil, fmt.Errorf("checkout commit %q: %v", commitHash.String(), err)
+		if r.cache != nil {
+			cached, release, err := r.cache.storage(gitsource.Repository, gitCredentialID(auth, tlsConfig))
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+			storer = cached
 		}
+		commitHash = plumbing.NewHash(gitsource.Ref.Commit)
+		if err := fetchCommit(ctx, storer, gitsource.Repository, commitHash, auth, &progress); err != nil {
+			return nil, err
+		}
+	} else {
+		if gitsource.Ref.Branch != "" {
+			cloneOpts.ReferenceName = plumbing.ReferenceName(fmt.Sprintf("refs/heads/%s", gitsource.Ref.Branch))
+		} else if gitsource.Ref.Tag != "" {
+			cloneOpts.ReferenceName = plumbing.ReferenceName(fmt.Sprintf("refs/tags/%s", gitsource.Ref.Tag))
+		} else if gitsource.Ref.Semver != "" {
+			semverTag, err = resolveSemverTag(ctx, gitsource, auth)
+			if err != nil {
+				return nil, err
+			}
+			cloneOpts.ReferenceName = plumbing.NewTagReferenceName(semverTag)
+		}
+		cloneOpts.SingleBranch = true
+		cloneOpts.Depth = 1
+
+		// Clone without a worktree: the commit is checked out below.
+		repo, err := git.CloneContext(ctx, storer, nil, &cloneOpts)
+		if err != nil {
+			return nil, gitFetchError(err, fmt.Errorf("bundle unpack git clone error: %v - %s", err, progress.String()))
+		}
+		head, err := repo.ResolveRevision("HEAD")
+		if err != nil {
+			return nil, fmt.Errorf("resolve commit hash: %v", err)
+		}
+		commitHash = *head
 	}
 
-	var bundleFS fs.FS = &billyFS{wt.Filesystem}
+	// Verify the signature of the tag, or else of the commit, before the
+	// commit is checked out.
+	var signed string
+	if gitsource.Verify != nil {
+		keys, err := getGitVerificationKeys(ctx, r.Reader, r.SecretNamespace, gitsource.Verify)
+		if err != nil {
+			return nil, verificationError(err)
+		}
+		object := fmt.Sprintf("commit %q", commitHash.String())
+		verify := func() (string, error) { return verifyGitCommit(keys, storer, commitHash) }
+		if gitsource.Ref.Tag != "" {
+			object = fmt.Sprintf("tag %q", gitsource.Ref.Tag)
+			verify = func() (string, error) { return verifyGitTag(keys, storer, gitsource.Ref.Tag) }
+		}
+		signer, err := verify()
+		if err != nil {
+			return nil, verificationError(err)
+		}
+		signed = fmt.Sprintf("%s is signed by %s", object, signer)
+	}
+
+	// Checkout
+	checkout := &gitCheckout{
+		auth:       auth,
+		progress:   &progress,
+		submodules: gitsource.Submodules,
+	}
+	if gitsource.SparseCheckout {
+		checkout.directory = filepath.Clean(gitsource.Directory)
+	}
+	worktree := memfs.New()
+	if err := checkout.checkoutCommit(ctx, storer, gitsource.Repository, commitHash, worktree); err != nil {
+		return nil, err
+	}
+
+	var bundleFS fs.FS = &billyFS{worktree}
 
 	// Subdirectory
 	if gitsource.Directory != "" {
 		directory := filepath.Clean(gitsource.Directory)
-		if directory[:3] == "../" || directory[0] == '/' {
+		if strings.HasPrefix(directory, "../") || strings.HasPrefix(directory, "/") {
 			return nil, fmt.Errorf("get subdirectory %q for repository %q: %s", gitsource.Directory, gitsource.Repository, "directory can not start with '../' or '/'")
 		}
-		sub, err := wt.Filesystem.Chroot(filepath.Clean(directory))
+		sub, err := worktree.Chroot(filepath.Clean(directory))
 		if err != nil {
 			return nil, fmt.Errorf("get subdirectory %q for repository %q: %v", gitsource.Directory, gitsource.Repository, err)
 		}
 		bundleFS = &billyFS{sub}
 	}
 
-	commitHash, err := repo.ResolveRevision("HEAD")
-	if err != nil {
-		return nil, fmt.Errorf("resolve commit hash: %v", err)
-	}
-
 	resolvedGit := bundle.Spec.Source.Git.DeepCopy()
 	resolvedGit.Ref = rukpakv1alpha1.GitRef{
+		Tag:    semverTag,
 		Commit: commitHash.String(),
 	}
 
@@ -124,18 +200,40 @@ func (r *Git) Unpack(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (*Resul
 	}
 
 	message := generateMessage("git")
+	if signed != "" {
+		message = fmt.Sprintf("%s: %s", message, signed)
+	}
 
 	return &Result{Bundle: bundleFS, ResolvedSource: resolvedSource, State: StateUnpacked, Message: message}, nil
 }
 
+// configAuth returns the auth method configured by the bundle's
+// authorization, or nil if the repository does not require authorization.
 func (r *Git) configAuth(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (transport.AuthMethod, error) {
 	var auth transport.AuthMethod
-	if strings.HasPrefix(bundle.Spec.Source.Git.Repository, "http") {
-		userName, password, err := r.getCredentials(ctx, bundle)
+	if isHTTPRepository(bundle.Spec.Source.Git.Repository) {
+		var basic *http.BasicAuth
+		if bundle.Spec.Source.Git.Auth.Secret.Name != "" {
+			userName, password, err := r.getCredentials(ctx, bundle)
+			if err != nil {
+				return nil, err
+			}
+			basic = &http.BasicAuth{Username: userName, Password: password}
+		}
+		header, err := getAuthHeaders(ctx, r.Reader, r.SecretNamespace, bundle.Spec.Source.Git.Auth)
 		if err != nil {
 			return nil, err
 		}
-		return &http.BasicAuth{Username: userName, Password: password}, nil
+		switch {
+		case len(header) > 0:
+			return &gitHTTPAuth{basic: basic, header: header}, nil
+		case basic != nil:
+			return basic, nil
+		}
+		return nil, nil
+	}
+	if bundle.Spec.Source.Git.Auth.Secret.Name == "" {
+		return nil, nil
 	}
 	privatekey, host, err := r.getCertificate(ctx, bundle)
 	if err != nil {
@@ -174,6 +272,49 @@ func (r *Git) configAuth(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (tr
 	return auth, nil
 }
 
+// resolveSemverTag lists the tags of the remote repository and returns the
+// name of the highest tag that is a semver version satisfying the constraint
+// of the git source. Tags whose names are not semver versions are ignored.
+func resolveSemverTag(ctx context.Context, gitsource *rukpakv1alpha1.GitSource, auth transport.AuthMethod) (string, error) {
+	constraint, err := semver.NewConstraint(gitsource.Ref.Semver)
+	if err != nil {
+		return "", fmt.Errorf("parse semver constraint %q: %v", gitsource.Ref.Semver, err)
+	}
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{gitsource.Repository},
+	})
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth, PeelingOption: git.IgnorePeeled})
+	if err != nil {
+		return "", fmt.Errorf("list tags of repository %q: %v", gitsource.Repository, err)
+	}
+
+	var (
+		tag     string
+		highest *semver.Version
+	)
+	for _, ref := range refs {
+		if !ref.Name().IsTag() {
+			continue
+		}
+		version, err := semver.NewVersion(ref.Name().Short())
+		if err != nil || !constraint.Check(version) {
+			continue
+		}
+		if highest == nil || version.GreaterThan(highest) {
+			tag, highest = ref.Name().Short(), version
+		}
+	}
+	if highest == nil {
+		return "", fmt.Errorf("no tag of repository %q satisfies semver constraint %q", gitsource.Repository, gitsource.Ref.Semver)
+	}
+	return tag, nil
+}
+
+func isHTTPRepository(repository string) bool {
+	return strings.HasPrefix(repository, "http")
+}
+
 // getCredentials reads credentials from the secret specified in the bundle
 // It returns the username ane password when they are in the secret
 func (r *Git) getCredentials(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (string, string, error) {
@@ -280,3 +421,19 @@ func (d *billyDirFile) ReadDir(n int) ([]fs.DirEntry, error) {
 func (d billyDirFile) Read(_ []byte) (int, error) {
 	return 0, &fs.PathError{Op: "read", Path: d.path, Err: syscall.EISDIR}
 }
+
+// gitFetchError returns err, which reports that fetching a repository failed
+// with cause, as a permanent error if the ref or the credentials were
+// rejected. Like a missing http resource, a repository that is not found is
+// transient, since servers also report private repositories as not found.
+func gitFetchError(cause, err error) error {
+	switch {
+	case errors.Is(cause, transport.ErrAuthenticationRequired),
+		errors.Is(cause, transport.ErrAuthorizationFailed),
+		errors.Is(cause, transport.ErrInvalidAuthMethod),
+		errors.Is(cause, plumbing.ErrReferenceNotFound),
+		errors.Is(cause, git.NoMatchingRefSpecError{}):
+		return permanentError(err)
+	}
+	return err
+}
@@ -0,0 +1,304 @@
+package source
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	sshgit "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+const (
+	// DefaultGitCacheDir is the default directory in which the objects of
+	// git repositories are cached.
+	DefaultGitCacheDir = "/var/cache/git"
+
+	// DefaultGitCacheMaxSize is the default maximum size, in bytes, of the
+	// git object cache.
+	DefaultGitCacheMaxSize int64 = 1 << 30
+)
+
+// GitCacheConfig configures the on-disk cache of the objects of the git
+// repositories that bundles are unpacked from.
+type GitCacheConfig struct {
+	// Dir is the directory in which the objects are cached. If empty, the
+	// objects are not cached and are fetched into memory for each unpack.
+	Dir string
+
+	// MaxSize is the maximum size, in bytes, of the cache. When the cache
+	// exceeds it, the least recently used repositories are evicted.
+	MaxSize int64
+}
+
+// gitCache is a bounded on-disk cache of the objects of git repositories,
+// keyed by repository URL and by the credentials that they are fetched with,
+// so that objects that were fetched with some credentials are not served to
+// bundles without them. Each repository is stored in its own bare git
+// directory and is locked while it is used, so that concurrent unpacks of
+// the same repository do not fetch into it at the same time and it is not
+// evicted while it is in use.
+type gitCache struct {
+	dir     string
+	maxSize int64
+
+	// mu guards the fields below.
+	mu sync.Mutex
+
+	// locks are the locks of the repositories that are in use, or waited
+	// for, which are removed once they are no longer.
+	locks map[string]*gitCacheLock
+
+	// repos are the sizes and last uses of the cached repositories, which
+	// are read from disk when the cache is first used and then updated
+	// whenever a repository is used, so that the size of the cache is known
+	// without walking all of it.
+	repos   map[string]*cachedGitRepository
+	size    int64
+	scanned bool
+}
+
+type gitCacheLock struct {
+	sync.Mutex
+	refs int
+}
+
+type cachedGitRepository struct {
+	size     int64
+	lastUsed time.Time
+}
+
+func newGitCache(cfg GitCacheConfig) *gitCache {
+	return &gitCache{
+		dir:     cfg.Dir,
+		maxSize: cfg.MaxSize,
+		locks:   map[string]*gitCacheLock{},
+		repos:   map[string]*cachedGitRepository{},
+	}
+}
+
+// storage locks the cached repository with the given URL, which is fetched
+// with the credentials identified by credentialID, and returns its object
+// storage. The returned function must be called once the storage is no
+// longer used: it unlocks the repository and evicts the least recently used
+// repositories if the cache exceeds its maximum size.
+func (c *gitCache) storage(repository, credentialID string) (storage.Storer, func(), error) {
+	c.scan()
+
+	key := fmt.Sprintf("%x", sha256.Sum256([]byte(repository+"\x00"+credentialID)))
+	lock := c.acquire(key)
+	lock.Lock()
+	unlock := func() {
+		lock.Unlock()
+		c.release(key)
+	}
+
+	dir := filepath.Join(c.dir, key)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		unlock()
+		return nil, nil, fmt.Errorf("create git cache directory: %v", err)
+	}
+	// The modification time of the repository directory records when it
+	// was last used, which survives restarts of the provisioner.
+	now := time.Now()
+	if err := os.Chtimes(dir, now, now); err != nil {
+		unlock()
+		return nil, nil, fmt.Errorf("update git cache directory: %v", err)
+	}
+
+	s := filesystem.NewStorage(osfs.New(dir), cache.NewObjectLRUDefault())
+	return s, func() {
+		// Only the repository that was used can have changed in size.
+		size := dirSize(dir)
+		c.mu.Lock()
+		c.setRepository(key, &cachedGitRepository{size: size, lastUsed: now})
+		c.mu.Unlock()
+		unlock()
+		c.evict()
+	}, nil
+}
+
+// scan reads the sizes of the repositories that are already cached, e.g. by
+// a previous run of the provisioner, the first time the cache is used.
+func (c *gitCache) scan() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.scanned {
+		return
+	}
+	c.scanned = true
+
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		c.setRepository(entry.Name(), &cachedGitRepository{size: dirSize(filepath.Join(c.dir, entry.Name())), lastUsed: info.ModTime()})
+	}
+}
+
+// setRepository records the size and last use of a cached repository. It
+// must be called with mu held.
+func (c *gitCache) setRepository(key string, repo *cachedGitRepository) {
+	if existing, ok := c.repos[key]; ok {
+		c.size -= existing.size
+	}
+	c.repos[key] = repo
+	c.size += repo.size
+}
+
+// acquire returns the lock of the repository with the given key, which must
+// be released once it is no longer used.
+func (c *gitCache) acquire(key string) *gitCacheLock {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lock, ok := c.locks[key]
+	if !ok {
+		lock = &gitCacheLock{}
+		c.locks[key] = lock
+	}
+	lock.refs++
+	return lock
+}
+
+func (c *gitCache) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lock := c.locks[key]
+	lock.refs--
+	if lock.refs == 0 {
+		delete(c.locks, key)
+	}
+}
+
+// evict removes the least recently used repositories until the cache no
+// longer exceeds its maximum size. Repositories that are in use are skipped.
+// Eviction is best effort: errors only mean that some space is not freed
+// until the next eviction.
+func (c *gitCache) evict() {
+	type candidate struct {
+		key      string
+		lastUsed time.Time
+	}
+	c.mu.Lock()
+	if c.size <= c.maxSize {
+		c.mu.Unlock()
+		return
+	}
+	candidates := make([]candidate, 0, len(c.repos))
+	for key, repo := range c.repos {
+		candidates = append(candidates, candidate{key: key, lastUsed: repo.lastUsed})
+	}
+	c.mu.Unlock()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].lastUsed.Before(candidates[j].lastUsed)
+	})
+	for _, candidate := range candidates {
+		c.evictRepository(candidate.key)
+		c.mu.Lock()
+		done := c.size <= c.maxSize
+		c.mu.Unlock()
+		if done {
+			return
+		}
+	}
+}
+
+// evictRepository removes the repository with the given key, unless it is in
+// use.
+func (c *gitCache) evictRepository(key string) {
+	lock := c.acquire(key)
+	defer c.release(key)
+	if !lock.TryLock() {
+		return
+	}
+	defer lock.Unlock()
+
+	if err := os.RemoveAll(filepath.Join(c.dir, key)); err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if repo, ok := c.repos[key]; ok {
+		c.size -= repo.size
+		delete(c.repos, key)
+	}
+}
+
+// dirSize returns the total size of the regular files in dir, ignoring
+// files that cannot be read.
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// gitCredentialID returns an identifier of the credentials that a repository
+// is fetched with, which are either in auth or are the client certificates of
+// tlsConfig. It is empty if there are no credentials.
+func gitCredentialID(auth transport.AuthMethod, tlsConfig *tls.Config) string {
+	b := &bytes.Buffer{}
+	switch a := auth.(type) {
+	case nil:
+	case *githttp.BasicAuth:
+		fmt.Fprintf(b, "basic\x00%s\x00%s\x00", a.Username, a.Password)
+	case *gitHTTPAuth:
+		if a.basic != nil {
+			fmt.Fprintf(b, "basic\x00%s\x00%s\x00", a.basic.Username, a.basic.Password)
+		}
+		names := make([]string, 0, len(a.header))
+		for name := range a.header {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(b, "header\x00%s\x00%q\x00", name, a.header[name])
+		}
+	case *sshgit.PublicKeys:
+		fmt.Fprintf(b, "ssh\x00%s\x00", a.User)
+		b.Write(a.Signer.PublicKey().Marshal())
+	default:
+		fmt.Fprintf(b, "%s\x00%s\x00", a.Name(), a.String())
+	}
+	if tlsConfig != nil {
+		for _, cert := range tlsConfig.Certificates {
+			for _, der := range cert.Certificate {
+				b.Write(der)
+			}
+		}
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b.Bytes()))
+}
@@ -0,0 +1,280 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+// fetchCommit fetches the given commit of the repository into storer, unless
+// it is already there. If the server allows fetching commits that are not at
+// the tip of a ref, only the commit is fetched, at depth 1. Otherwise all
+// branches are fetched, as they would be by a clone.
+func fetchCommit(ctx context.Context, storer storage.Storer, repository string, commitHash plumbing.Hash, auth transport.AuthMethod, progress *bytes.Buffer) error {
+	if _, err := storer.EncodedObject(plumbing.CommitObject, commitHash); err == nil {
+		return nil
+	}
+
+	remote := git.NewRemote(storer, &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{repository},
+	})
+	err := remote.FetchContext(ctx, &git.FetchOptions{
+		RefSpecs: []config.RefSpec{config.RefSpec(fmt.Sprintf("%s:refs/rukpak/commits/%[1]s", commitHash))},
+		Depth:    1,
+		Auth:     auth,
+		Progress: progress,
+		Tags:     git.NoTags,
+	})
+	if errors.Is(err, git.ErrExactSHA1NotSupported) {
+		err = remote.FetchContext(ctx, &git.FetchOptions{
+			RefSpecs: []config.RefSpec{config.RefSpec(fmt.Sprintf("+refs/heads/*:refs/remotes/%s/*", git.DefaultRemoteName))},
+			Auth:     auth,
+			Progress: progress,
+			Tags:     git.NoTags,
+		})
+	}
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return gitFetchError(err, fmt.Errorf("bundle unpack git fetch error: %v - %s", err, progress.String()))
+	}
+	return nil
+}
+
+// gitCheckout checks out the files of commits into worktree filesystems.
+// Unlike a go-git worktree, it does not keep an index in the object storage,
+// so the storage of a cached repository can be shared by the checkouts of
+// different commits.
+type gitCheckout struct {
+	// auth is the auth method of the repository, which is also used to fetch
+	// the submodules that are hosted on the same host.
+	auth     transport.AuthMethod
+	progress *bytes.Buffer
+
+	// directory, if set, limits the checkout to the files in the directory
+	// and to the submodules within it.
+	directory string
+
+	// submodules configures whether submodules are checked out, recursively.
+	submodules bool
+}
+
+// checkoutCommit writes the files of the given commit of the repository,
+// whose objects are in storer, into worktree.
+func (c *gitCheckout) checkoutCommit(ctx context.Context, storer storage.Storer, repository string, commitHash plumbing.Hash, worktree billy.Filesystem) error {
+	commit, err := object.GetCommit(storer, commitHash)
+	if err != nil {
+		return fmt.Errorf("checkout commit %q: %v", commitHash.String(), err)
+	}
+	root, err := commit.Tree()
+	if err != nil {
+		return fmt.Errorf("checkout commit %q: %v", commitHash.String(), err)
+	}
+
+	tree, dir := root, ""
+	if c.directory != "" && c.directory != "." {
+		if tree, err = root.Tree(c.directory); err != nil {
+			return fmt.Errorf("checkout directory %q of commit %q: %v", c.directory, commitHash.String(), err)
+		}
+		dir = c.directory
+	}
+	gitlinks := map[string]plumbing.Hash{}
+	if err := writeGitTree(storer, tree, worktree, dir, gitlinks); err != nil {
+		return fmt.Errorf("checkout commit %q: %v", commitHash.String(), err)
+	}
+
+	if !c.submodules || len(gitlinks) == 0 {
+		return nil
+	}
+	return c.checkoutSubmodules(ctx, storer, repository, root, worktree, gitlinks)
+}
+
+// checkoutSubmodules fetches and checks out the commits of the submodules
+// with the given paths, as recorded in the .gitmodules file of the tree.
+// Submodules are checked out in full, regardless of the checkout directory.
+func (c *gitCheckout) checkoutSubmodules(ctx context.Context, storer storage.Storer, repository string, root *object.Tree, worktree billy.Filesystem, gitlinks map[string]plumbing.Hash) error {
+	file, err := root.File(".gitmodules")
+	if err != nil {
+		return fmt.Errorf("read .gitmodules: %v", err)
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return fmt.Errorf("read .gitmodules: %v", err)
+	}
+	modules := config.NewModules()
+	if err := modules.Unmarshal([]byte(contents)); err != nil {
+		return fmt.Errorf("parse .gitmodules: %v", err)
+	}
+	submodules := map[string]*config.Submodule{}
+	for _, submodule := range modules.Submodules {
+		submodules[path.Clean(submodule.Path)] = submodule
+	}
+
+	for subPath, subCommit := range gitlinks {
+		submodule, ok := submodules[subPath]
+		if !ok {
+			return fmt.Errorf("no submodule mapping found in .gitmodules for path %q", subPath)
+		}
+		subURL, err := resolveSubmoduleURL(repository, submodule.URL)
+		if err != nil {
+			return fmt.Errorf("resolve URL of submodule %q: %v", submodule.Name, err)
+		}
+		subStorer, err := storer.Module(submodule.Name)
+		if err != nil {
+			return fmt.Errorf("open storage of submodule %q: %v", submodule.Name, err)
+		}
+		subWorktree, err := worktree.Chroot(subPath)
+		if err != nil {
+			return fmt.Errorf("checkout submodule %q: %v", submodule.Name, err)
+		}
+
+		// The credentials of the repository are only sent to submodules on
+		// the same host, so that they are not leaked to other hosts.
+		subCheckout := &gitCheckout{progress: c.progress, submodules: true}
+		if sameGitHost(repository, subURL) {
+			subCheckout.auth = c.auth
+		}
+		if err := fetchCommit(ctx, subStorer, subURL, subCommit, subCheckout.auth, c.progress); err != nil {
+			return fmt.Errorf("fetch submodule %q: %v", submodule.Name, err)
+		}
+		if err := subCheckout.checkoutCommit(ctx, subStorer, subURL, subCommit, subWorktree); err != nil {
+			return fmt.Errorf("checkout submodule %q: %v", submodule.Name, err)
+		}
+	}
+	return nil
+}
+
+// writeGitTree writes the files of tree into worktree, under dir. The commits
+// of the submodules in the tree are recorded in gitlinks by path.
+func writeGitTree(storer storage.Storer, tree *object.Tree, worktree billy.Filesystem, dir string, gitlinks map[string]plumbing.Hash) error {
+	if err := worktree.MkdirAll(path.Join("/", dir), 0755); err != nil {
+		return err
+	}
+	for _, entry := range tree.Entries {
+		name := path.Join(dir, entry.Name)
+		switch entry.Mode {
+		case filemode.Dir:
+			subtree, err := object.GetTree(storer, entry.Hash)
+			if err != nil {
+				return fmt.Errorf("read tree %q: %v", name, err)
+			}
+			if err := writeGitTree(storer, subtree, worktree, name, gitlinks); err != nil {
+				return err
+			}
+		case filemode.Submodule:
+			gitlinks[name] = entry.Hash
+		case filemode.Symlink:
+			target, err := readGitBlob(storer, entry.Hash)
+			if err != nil {
+				return fmt.Errorf("read symlink %q: %v", name, err)
+			}
+			if err := worktree.Symlink(string(target), name); err != nil {
+				return err
+			}
+		default:
+			if err := writeGitBlob(storer, entry, worktree, name); err != nil {
+				return fmt.Errorf("write file %q: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func writeGitBlob(storer storage.Storer, entry object.TreeEntry, worktree billy.Filesystem, name string) error {
+	blob, err := object.GetBlob(storer, entry.Hash)
+	if err != nil {
+		return err
+	}
+	mode, err := entry.Mode.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+	r, err := blob.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := worktree.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readGitBlob(storer storage.Storer, hash plumbing.Hash) ([]byte, error) {
+	blob, err := object.GetBlob(storer, hash)
+	if err != nil {
+		return nil, err
+	}
+	r, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// gitSubmoduleProtocols are the protocols with which submodules may be
+// fetched. Local repositories, e.g. with the file protocol, are rejected, so
+// that the .gitmodules file of a repository cannot read other repositories
+// from the disk of the provisioner, such as those in the git object cache.
+var gitSubmoduleProtocols = map[string]bool{"http": true, "https": true, "ssh": true, "git": true}
+
+// resolveSubmoduleURL resolves the URL of a submodule, which may be relative
+// to the URL of its parent repository. Submodules must be fetched with a
+// network protocol, and only with http if the parent repository is.
+func resolveSubmoduleURL(repository, submoduleURL string) (string, error) {
+	parent, err := transport.NewEndpoint(repository)
+	if err != nil {
+		return "", err
+	}
+	if !gitSubmoduleProtocols[parent.Protocol] {
+		return "", fmt.Errorf("submodules of repositories with protocol %q are not supported", parent.Protocol)
+	}
+
+	var endpoint *transport.Endpoint
+	if strings.HasPrefix(submoduleURL, "./") || strings.HasPrefix(submoduleURL, "../") {
+		resolved := *parent
+		resolved.Path = path.Join(parent.Path, submoduleURL)
+		endpoint = &resolved
+	} else if endpoint, err = transport.NewEndpoint(submoduleURL); err != nil {
+		return "", err
+	}
+	if !gitSubmoduleProtocols[endpoint.Protocol] || (endpoint.Protocol == "http" && parent.Protocol != "http") {
+		return "", fmt.Errorf("protocol %q of submodule URL %q is not allowed for a repository with protocol %q", endpoint.Protocol, submoduleURL, parent.Protocol)
+	}
+	return endpoint.String(), nil
+}
+
+// sameGitHost returns whether the given repository URLs are served by the
+// same host, with the same protocol.
+func sameGitHost(a, b string) bool {
+	endpointA, err := transport.NewEndpoint(a)
+	if err != nil {
+		return false
+	}
+	endpointB, err := transport.NewEndpoint(b)
+	if err != nil {
+		return false
+	}
+	return endpointA.Protocol == endpointB.Protocol &&
+		strings.EqualFold(endpointA.Host, endpointB.Host) &&
+		endpointA.Port == endpointB.Port
+}
@@ -0,0 +1,359 @@
+package source
+
+import (
+	"context"
+	"crypto/tls"
+	"io/fs"
+	nethttp "net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+var _ = Describe("Git", func() {
+	var (
+		ctx     context.Context
+		repoDir string
+		commits map[string]plumbing.Hash
+		source  *Git
+		bundle  *rukpakv1alpha1.Bundle
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		repoDir = GinkgoT().TempDir()
+		commits = map[string]plumbing.Hash{}
+
+		repo, err := git.PlainInit(repoDir, false)
+		Expect(err).NotTo(HaveOccurred())
+		wt, err := repo.Worktree()
+		Expect(err).NotTo(HaveOccurred())
+		signature := &object.Signature{Name: "rukpak", Email: "rukpak@example.com", When: time.Now()}
+		for _, tag := range []string{"v1.0.0", "v1.2.0", "v2.0.0", "latest"} {
+			Expect(os.MkdirAll(filepath.Join(repoDir, "manifests"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(repoDir, "manifests", "version.txt"), []byte(tag), 0600)).To(Succeed())
+			_, err = wt.Add("manifests/version.txt")
+			Expect(err).NotTo(HaveOccurred())
+			commits[tag], err = wt.Commit(tag, &git.CommitOptions{Author: signature})
+			Expect(err).NotTo(HaveOccurred())
+			// Annotate every other tag (e.g. v1.2.0) to cover both kinds of tags.
+			var tagOpts *git.CreateTagOptions
+			if len(commits)%2 == 0 {
+				tagOpts = &git.CreateTagOptions{Tagger: signature, Message: tag}
+			}
+			_, err = repo.CreateTag(tag, commits[tag], tagOpts)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		source = &Git{Reader: fake.NewClientBuilder().Build(), SecretNamespace: "rukpak-system"}
+		bundle = &rukpakv1alpha1.Bundle{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-bundle"},
+			Spec: rukpakv1alpha1.BundleSpec{
+				Source: rukpakv1alpha1.BundleSource{
+					Type: rukpakv1alpha1.SourceTypeGit,
+					Git: &rukpakv1alpha1.GitSource{
+						Repository: repoDir,
+						Ref:        rukpakv1alpha1.GitRef{Semver: ">=1.0.0 <2.0.0"},
+					},
+				},
+			},
+		}
+	})
+
+	It("should unpack the highest tag that satisfies the semver constraint", func() {
+		result, err := source.Unpack(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.State).To(Equal(StateUnpacked))
+		Expect(result.ResolvedSource.Git.Ref).To(Equal(rukpakv1alpha1.GitRef{Tag: "v1.2.0", Commit: commits["v1.2.0"].String()}))
+
+		data, err := fs.ReadFile(result.Bundle, "manifests/version.txt")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("v1.2.0"))
+	})
+
+	It("should unpack a lightweight tag", func() {
+		bundle.Spec.Source.Git.Ref.Semver = "^2"
+		result, err := source.Unpack(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.ResolvedSource.Git.Ref).To(Equal(rukpakv1alpha1.GitRef{Tag: "v2.0.0", Commit: commits["v2.0.0"].String()}))
+	})
+
+	It("should fail when no tag satisfies the semver constraint", func() {
+		bundle.Spec.Source.Git.Ref.Semver = ">=3.0.0"
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring(`satisfies semver constraint ">=3.0.0"`)))
+	})
+
+	When("the ref is a commit", func() {
+		var cacheDir string
+
+		BeforeEach(func() {
+			cacheDir = GinkgoT().TempDir()
+			source.cache = newGitCache(GitCacheConfig{Dir: cacheDir, MaxSize: DefaultGitCacheMaxSize})
+			bundle.Spec.Source.Git.Ref = rukpakv1alpha1.GitRef{Commit: commits["v1.0.0"].String()}
+		})
+
+		cachedRepository := func() string {
+			entries, err := os.ReadDir(cacheDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+			return filepath.Join(cacheDir, entries[0].Name())
+		}
+
+		It("should fetch all branches into the cache when the server does not allow fetching the commit", func() {
+			result, err := source.Unpack(ctx, bundle)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.ResolvedSource.Git.Ref).To(Equal(rukpakv1alpha1.GitRef{Commit: commits["v1.0.0"].String()}))
+			data, err := fs.ReadFile(result.Bundle, "manifests/version.txt")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("v1.0.0"))
+			Expect(filepath.Join(cachedRepository(), "shallow")).NotTo(BeAnExistingFile())
+		})
+
+		It("should fetch only the commit when the server allows it", func() {
+			repo, err := git.PlainOpen(repoDir)
+			Expect(err).NotTo(HaveOccurred())
+			cfg, err := repo.Config()
+			Expect(err).NotTo(HaveOccurred())
+			cfg.Raw.Section("uploadpack").SetOption("allowReachableSHA1InWant", "true")
+			Expect(repo.SetConfig(cfg)).To(Succeed())
+
+			result, err := source.Unpack(ctx, bundle)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := fs.ReadFile(result.Bundle, "manifests/version.txt")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("v1.0.0"))
+			Expect(filepath.Join(cachedRepository(), "shallow")).To(BeAnExistingFile())
+		})
+
+		It("should unpack cached commits without fetching them again", func() {
+			_, err := source.Unpack(ctx, bundle)
+			Expect(err).NotTo(HaveOccurred())
+
+			// Fetching from the repository fails once it is removed, so the
+			// commits must be read from the cache.
+			Expect(os.RemoveAll(repoDir)).To(Succeed())
+			bundle.Spec.Source.Git.Ref.Commit = commits["v2.0.0"].String()
+			result, err := source.Unpack(ctx, bundle)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := fs.ReadFile(result.Bundle, "manifests/version.txt")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("v2.0.0"))
+		})
+
+		It("should not serve cached objects to bundles with other credentials", func() {
+			_, err := source.Unpack(ctx, bundle)
+			Expect(err).NotTo(HaveOccurred())
+
+			credentialID := gitCredentialID(&http.BasicAuth{Username: "user", Password: "secret"}, nil)
+			Expect(credentialID).NotTo(BeEmpty())
+			Expect(gitCredentialID(&http.BasicAuth{Username: "user", Password: "other"}, nil)).NotTo(Equal(credentialID))
+
+			storer, release, err := source.cache.storage(repoDir, credentialID)
+			Expect(err).NotTo(HaveOccurred())
+			defer release()
+			_, err = storer.EncodedObject(plumbing.CommitObject, commits["v1.0.0"])
+			Expect(err).To(MatchError(plumbing.ErrObjectNotFound))
+		})
+
+		It("should track the size of the cache and release the locks of repositories", func() {
+			_, err := source.Unpack(ctx, bundle)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(source.cache.size).To(Equal(dirSize(cachedRepository())))
+			Expect(source.cache.size).To(BeNumerically(">", 0))
+			Expect(source.cache.locks).To(BeEmpty())
+
+			// The sizes of repositories cached by a previous run are read
+			// from disk.
+			restarted := newGitCache(GitCacheConfig{Dir: cacheDir, MaxSize: DefaultGitCacheMaxSize})
+			restarted.scan()
+			Expect(restarted.size).To(Equal(source.cache.size))
+		})
+
+		It("should evict the repository when the cache exceeds its maximum size", func() {
+			source.cache.maxSize = 1
+			_, err := source.Unpack(ctx, bundle)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.ReadDir(cacheDir)).To(BeEmpty())
+		})
+	})
+
+	When("the repository has submodules", func() {
+		var (
+			parentDir string
+			parentURL string
+		)
+
+		runGit := func(dir string, args ...string) string {
+			cmd := exec.Command("git", append([]string{"-c", "user.name=rukpak", "-c", "user.email=rukpak@example.com"}, args...)...)
+			cmd.Dir = dir
+			out, err := cmd.CombinedOutput()
+			Expect(err).NotTo(HaveOccurred(), string(out))
+			return strings.TrimSpace(string(out))
+		}
+
+		BeforeEach(func() {
+			// The parent repository is a sibling of the repository created
+			// above, which it references as a relative submodule at
+			// manifests/shared. It also references a submodule outside of
+			// the manifests directory that cannot be fetched.
+			parentDir = filepath.Join(filepath.Dir(repoDir), "parent")
+			Expect(os.MkdirAll(filepath.Join(parentDir, "manifests"), 0755)).To(Succeed())
+			runGit(parentDir, "init", "-b", "main")
+			Expect(os.WriteFile(filepath.Join(parentDir, "README.md"), []byte("parent"), 0600)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(parentDir, "manifests", "configmap.yaml"), []byte("kind: ConfigMap\n"), 0600)).To(Succeed())
+			runGit(parentDir, "config", "-f", ".gitmodules", "submodule.shared.path", "manifests/shared")
+			runGit(parentDir, "config", "-f", ".gitmodules", "submodule.shared.url", "../"+filepath.Base(repoDir))
+			runGit(parentDir, "config", "-f", ".gitmodules", "submodule.missing.path", "vendor/missing")
+			runGit(parentDir, "config", "-f", ".gitmodules", "submodule.missing.url", "https://git.invalid/missing")
+			runGit(parentDir, "add", ".")
+			runGit(parentDir, "update-index", "--add", "--cacheinfo", "160000,"+commits["v1.2.0"].String()+",manifests/shared")
+			runGit(parentDir, "update-index", "--add", "--cacheinfo", "160000,"+commits["v1.0.0"].String()+",vendor/missing")
+			runGit(parentDir, "commit", "-m", "parent")
+
+			// Submodules cannot be fetched from local repositories, so the
+			// repositories are served over http.
+			gitPath, err := exec.LookPath("git")
+			Expect(err).NotTo(HaveOccurred())
+			server := httptest.NewServer(&cgi.Handler{
+				Path: gitPath,
+				Args: []string{"http-backend"},
+				Env:  []string{"GIT_PROJECT_ROOT=" + filepath.Dir(repoDir), "GIT_HTTP_EXPORT_ALL=1"},
+			})
+			DeferCleanup(server.Close)
+			parentURL = server.URL + "/parent"
+
+			bundle.Spec.Source.Git = &rukpakv1alpha1.GitSource{
+				Repository:     parentURL,
+				Directory:      "manifests",
+				Ref:            rukpakv1alpha1.GitRef{Branch: "main"},
+				Submodules:     true,
+				SparseCheckout: true,
+			}
+		})
+
+		It("should check out the submodules within the sparse checkout directory", func() {
+			result, err := source.Unpack(ctx, bundle)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := fs.ReadFile(result.Bundle, "shared/manifests/version.txt")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("v1.2.0"))
+			_, err = fs.Stat(result.Bundle, "configmap.yaml")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should only check out the files within the sparse checkout directory", func() {
+			bundle.Spec.Source.Git.Submodules = false
+			parent, err := git.PlainOpen(parentDir)
+			Expect(err).NotTo(HaveOccurred())
+			head, err := parent.Head()
+			Expect(err).NotTo(HaveOccurred())
+
+			worktree := memfs.New()
+			checkout := &gitCheckout{directory: "manifests"}
+			Expect(checkout.checkoutCommit(ctx, parent.Storer, parentDir, head.Hash(), worktree)).To(Succeed())
+			_, err = worktree.Stat("manifests/configmap.yaml")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = worktree.Stat("README.md")
+			Expect(err).To(MatchError(os.ErrNotExist))
+		})
+
+		It("should fail to fetch submodules outside of the directory without a sparse checkout", func() {
+			bundle.Spec.Source.Git.SparseCheckout = false
+			_, err := source.Unpack(ctx, bundle)
+			Expect(err).To(MatchError(ContainSubstring(`fetch submodule "missing"`)))
+		})
+
+		It("should not fetch the submodules of local repositories", func() {
+			bundle.Spec.Source.Git.Repository = parentDir
+			_, err := source.Unpack(ctx, bundle)
+			Expect(err).To(MatchError(ContainSubstring(`submodules of repositories with protocol "file" are not supported`)))
+		})
+	})
+
+	DescribeTable("resolving submodule URLs",
+		func(repository, submoduleURL, expected string) {
+			resolved, err := resolveSubmoduleURL(repository, submoduleURL)
+			if expected == "" {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resolved).To(Equal(expected))
+		},
+		Entry("relative to an https repository", "https://example.com/org/repo.git", "../lib.git", "https://example.com/org/lib.git"),
+		Entry("relative to an ssh repository", "ssh://git@example.com/org/repo.git", "./lib.git", "ssh://git@example.com/org/repo.git/lib.git"),
+		Entry("absolute https", "ssh://git@example.com/org/repo.git", "https://other.example.com/lib.git", "https://other.example.com/lib.git"),
+		Entry("http of an http repository", "http://example.com/org/repo.git", "http://other.example.com/lib.git", "http://other.example.com/lib.git"),
+		Entry("http of an https repository", "https://example.com/org/repo.git", "http://other.example.com/lib.git", ""),
+		Entry("file URL", "https://example.com/org/repo.git", "file:///var/cache/git/repo", ""),
+		Entry("local path", "https://example.com/org/repo.git", "/var/cache/git/repo", ""),
+		Entry("relative to a local repository", "/tmp/repo", "../lib", ""),
+	)
+})
+
+var _ = Describe("Git http scope", func() {
+	var (
+		ctx   context.Context
+		scope *gitHTTPScope
+	)
+
+	BeforeEach(func() {
+		auth := &gitHTTPAuth{
+			basic:  &http.BasicAuth{Username: "user", Password: "secret"},
+			header: nethttp.Header{"Private-Token": []string{"secret"}},
+		}
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{{Certificate: [][]byte{[]byte("cert")}}}}
+		var closeIdle func()
+		ctx, closeIdle = withGitHTTPScope(context.Background(), "https://git.example.com/org/repo.git", tlsConfig, auth)
+		DeferCleanup(closeIdle)
+		scope = ctx.Value(gitHTTPScopeKey{}).(*gitHTTPScope)
+	})
+
+	request := func(url string) *nethttp.Request {
+		req, err := nethttp.NewRequestWithContext(ctx, nethttp.MethodGet, url, nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("Authorization", "Basic c2VjcmV0")
+		req.Header.Set("Private-Token", "secret")
+		req.Header.Set("User-Agent", "git/1.0")
+		return req
+	}
+
+	It("should only send client certificates to the host of the repository", func() {
+		rt := scope.roundTripper(request("https://git.example.com/org/submodule.git/info/refs"))
+		Expect(rt.(*nethttp.Transport).TLSClientConfig.Certificates).To(HaveLen(1))
+
+		rt = scope.roundTripper(request("https://other.example.com/org/submodule.git/info/refs"))
+		Expect(rt.(*nethttp.Transport).TLSClientConfig.Certificates).To(BeEmpty())
+	})
+
+	It("should remove auth headers from requests that are redirected to other hosts", func() {
+		via := []*nethttp.Request{request("https://git.example.com/org/repo.git/info/refs")}
+
+		req := request("https://git.example.com/org/moved.git/info/refs")
+		Expect(checkGitRedirect(req, via)).To(Succeed())
+		Expect(req.Header.Get("Private-Token")).To(Equal("secret"))
+
+		req = request("https://other.example.com/org/repo.git/info/refs")
+		Expect(checkGitRedirect(req, via)).To(Succeed())
+		Expect(req.Header.Get("Authorization")).To(BeEmpty())
+		Expect(req.Header.Get("Private-Token")).To(BeEmpty())
+		Expect(req.Header.Get("User-Agent")).To(Equal("git/1.0"))
+	})
+})
@@ -0,0 +1,157 @@
+package source
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	gitclient "github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// go-git selects the transport for a repository URL from a global registry of
+// protocols, and its clone and fetch options cannot carry an http client, so
+// the TLS configuration of a single clone (certificate authorities and client
+// certificates) cannot be passed to it directly. Instead, the http and https
+// protocols are served by a client that looks up the scope of each request
+// from its context, which go-git derives from the context passed to
+// CloneContext and FetchContext.
+var (
+	installGitHTTPTransportOnce sync.Once
+	gitHTTPTransportInstalled   atomic.Bool
+)
+
+// InstallGitHTTPTransport replaces the http and https transports of go-git
+// for the whole process with the transport that git sources require to
+// apply the TLS configuration and credentials of each bundle. Programs that
+// unpack git sources must call it during their setup; git sources fail to
+// unpack http repositories until it is called.
+func InstallGitHTTPTransport() {
+	installGitHTTPTransportOnce.Do(func() {
+		transport := githttp.NewClient(&http.Client{
+			Transport:     contextRoundTripper{},
+			CheckRedirect: checkGitRedirect,
+		})
+		gitclient.InstallProtocol("http", transport)
+		gitclient.InstallProtocol("https", transport)
+		gitHTTPTransportInstalled.Store(true)
+	})
+}
+
+type gitHTTPScopeKey struct{}
+
+// gitHTTPScope is the http configuration of the clones and fetches of a
+// single repository. Client certificates and auth headers are only sent to
+// the host of the repository, so that they are not leaked to other hosts,
+// e.g. of submodules or of redirects.
+type gitHTTPScope struct {
+	repository string
+
+	// transport sends requests to the host of the repository.
+	transport *http.Transport
+
+	// otherHosts sends requests to other hosts, without client certificates.
+	otherHosts *http.Transport
+
+	// authHeaders are the headers that are removed from requests that are
+	// redirected to other hosts.
+	authHeaders []string
+}
+
+// withGitHTTPScope returns a context whose git http requests to the host of
+// the repository are sent with tlsConfig, and whose requests to other hosts
+// are sent without its client certificates or the headers of auth. The
+// returned function closes the idle connections of the scope.
+func withGitHTTPScope(ctx context.Context, repository string, tlsConfig *tls.Config, auth transport.AuthMethod) (context.Context, func()) {
+	scope := &gitHTTPScope{
+		repository:  repository,
+		transport:   http.DefaultTransport.(*http.Transport).Clone(),
+		otherHosts:  http.DefaultTransport.(*http.Transport).Clone(),
+		authHeaders: []string{"Authorization"},
+	}
+	scope.transport.TLSClientConfig = tlsConfig
+	if tlsConfig != nil {
+		scope.otherHosts.TLSClientConfig = tlsConfig.Clone()
+		scope.otherHosts.TLSClientConfig.Certificates = nil
+		scope.otherHosts.TLSClientConfig.GetClientCertificate = nil
+	}
+	if headerAuth, ok := auth.(*gitHTTPAuth); ok {
+		for k := range headerAuth.header {
+			scope.authHeaders = append(scope.authHeaders, k)
+		}
+	}
+	closeIdle := func() {
+		scope.transport.CloseIdleConnections()
+		scope.otherHosts.CloseIdleConnections()
+	}
+	return context.WithValue(ctx, gitHTTPScopeKey{}, scope), closeIdle
+}
+
+func (s *gitHTTPScope) roundTripper(req *http.Request) http.RoundTripper {
+	if sameGitHost(s.repository, req.URL.String()) {
+		return s.transport
+	}
+	return s.otherHosts
+}
+
+// contextRoundTripper sends requests with the round tripper of the scope
+// stored in the request's context, falling back to http.DefaultTransport.
+type contextRoundTripper struct{}
+
+func (contextRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if scope, ok := req.Context().Value(gitHTTPScopeKey{}).(*gitHTTPScope); ok {
+		return scope.roundTripper(req).RoundTrip(req)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// checkGitRedirect removes the auth headers of the scope of a request that is
+// redirected to another host. Like the default policy of http clients, it
+// stops after 10 redirects.
+func checkGitRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if scope, ok := req.Context().Value(gitHTTPScopeKey{}).(*gitHTTPScope); ok && !sameGitHost(scope.repository, req.URL.String()) {
+		for _, k := range scope.authHeaders {
+			req.Header.Del(k)
+		}
+	}
+	return nil
+}
+
+// gitHTTPAuth is a go-git http auth method that sets custom headers, such as
+// a bearer token, in addition to optional basic authentication credentials.
+type gitHTTPAuth struct {
+	basic  *githttp.BasicAuth
+	header http.Header
+}
+
+func (a *gitHTTPAuth) Name() string {
+	return "http-header-auth"
+}
+
+func (a *gitHTTPAuth) String() string {
+	headers := make([]string, 0, len(a.header))
+	for k := range a.header {
+		headers = append(headers, k)
+	}
+	sort.Strings(headers)
+	// Never print the header values, which are likely to be secret.
+	return fmt.Sprintf("%s - %v", a.Name(), headers)
+}
+
+func (a *gitHTTPAuth) SetAuth(r *http.Request) {
+	if a.basic != nil {
+		a.basic.SetAuth(r)
+	}
+	for k, v := range a.header {
+		r.Header[k] = v
+	}
+}
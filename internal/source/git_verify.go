@@ -0,0 +1,256 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage"
+	"golang.org/x/crypto/ssh"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+const (
+	pgpPublicKeyBlockHeader = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+	pgpSignatureHeader      = "-----BEGIN PGP SIGNATURE-----"
+	sshSignatureHeader      = "-----BEGIN SSH SIGNATURE-----"
+
+	// sshSignatureMagic is the preamble of SSH signatures, as defined by
+	// the PROTOCOL.sshsig file of OpenSSH.
+	sshSignatureMagic = "SSHSIG"
+
+	// sshSignatureNamespace is the namespace of the SSH signatures of git
+	// commits and tags.
+	sshSignatureNamespace = "git"
+)
+
+// gitKeys are the keys that are trusted to sign git commits and tags.
+type gitKeys struct {
+	pgp openpgp.EntityList
+	ssh []gitSSHKey
+}
+
+type gitSSHKey struct {
+	key     ssh.PublicKey
+	comment string
+}
+
+// getGitVerificationKeys reads the GPG and SSH public keys referenced by the
+// given verification from the configmap or secret in the given namespace.
+// Each data value is either an ASCII-armored GPG public key block or a list
+// of SSH public keys in the authorized_keys format.
+func getGitVerificationKeys(ctx context.Context, cl client.Reader, namespace string, verification *rukpakv1alpha1.GitVerification) (*gitKeys, error) {
+	data, source, err := getPublicKeysData(ctx, cl, namespace, verification.PublicKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := &gitKeys{}
+	for _, k := range sortedKeys(data) {
+		value := data[k]
+		if bytes.Contains(value, []byte(pgpPublicKeyBlockHeader)) {
+			entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(value))
+			if err != nil {
+				return nil, fmt.Errorf("parse GPG public key %q in %s: %v", k, source, err)
+			}
+			keys.pgp = append(keys.pgp, entities...)
+			continue
+		}
+		for _, line := range strings.Split(string(value), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+			if err != nil {
+				return nil, fmt.Errorf("parse SSH public key %q in %s: %v", k, source, err)
+			}
+			keys.ssh = append(keys.ssh, gitSSHKey{key: key, comment: comment})
+		}
+	}
+	if len(keys.pgp) == 0 && len(keys.ssh) == 0 {
+		return nil, fmt.Errorf("no public keys found in %s", source)
+	}
+	return keys, nil
+}
+
+// verifyGitCommit verifies that the given commit is signed by any of the
+// keys, and returns the identity of the signer.
+func verifyGitCommit(keys *gitKeys, storer storage.Storer, commitHash plumbing.Hash) (string, error) {
+	commit, err := object.GetCommit(storer, commitHash)
+	if err != nil {
+		return "", fmt.Errorf("get commit %q: %v", commitHash.String(), err)
+	}
+	if commit.PGPSignature == "" {
+		return "", fmt.Errorf("commit %q is not signed", commitHash.String())
+	}
+	payload := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(payload); err != nil {
+		return "", fmt.Errorf("encode commit %q: %v", commitHash.String(), err)
+	}
+	signer, err := keys.verify(payload, commit.PGPSignature)
+	if err != nil {
+		return "", fmt.Errorf("commit %q: %v", commitHash.String(), err)
+	}
+	return signer, nil
+}
+
+// verifyGitTag verifies that the given tag is an annotated tag signed by any
+// of the keys, and returns the identity of the signer.
+func verifyGitTag(keys *gitKeys, storer storage.Storer, tagName string) (string, error) {
+	ref, err := storer.Reference(plumbing.NewTagReferenceName(tagName))
+	if err != nil {
+		return "", fmt.Errorf("get tag %q: %v", tagName, err)
+	}
+	tag, err := object.GetTag(storer, ref.Hash())
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		// Lightweight tags reference commits rather than tag objects, so
+		// they cannot be signed.
+		return "", fmt.Errorf("tag %q is not an annotated tag", tagName)
+	}
+	if err != nil {
+		return "", fmt.Errorf("get tag %q: %v", tagName, err)
+	}
+	if tag.PGPSignature == "" {
+		return "", fmt.Errorf("tag %q is not signed", tagName)
+	}
+	payload := &plumbing.MemoryObject{}
+	if err := tag.EncodeWithoutSignature(payload); err != nil {
+		return "", fmt.Errorf("encode tag %q: %v", tagName, err)
+	}
+	signer, err := keys.verify(payload, tag.PGPSignature)
+	if err != nil {
+		return "", fmt.Errorf("tag %q: %v", tagName, err)
+	}
+	return signer, nil
+}
+
+// verify verifies that signature is a GPG or SSH signature of the payload
+// created by any of the keys, and returns the identity of the signer.
+func (k *gitKeys) verify(payload *plumbing.MemoryObject, signature string) (string, error) {
+	r, err := payload.Reader()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	switch {
+	case strings.HasPrefix(signature, pgpSignatureHeader):
+		entity, err := openpgp.CheckArmoredDetachedSignature(k.pgp, r, strings.NewReader(signature), nil)
+		if err != nil {
+			return "", fmt.Errorf("no valid GPG signature found for any of the trusted public keys: %v", err)
+		}
+		return pgpIdentity(entity), nil
+	case strings.HasPrefix(signature, sshSignatureHeader):
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return "", err
+		}
+		return k.verifySSH(data, signature)
+	}
+	return "", errors.New("signature is neither a GPG nor an SSH signature")
+}
+
+// sshSignature is the blob of an SSH signature, following its magic
+// preamble, as defined by the PROTOCOL.sshsig file of OpenSSH.
+type sshSignature struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      []byte
+	HashAlgorithm string
+	Signature     []byte
+}
+
+// sshSignedData is the data that is signed by an SSH signature, following
+// its magic preamble.
+type sshSignedData struct {
+	Namespace     string
+	Reserved      []byte
+	HashAlgorithm string
+	Hash          []byte
+}
+
+// verifySSH verifies that armored is an SSH signature of data, in the git
+// namespace, created by any of the keys, and returns the identity of the
+// signer.
+func (k *gitKeys) verifySSH(data []byte, armored string) (string, error) {
+	block, _ := pem.Decode([]byte(armored))
+	if block == nil || !bytes.HasPrefix(block.Bytes, []byte(sshSignatureMagic)) {
+		return "", errors.New("malformed SSH signature")
+	}
+	var sig sshSignature
+	if err := ssh.Unmarshal(block.Bytes[len(sshSignatureMagic):], &sig); err != nil {
+		return "", fmt.Errorf("malformed SSH signature: %v", err)
+	}
+	if sig.Version != 1 {
+		return "", fmt.Errorf("unsupported SSH signature version %d", sig.Version)
+	}
+	if sig.Namespace != sshSignatureNamespace {
+		return "", fmt.Errorf("SSH signature namespace is %q, expected %q", sig.Namespace, sshSignatureNamespace)
+	}
+	var h hash.Hash
+	switch sig.HashAlgorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("unsupported SSH signature hash algorithm %q", sig.HashAlgorithm)
+	}
+	h.Write(data)
+
+	var trusted *gitSSHKey
+	for i := range k.ssh {
+		if bytes.Equal(k.ssh[i].key.Marshal(), sig.PublicKey) {
+			trusted = &k.ssh[i]
+			break
+		}
+	}
+	if trusted == nil {
+		return "", errors.New("SSH signature is not signed by any of the trusted public keys")
+	}
+
+	signature := &ssh.Signature{}
+	if err := ssh.Unmarshal(sig.Signature, signature); err != nil {
+		return "", fmt.Errorf("malformed SSH signature: %v", err)
+	}
+	signed := append([]byte(sshSignatureMagic), ssh.Marshal(sshSignedData{
+		Namespace:     sig.Namespace,
+		Reserved:      sig.Reserved,
+		HashAlgorithm: sig.HashAlgorithm,
+		Hash:          h.Sum(nil),
+	})...)
+	if err := trusted.key.Verify(signed, signature); err != nil {
+		return "", fmt.Errorf("invalid SSH signature: %v", err)
+	}
+	return trusted.identity(), nil
+}
+
+func (k *gitSSHKey) identity() string {
+	fingerprint := ssh.FingerprintSHA256(k.key)
+	if k.comment == "" {
+		return fingerprint
+	}
+	return fmt.Sprintf("%s (%s)", k.comment, fingerprint)
+}
+
+func pgpIdentity(entity *openpgp.Entity) string {
+	keyID := entity.PrimaryKey.KeyIdString()
+	if identity := entity.PrimaryIdentity(); identity != nil {
+		return fmt.Sprintf("%s (%s)", identity.Name, keyID)
+	}
+	return keyID
+}
@@ -0,0 +1,169 @@
+package source
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+// helmRepositoryClientTimeout is the timeout of each request to a helm chart
+// repository. It is longer than that of http sources because the indexes of
+// large repositories are tens of megabytes in size.
+const helmRepositoryClientTimeout = time.Minute
+
+// HelmRepository is a bundle source that sources bundles from the charts in
+// a helm chart repository.
+type HelmRepository struct {
+	client.Reader
+	SecretNamespace string
+
+	// RootCAs are the certificate authorities used to verify the certificate
+	// of the repository server. If nil, the system certificate authorities
+	// are used.
+	RootCAs *x509.CertPool
+}
+
+// Unpack unpacks a bundle by resolving the chart version in the repository
+// index and downloading the chart archive.
+func (h *HelmRepository) Unpack(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (*Result, error) {
+	if bundle.Spec.Source.Type != rukpakv1alpha1.SourceTypeHelmRepository {
+		return nil, fmt.Errorf("cannot unpack source type %q with %q unpacker", bundle.Spec.Source.Type, rukpakv1alpha1.SourceTypeHelmRepository)
+	}
+	if bundle.Spec.Source.HelmRepository == nil {
+		return nil, fmt.Errorf("bundle source helmRepository configuration is unset")
+	}
+	repoSource := bundle.Spec.Source.HelmRepository
+
+	tlsConfig, err := getTLSConfig(ctx, h.Reader, h.SecretNamespace, repoSource.Auth, h.RootCAs)
+	if err != nil {
+		return nil, fmt.Errorf("configure TLS: %v", err)
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = tlsConfig
+	defer tr.CloseIdleConnections()
+	httpClient := &http.Client{Timeout: helmRepositoryClientTimeout, Transport: tr, CheckRedirect: authRedirectPolicy(repoSource.Auth)}
+
+	index, err := h.getIndex(ctx, httpClient, repoSource)
+	if err != nil {
+		return nil, err
+	}
+	chartVersion, err := index.Get(repoSource.Chart, repoSource.Version)
+	if err != nil {
+		return nil, fmt.Errorf("resolve version %q of chart %q: %v", repoSource.Version, repoSource.Chart, err)
+	}
+	if len(chartVersion.URLs) == 0 {
+		return nil, fmt.Errorf("chart %q version %q has no download URLs", repoSource.Chart, chartVersion.Version)
+	}
+	chartURL, err := repo.ResolveReferenceURL(repoSource.URL, chartVersion.URLs[0])
+	if err != nil {
+		return nil, fmt.Errorf("resolve URL of chart %q version %q: %v", repoSource.Chart, chartVersion.Version, err)
+	}
+	action := fmt.Sprintf("%s %s", http.MethodGet, chartURL)
+
+	resp, err := h.get(ctx, httpClient, repoSource, chartURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Hash the chart archive as it is read, so that the resolved source can
+	// pin the exact archive that was unpacked.
+	hasher := sha256.New()
+	body := io.TeeReader(resp.Body, hasher)
+	bundleFS, fsErr := archiveFS(body, rukpakv1alpha1.ArchiveFormatTarGz, "")
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return nil, fmt.Errorf("%s: read chart archive: %v", action, err)
+	}
+	digest := fmt.Sprintf("sha256:%x", hasher.Sum(nil))
+	if expected := repoSource.Digest; expected != "" && expected != digest {
+		return nil, permanentError(fmt.Errorf("%s: chart archive digest %q does not match expected digest %q", action, digest, expected))
+	}
+	if chartVersion.Digest != "" && "sha256:"+chartVersion.Digest != digest {
+		return nil, fmt.Errorf("%s: chart archive digest %q does not match the digest %q in the repository index", action, digest, chartVersion.Digest)
+	}
+	if fsErr != nil {
+		return nil, permanentError(fsErr)
+	}
+
+	resolvedSource := bundle.Spec.Source.DeepCopy()
+	resolvedSource.HelmRepository.Version = chartVersion.Version
+	resolvedSource.HelmRepository.Digest = digest
+
+	message := generateMessage("helmRepository")
+
+	return &Result{Bundle: bundleFS, ResolvedSource: resolvedSource, State: StateUnpacked, Message: message}, nil
+}
+
+// getIndex downloads and parses the index of the repository.
+func (h *HelmRepository) getIndex(ctx context.Context, httpClient *http.Client, repoSource *rukpakv1alpha1.HelmRepositorySource) (*repo.IndexFile, error) {
+	indexURL, err := repo.ResolveReferenceURL(repoSource.URL, "index.yaml")
+	if err != nil {
+		return nil, fmt.Errorf("resolve repository index URL: %v", err)
+	}
+	resp, err := h.get(ctx, httpClient, repoSource, indexURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read repository index %q: %v", indexURL, err)
+	}
+
+	index := &repo.IndexFile{}
+	if err := yaml.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("parse repository index %q: %v", indexURL, err)
+	}
+	if index.APIVersion == "" {
+		return nil, fmt.Errorf("parse repository index %q: %v", indexURL, repo.ErrNoAPIVersion)
+	}
+	// Get expects the versions of each chart to be sorted from newest to
+	// oldest, which the repository does not guarantee.
+	index.SortEntries()
+	return index, nil
+}
+
+// get sends a GET request for rawURL. The repository credentials are only
+// sent to the host of the repository, so that they are not leaked to other
+// hosts that charts are served from.
+func (h *HelmRepository) get(ctx context.Context, httpClient *http.Client, repoSource *rukpakv1alpha1.HelmRepositorySource, rawURL string) (*http.Response, error) {
+	action := fmt.Sprintf("%s %s", http.MethodGet, rawURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create http request %q: %v", action, err)
+	}
+	if sameHost(repoSource.URL, req.URL) {
+		if err := authorizeRequest(ctx, h.Reader, h.SecretNamespace, repoSource.Auth, req); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: http request failed: %v", action, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, httpStatusError(resp.StatusCode, fmt.Errorf("%s: unexpected status %q", action, resp.Status))
+	}
+	return resp, nil
+}
+
+func sameHost(rawURL string, u *url.URL) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(parsed.Scheme, u.Scheme) && strings.EqualFold(parsed.Host, u.Host)
+}
@@ -0,0 +1,123 @@
+package source
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+	"github.com/operator-framework/rukpak/internal/provisioner/helm"
+)
+
+var _ = Describe("HelmRepository", func() {
+	var (
+		ctx    context.Context
+		server *httptest.Server
+		charts map[string][]byte
+		index  string
+		source *HelmRepository
+		bundle *rukpakv1alpha1.Bundle
+	)
+
+	chartArchive := func(version string) []byte {
+		return tarGZ(map[string]string{
+			"podinfo/Chart.yaml":               fmt.Sprintf("apiVersion: v2\nname: podinfo\nversion: %s\n", version),
+			"podinfo/templates/configmap.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: podinfo\n",
+			"podinfo/values.yaml":              "{}\n",
+		})
+	}
+	indexEntry := func(version, digest string) string {
+		return fmt.Sprintf("  - apiVersion: v2\n    name: podinfo\n    version: %s\n    digest: %s\n    urls:\n    - charts/podinfo-%[1]s.tgz\n", version, digest)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		charts = map[string][]byte{}
+		index = "apiVersion: v1\nentries:\n  podinfo:\n"
+		for _, version := range []string{"1.0.0", "1.1.0", "2.0.0-rc.1"} {
+			charts[version] = chartArchive(version)
+			index += indexEntry(version, fmt.Sprintf("%x", sha256.Sum256(charts[version])))
+		}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/stable/index.yaml" {
+				_, _ = w.Write([]byte(index))
+				return
+			}
+			for version, data := range charts {
+				if r.URL.Path == fmt.Sprintf("/stable/charts/podinfo-%s.tgz", version) {
+					_, _ = w.Write(data)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+		}))
+
+		source = &HelmRepository{Reader: fake.NewClientBuilder().Build(), SecretNamespace: "rukpak-system"}
+		bundle = &rukpakv1alpha1.Bundle{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-bundle"},
+			Spec: rukpakv1alpha1.BundleSpec{
+				Source: rukpakv1alpha1.BundleSource{
+					Type: rukpakv1alpha1.SourceTypeHelmRepository,
+					HelmRepository: &rukpakv1alpha1.HelmRepositorySource{
+						URL:   server.URL + "/stable",
+						Chart: "podinfo",
+					},
+				},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should unpack the latest stable version of the chart", func() {
+		result, err := source.Unpack(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.State).To(Equal(StateUnpacked))
+		Expect(result.ResolvedSource.HelmRepository.Version).To(Equal("1.1.0"))
+		Expect(result.ResolvedSource.HelmRepository.Digest).To(Equal(fmt.Sprintf("sha256:%x", sha256.Sum256(charts["1.1.0"]))))
+
+		_, err = helm.HandleBundle(ctx, result.Bundle, bundle)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should unpack the latest version of the chart that satisfies the constraint", func() {
+		bundle.Spec.Source.HelmRepository.Version = ">=1.0.0 <1.1.0"
+		result, err := source.Unpack(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.ResolvedSource.HelmRepository.Version).To(Equal("1.0.0"))
+	})
+
+	It("should unpack an exact prerelease version of the chart", func() {
+		bundle.Spec.Source.HelmRepository.Version = "2.0.0-rc.1"
+		result, err := source.Unpack(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.ResolvedSource.HelmRepository.Version).To(Equal("2.0.0-rc.1"))
+	})
+
+	It("should fail when no version of the chart satisfies the constraint", func() {
+		bundle.Spec.Source.HelmRepository.Version = ">=3.0.0"
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring("no chart version found")))
+	})
+
+	It("should reject a chart archive whose digest does not match the repository index", func() {
+		charts["1.1.0"] = chartArchive("1.1.1")
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring("does not match the digest")))
+	})
+
+	It("should reject a chart archive whose digest does not match the expected digest", func() {
+		bundle.Spec.Source.HelmRepository.Digest = fmt.Sprintf("sha256:%x", sha256.Sum256(charts["1.0.0"]))
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring("does not match expected digest")))
+	})
+})
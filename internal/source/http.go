@@ -1,15 +1,15 @@
 package source
 
 import (
-	"compress/gzip"
+	"bytes"
 	"context"
-	"crypto/tls"
+	"crypto/sha256"
+	"crypto/x509"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
-	"github.com/nlepage/go-tarfs"
-	corev1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
@@ -19,6 +19,10 @@ import (
 type HTTP struct {
 	client.Reader
 	SecretNamespace string
+
+	// RootCAs are the certificate authorities used to verify the certificate
+	// of the server. If nil, the system certificate authorities are used.
+	RootCAs *x509.CertPool
 }
 
 // Unpack unpacks a bundle by requesting the bundle contents from a specified URL
@@ -34,21 +38,18 @@ func (b *HTTP) Unpack(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (*Resu
 	if err != nil {
 		return nil, fmt.Errorf("create http request %q for bundle content: %v", action, err)
 	}
-	var userName, password string
-	if bundle.Spec.Source.HTTP.Auth.Secret.Name != "" {
-		userName, password, err = b.getCredentials(ctx, bundle)
-		if err != nil {
-			return nil, err
-		}
-		req.SetBasicAuth(userName, password)
+	if err := authorizeRequest(ctx, b.Reader, b.SecretNamespace, bundle.Spec.Source.HTTP.Auth, req); err != nil {
+		return nil, err
 	}
 
-	httpClient := http.Client{Timeout: 10 * time.Second}
-	if bundle.Spec.Source.HTTP.Auth.InsecureSkipVerify {
-		tr := http.DefaultTransport.(*http.Transport).Clone()
-		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} // nolint:gosec
-		httpClient.Transport = tr
+	tlsConfig, err := getTLSConfig(ctx, b.Reader, b.SecretNamespace, bundle.Spec.Source.HTTP.Auth, b.RootCAs)
+	if err != nil {
+		return nil, fmt.Errorf("configure TLS: %v", err)
 	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = tlsConfig
+	defer tr.CloseIdleConnections()
+	httpClient := http.Client{Timeout: 10 * time.Second, Transport: tr, CheckRedirect: authRedirectPolicy(bundle.Spec.Source.HTTP.Auth)}
 
 	resp, err := httpClient.Do(req)
 	if err != nil {
@@ -56,33 +57,90 @@ func (b *HTTP) Unpack(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (*Resu
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("%s: unexpected status %q", action, resp.Status)
+		return nil, httpStatusError(resp.StatusCode, fmt.Errorf("%s: unexpected status %q", action, resp.Status))
 	}
 
-	tarReader, err := gzip.NewReader(resp.Body)
-	if err != nil {
-		return nil, err
+	// Hash the bundle content as it is read, so that the resolved source can
+	// pin the exact content that was unpacked.
+	hasher := sha256.New()
+	var body io.Reader = io.TeeReader(resp.Body, hasher)
+	if verification := bundle.Spec.Source.Verification; verification != nil {
+		// The signature covers the complete bundle content, so the content
+		// must be read in full before any of it is unpacked.
+		content, err := io.ReadAll(body)
+		if err != nil {
+			return nil, fmt.Errorf("%s: read bundle content: %v", action, err)
+		}
+		if err := b.verifySignature(ctx, &httpClient, bundle, verification, content); err != nil {
+			return nil, verificationError(err)
+		}
+		body = bytes.NewReader(content)
 	}
-	fs, err := tarfs.New(tarReader)
-	if err != nil {
-		return nil, fmt.Errorf("error creating FS: %s", err)
+
+	bundleFS, fsErr := archiveFS(body, bundle.Spec.Source.HTTP.Format, resp.Header.Get("Content-Type"))
+
+	// The archive readers may stop before the end of the content (e.g.
+	// at trailing padding), so read the rest of it to complete the digest.
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return nil, fmt.Errorf("%s: read bundle content: %v", action, err)
+	}
+	digest := fmt.Sprintf("sha256:%x", hasher.Sum(nil))
+	if expected := bundle.Spec.Source.HTTP.Digest; expected != "" && expected != digest {
+		return nil, permanentError(fmt.Errorf("%s: bundle content digest %q does not match expected digest %q", action, digest, expected))
 	}
+	if fsErr != nil {
+		// The content was read in full, so it is the content itself that
+		// cannot be unpacked.
+		return nil, permanentError(fsErr)
+	}
+
+	resolvedSource := bundle.Spec.Source.DeepCopy()
+	resolvedSource.HTTP.Digest = digest
 
 	message := generateMessage("http")
 
-	return &Result{Bundle: fs, ResolvedSource: bundle.Spec.Source.DeepCopy(), State: StateUnpacked, Message: message}, nil
+	return &Result{Bundle: bundleFS, ResolvedSource: resolvedSource, State: StateUnpacked, Message: message}, nil
 }
 
-// getCredentials reads credentials from the secret specified in the bundle
-// It returns the username ane password when they are in the secret
-func (b *HTTP) getCredentials(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (string, string, error) {
-	secret := &corev1.Secret{}
-	err := b.Get(ctx, client.ObjectKey{Namespace: b.SecretNamespace, Name: bundle.Spec.Source.HTTP.Auth.Secret.Name}, secret)
+// verifySignature verifies the detached signature of the bundle content,
+// which is downloaded from the verification's signature URL with the same
+// client and credentials as the bundle content.
+func (b *HTTP) verifySignature(ctx context.Context, httpClient *http.Client, bundle *rukpakv1alpha1.Bundle, verification *rukpakv1alpha1.Verification, content []byte) error {
+	keys, err := getVerificationKeys(ctx, b.Reader, b.SecretNamespace, verification)
 	if err != nil {
-		return "", "", err
+		return err
 	}
-	userName := string(secret.Data["username"])
-	password := string(secret.Data["password"])
 
-	return userName, password, nil
+	sigURL := verification.SignatureURL
+	if sigURL == "" {
+		sigURL = bundle.Spec.Source.HTTP.URL + ".sig"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sigURL, nil)
+	if err != nil {
+		return fmt.Errorf("create http request for signature %q: %v", sigURL, err)
+	}
+	// The credentials are only sent with signatures on the host of the
+	// bundle content.
+	if sameHost(bundle.Spec.Source.HTTP.URL, req.URL) {
+		if err := authorizeRequest(ctx, b.Reader, b.SecretNamespace, bundle.Spec.Source.HTTP.Auth, req); err != nil {
+			return err
+		}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("get signature %q: %v", sigURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get signature %q: unexpected status %q", sigURL, resp.Status)
+	}
+	encodedSig, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read signature %q: %v", sigURL, err)
+	}
+	sig, err := decodeSignature(encodedSig)
+	if err != nil {
+		return err
+	}
+	return verifyBlobSignature(keys, content, sig)
 }
@@ -0,0 +1,122 @@
+package source
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+var _ = Describe("HTTP", func() {
+	var (
+		ctx     context.Context
+		server  *httptest.Server
+		content []byte
+		status  int
+		digest  string
+		source  *HTTP
+		bundle  *rukpakv1alpha1.Bundle
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		content = tarGZ(map[string]string{"manifests/configmap.yaml": "kind: ConfigMap\n"})
+		digest = fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+		status = http.StatusOK
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(status)
+			_, _ = w.Write(content)
+		}))
+
+		source = &HTTP{}
+		bundle = &rukpakv1alpha1.Bundle{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-bundle"},
+			Spec: rukpakv1alpha1.BundleSpec{
+				Source: rukpakv1alpha1.BundleSource{
+					Type: rukpakv1alpha1.SourceTypeHTTP,
+					HTTP: &rukpakv1alpha1.HTTPSource{URL: server.URL + "/bundle.tgz"},
+				},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should record the digest of the content in the resolved source", func() {
+		result, err := source.Unpack(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.State).To(Equal(StateUnpacked))
+		Expect(result.ResolvedSource.HTTP.Digest).To(Equal(digest))
+		Expect(bundle.Spec.Source.HTTP.Digest).To(BeEmpty())
+	})
+
+	It("should unpack content that matches the expected digest", func() {
+		bundle.Spec.Source.HTTP.Digest = digest
+		result, err := source.Unpack(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.ResolvedSource.HTTP.Digest).To(Equal(digest))
+	})
+
+	It("should reject content that does not match the expected digest", func() {
+		bundle.Spec.Source.HTTP.Digest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("bundle content digest %q does not match", digest))))
+		Expect(IsPermanent(err)).To(BeTrue())
+	})
+
+	It("should report a digest mismatch rather than a format error for unexpected content", func() {
+		content = []byte("not a tarball")
+		bundle.Spec.Source.HTTP.Digest = digest
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring("does not match expected digest")))
+	})
+
+	It("should report client errors as permanent errors", func() {
+		status = http.StatusForbidden
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(HaveOccurred())
+		Expect(IsPermanent(err)).To(BeTrue())
+	})
+
+	It("should report missing content as a transient error", func() {
+		status = http.StatusNotFound
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(HaveOccurred())
+		Expect(IsPermanent(err)).To(BeFalse())
+	})
+
+	It("should not send the credentials and custom headers to the targets of redirects on other hosts", func() {
+		var received http.Header
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header.Clone()
+			_, _ = w.Write(content)
+		}))
+		defer target.Close()
+		redirect := httptest.NewServer(http.RedirectHandler(target.URL+"/bundle.tgz", http.StatusFound))
+		defer redirect.Close()
+
+		bundle.Spec.Source.HTTP.URL = redirect.URL + "/bundle.tgz"
+		bundle.Spec.Source.HTTP.Auth.Headers = []rukpakv1alpha1.HTTPHeader{{Name: "X-Api-Key", Value: "secret-key"}}
+		result, err := source.Unpack(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.State).To(Equal(StateUnpacked))
+		Expect(received).NotTo(BeNil())
+		Expect(received.Get("X-Api-Key")).To(BeEmpty())
+	})
+
+	It("should report server errors as transient errors", func() {
+		status = http.StatusServiceUnavailable
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(HaveOccurred())
+		Expect(IsPermanent(err)).To(BeFalse())
+	})
+})
@@ -4,12 +4,19 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
-	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"net/http"
+	"os"
 	"strings"
 
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/nlepage/go-tarfs"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
@@ -22,6 +29,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+	"github.com/operator-framework/rukpak/internal/unpack"
 	"github.com/operator-framework/rukpak/internal/util"
 )
 
@@ -30,9 +38,26 @@ type Image struct {
 	KubeClient   kubernetes.Interface
 	PodNamespace string
 	UnpackImage  string
+	Upload       ImageUploadConfig
+
+	// PodTemplateConfigMap is the name of a configmap in PodNamespace whose
+	// UnpackPodTemplateKey holds a pod template that is merged into the
+	// unpack pods. If empty, the unpack pods are not customized.
+	PodTemplateConfigMap string
+
+	// Lifecycle configures the cleanup, concurrency and timeout of the
+	// unpack pods.
+	Lifecycle ImagePodLifecycleConfig
+
+	// Transport is used to fetch image signatures from the image registry
+	// when a bundle's signature is verified.
+	Transport http.RoundTripper
 }
 
-const imageBundleUnpackContainerName = "bundle"
+const (
+	imageBundleUnpackContainerName = "bundle"
+	imageUnpackerInitContainerName = "install-unpacker"
+)
 
 func (i *Image) Unpack(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (*Result, error) {
 	if bundle.Spec.Source.Type != rukpakv1alpha1.SourceTypeImage {
@@ -42,6 +67,14 @@ func (i *Image) Unpack(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (*Res
 		return nil, fmt.Errorf("bundle source image configuration is unset")
 	}
 
+	if err := i.ensureUploadSecret(ctx, bundle); err != nil {
+		return nil, fmt.Errorf("ensure upload token: %v", err)
+	}
+
+	if queued, err := i.queuedPodResult(ctx, bundle); err != nil || queued != nil {
+		return queued, err
+	}
+
 	pod := &corev1.Pod{}
 	op, err := i.ensureUnpackPod(ctx, bundle, pod)
 	if err != nil {
@@ -52,25 +85,77 @@ func (i *Image) Unpack(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (*Res
 
 	switch phase := pod.Status.Phase; phase {
 	case corev1.PodPending:
-		return pendingImagePodResult(pod), nil
+		result := pendingImagePodResult(pod)
+		if err := i.checkUnpackTimeout(ctx, pod, result); err != nil {
+			return nil, err
+		}
+		return result, nil
 	case corev1.PodRunning:
-		return &Result{State: StateUnpacking}, nil
+		result := &Result{State: StateUnpacking}
+		if err := i.checkUnpackTimeout(ctx, pod, result); err != nil {
+			return nil, err
+		}
+		return result, nil
 	case corev1.PodFailed:
 		return nil, i.failedPodResult(ctx, pod)
 	case corev1.PodSucceeded:
-		return i.succeededPodResult(ctx, pod)
+		return i.succeededPodResult(ctx, bundle, pod)
 	default:
 		return nil, i.handleUnexpectedPod(ctx, pod)
 	}
 }
 
+// ensureUploadSecret ensures that a secret exists that holds the token the
+// unpack pod uses to authenticate when uploading the bundle content, along
+// with the certificate authority to verify the upload server. The secret is
+// named after the UID of the bundle, so that a bundle that is recreated with
+// the same name does not reuse the token of the deleted bundle, and it is
+// owned by the bundle so that it is garbage collected with it.
+func (i *Image) ensureUploadSecret(ctx context.Context, bundle *rukpakv1alpha1.Bundle) error {
+	existingSecret := &corev1.Secret{}
+	err := i.Client.Get(ctx, client.ObjectKey{Namespace: i.PodNamespace, Name: imageUploadSecretName(bundle.UID)}, existingSecret)
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      imageUploadSecretName(bundle.UID),
+			Namespace: i.PodNamespace,
+			Labels: map[string]string{
+				util.CoreOwnerKindKey: bundle.Kind,
+				util.CoreOwnerNameKey: bundle.Name,
+			},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(bundle, bundle.GroupVersionKind())},
+		},
+		Data: map[string][]byte{
+			imageUploadSecretTokenKey: []byte(hex.EncodeToString(token)),
+			imageUploadSecretCAKey:    i.Upload.CAData,
+		},
+	}
+	if err := i.Client.Create(ctx, secret); err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
 func (i *Image) ensureUnpackPod(ctx context.Context, bundle *rukpakv1alpha1.Bundle, pod *corev1.Pod) (controllerutil.OperationResult, error) {
 	existingPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: i.PodNamespace, Name: bundle.Name}}
 	if err := i.Client.Get(ctx, client.ObjectKeyFromObject(existingPod), existingPod); client.IgnoreNotFound(err) != nil {
 		return controllerutil.OperationResultNone, err
 	}
 
-	podApplyConfig := i.getDesiredPodApplyConfig(bundle)
+	podApplyConfig, err := i.getDesiredPodApplyConfig(ctx, bundle)
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
 	updatedPod, err := i.KubeClient.CoreV1().Pods(i.PodNamespace).Apply(ctx, podApplyConfig, metav1.ApplyOptions{Force: true, FieldManager: "rukpak-core"})
 	if err != nil {
 		if !apierrors.IsInvalid(err) {
@@ -99,7 +184,31 @@ func (i *Image) ensureUnpackPod(ctx context.Context, bundle *rukpakv1alpha1.Bund
 	return controllerutil.OperationResultUpdated, nil
 }
 
-func (i *Image) getDesiredPodApplyConfig(bundle *rukpakv1alpha1.Bundle) *applyconfigurationcorev1.PodApplyConfiguration {
+func (i *Image) getDesiredPodApplyConfig(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (*applyconfigurationcorev1.PodApplyConfiguration, error) {
+	// The unpack pod is layered from the defaults, which the pod templates can
+	// override, the cluster-wide pod template, the bundle's pod overrides and
+	// the fields that rukpak requires to unpack the bundle, which always win.
+	layers := []interface{}{i.getDefaultPodApplyConfig(bundle)}
+	pt, err := i.getPodTemplate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if pt.template != nil {
+		layers = append(layers, pt.template)
+	}
+	if overrides := bundle.Spec.Source.Image.PodOverrides; overrides != nil {
+		// Bundles that set overrides which are not allowed fail until the
+		// bundle or the allowed overrides change.
+		if err := pt.checkPodOverrides(overrides); err != nil {
+			return nil, err
+		}
+		layers = append(layers, podOverridesPatch(overrides))
+	}
+	layers = append(layers, i.getRequiredPodApplyConfig(bundle))
+	return mergePodLayers(layers...)
+}
+
+func (i *Image) getDefaultPodApplyConfig(bundle *rukpakv1alpha1.Bundle) *applyconfigurationcorev1.PodApplyConfiguration {
 	// TODO (tyslaton): Address unpacker pod allowing root users for image sources
 	//
 	// In our current implementation, we are creating a pod that uses the image
@@ -118,6 +227,28 @@ func (i *Image) getDesiredPodApplyConfig(bundle *rukpakv1alpha1.Bundle) *applyco
 			WithDrop("ALL"),
 		)
 
+	return applyconfigurationcorev1.Pod(bundle.Name, i.PodNamespace).
+		WithSpec(applyconfigurationcorev1.PodSpec().
+			WithAutomountServiceAccountToken(false).
+			WithInitContainers(applyconfigurationcorev1.Container().
+				WithName(imageUnpackerInitContainerName).
+				WithImagePullPolicy(corev1.PullIfNotPresent).
+				WithSecurityContext(containerSecurityContext),
+			).
+			WithContainers(applyconfigurationcorev1.Container().
+				WithName(imageBundleUnpackContainerName).
+				WithSecurityContext(containerSecurityContext),
+			).
+			WithSecurityContext(applyconfigurationcorev1.PodSecurityContext().
+				WithRunAsNonRoot(false).
+				WithSeccompProfile(applyconfigurationcorev1.SeccompProfile().
+					WithType(corev1.SeccompProfileTypeRuntimeDefault),
+				),
+			),
+		)
+}
+
+func (i *Image) getRequiredPodApplyConfig(bundle *rukpakv1alpha1.Bundle) *applyconfigurationcorev1.PodApplyConfiguration {
 	podApply := applyconfigurationcorev1.Pod(bundle.Name, i.PodNamespace).
 		WithLabels(map[string]string{
 			util.CoreOwnerKindKey: bundle.Kind,
@@ -132,38 +263,45 @@ func (i *Image) getDesiredPodApplyConfig(bundle *rukpakv1alpha1.Bundle) *applyco
 			WithBlockOwnerDeletion(true),
 		).
 		WithSpec(applyconfigurationcorev1.PodSpec().
-			WithAutomountServiceAccountToken(false).
 			WithRestartPolicy(corev1.RestartPolicyNever).
 			WithInitContainers(applyconfigurationcorev1.Container().
-				WithName("install-unpacker").
+				WithName(imageUnpackerInitContainerName).
 				WithImage(i.UnpackImage).
-				WithImagePullPolicy(corev1.PullIfNotPresent).
 				WithCommand("cp", "-Rv", "/unpack", "/util/bin/unpack").
 				WithVolumeMounts(applyconfigurationcorev1.VolumeMount().
 					WithName("util").
 					WithMountPath("/util/bin"),
-				).
-				WithSecurityContext(containerSecurityContext),
+				),
 			).
 			WithContainers(applyconfigurationcorev1.Container().
 				WithName(imageBundleUnpackContainerName).
 				WithImage(bundle.Spec.Source.Image.Ref).
-				WithCommand("/bin/unpack", "--bundle-dir", "/").
-				WithVolumeMounts(applyconfigurationcorev1.VolumeMount().
-					WithName("util").
-					WithMountPath("/bin"),
+				WithCommand("/bin/unpack",
+					"--bundle-dir", "/",
+					"--upload-url", fmt.Sprintf("%s%s%s.tgz", strings.TrimSuffix(i.Upload.BaseURL, "/"), ImageUploadPathPrefix, bundle.UID),
+					"--upload-token-file", "/etc/unpack/"+imageUploadSecretTokenKey,
+					"--upload-ca-file", "/etc/unpack/"+imageUploadSecretCAKey,
 				).
-				WithSecurityContext(containerSecurityContext),
-			).
-			WithVolumes(applyconfigurationcorev1.Volume().
-				WithName("util").
-				WithEmptyDir(applyconfigurationcorev1.EmptyDirVolumeSource()),
-			).
-			WithSecurityContext(applyconfigurationcorev1.PodSecurityContext().
-				WithRunAsNonRoot(false).
-				WithSeccompProfile(applyconfigurationcorev1.SeccompProfile().
-					WithType(corev1.SeccompProfileTypeRuntimeDefault),
+				WithTerminationMessagePolicy(corev1.TerminationMessageFallbackToLogsOnError).
+				WithVolumeMounts(
+					applyconfigurationcorev1.VolumeMount().
+						WithName("util").
+						WithMountPath("/bin"),
+					applyconfigurationcorev1.VolumeMount().
+						WithName("upload").
+						WithMountPath("/etc/unpack").
+						WithReadOnly(true),
 				),
+			).
+			WithVolumes(
+				applyconfigurationcorev1.Volume().
+					WithName("util").
+					WithEmptyDir(applyconfigurationcorev1.EmptyDirVolumeSource()),
+				applyconfigurationcorev1.Volume().
+					WithName("upload").
+					WithSecret(applyconfigurationcorev1.SecretVolumeSource().
+						WithSecretName(imageUploadSecretName(bundle.UID)),
+					),
 			),
 		)
 
@@ -188,24 +326,61 @@ func (i *Image) failedPodResult(ctx context.Context, pod *corev1.Pod) error {
 	if err != nil {
 		return fmt.Errorf("unpack failed: failed to retrieve failed pod logs: %v", err)
 	}
-	_ = i.Client.Delete(ctx, pod)
-	return fmt.Errorf("unpack failed: %v", string(logs))
-}
+	retryAfter := i.deleteExpiredPod(ctx, pod, podFinishedAt(pod))
+	err = fmt.Errorf("unpack failed: %v", string(logs))
 
-func (i *Image) succeededPodResult(ctx context.Context, pod *corev1.Pod) (*Result, error) {
-	bundleFS, err := i.getBundleContents(ctx, pod)
-	if err != nil {
-		return nil, fmt.Errorf("get bundle contents: %v", err)
+	for _, cStatus := range pod.Status.ContainerStatuses {
+		if cStatus.Name != imageBundleUnpackContainerName || cStatus.State.Terminated == nil {
+			continue
+		}
+		switch cStatus.State.Terminated.ExitCode {
+		case unpack.ExitCodeUploadFailed:
+			return &UnpackError{Reason: rukpakv1alpha1.ReasonUnpackUploadFailed, Err: err, RetryAfter: retryAfter}
+		case unpack.ExitCodeContentTooLarge:
+			return permanentError(&UnpackError{Reason: rukpakv1alpha1.ReasonUnpackContentTooLarge, Err: err})
+		}
 	}
+	return &UnpackError{Reason: rukpakv1alpha1.ReasonUnpackFailed, Err: err, RetryAfter: retryAfter}
+}
 
+func (i *Image) succeededPodResult(ctx context.Context, bundle *rukpakv1alpha1.Bundle, pod *corev1.Pod) (*Result, error) {
 	digest, err := i.getBundleImageDigest(pod)
 	if err != nil {
 		return nil, fmt.Errorf("get bundle image digest: %v", err)
 	}
 
+	// Verify the signature before reading the uploaded content, so that the
+	// content remains available if verification succeeds on a later attempt
+	// (e.g. once the image has been signed).
+	if verification := bundle.Spec.Source.Verification; verification != nil {
+		if err := i.verifySignature(ctx, bundle, verification, digest); err != nil {
+			return nil, verificationError(err)
+		}
+	}
+
+	bundleFS, err := i.getBundleContents(bundle)
+	if errors.Is(err, os.ErrNotExist) {
+		// The unpack pod succeeded, but the content it uploaded is no longer
+		// available (e.g. because the provisioner restarted without a
+		// persistent content directory). Delete the pod so that the bundle
+		// is unpacked again.
+		if err := i.Client.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+			return nil, err
+		}
+		return &Result{State: StatePending, Message: "uploaded bundle content not found, unpacking bundle again"}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get bundle contents: %v", err)
+	}
+
+	if err := i.deleteSucceededPod(ctx, pod); err != nil {
+		return nil, err
+	}
+
 	resolvedSource := &rukpakv1alpha1.BundleSource{
-		Type:  rukpakv1alpha1.SourceTypeImage,
-		Image: &rukpakv1alpha1.ImageSource{Ref: digest},
+		Type:         rukpakv1alpha1.SourceTypeImage,
+		Image:        &rukpakv1alpha1.ImageSource{Ref: digest},
+		Verification: bundle.Spec.Source.Verification.DeepCopy(),
 	}
 
 	message := generateMessage("image")
@@ -213,24 +388,66 @@ func (i *Image) succeededPodResult(ctx context.Context, pod *corev1.Pod) (*Resul
 	return &Result{Bundle: bundleFS, ResolvedSource: resolvedSource, State: StateUnpacked, Message: message}, nil
 }
 
-func (i *Image) getBundleContents(ctx context.Context, pod *corev1.Pod) (fs.FS, error) {
-	bundleData, err := i.getPodLogs(ctx, pod)
+// verifySignature verifies the signature of the bundle image that was pulled
+// by the unpack pod. The signature is fetched from the repository of the
+// bundle's image reference, using the bundle's image pull secret.
+func (i *Image) verifySignature(ctx context.Context, bundle *rukpakv1alpha1.Bundle, verification *rukpakv1alpha1.Verification, imageID string) error {
+	keys, err := getVerificationKeys(ctx, i.Client, i.PodNamespace, verification)
 	if err != nil {
-		return nil, fmt.Errorf("get bundle contents: %v", err)
+		return err
 	}
-	bd := struct {
-		Content []byte `json:"content"`
-	}{}
 
-	if err := json.Unmarshal(bundleData, &bd); err != nil {
-		return nil, fmt.Errorf("parse bundle data: %v", err)
+	ref, err := name.ParseReference(bundle.Spec.Source.Image.Ref)
+	if err != nil {
+		return fmt.Errorf("parse image reference %q: %v", bundle.Spec.Source.Image.Ref, err)
+	}
+	// The image ID reported by the container runtime may refer to a
+	// different (e.g. mirrored) repository, so only its digest is used.
+	_, digest, ok := strings.Cut(imageID, "@")
+	if !ok {
+		return fmt.Errorf("image ID %q does not contain a digest", imageID)
 	}
 
-	gzr, err := gzip.NewReader(bytes.NewReader(bd.Content))
+	keychain := authn.DefaultKeychain
+	if bundle.Spec.Source.Image.ImagePullSecretName != "" {
+		keychain, err = getPullSecretKeychain(ctx, i.Client, i.PodNamespace, bundle.Spec.Source.Image.ImagePullSecretName)
+		if err != nil {
+			return err
+		}
+	}
+	remoteOpts := []remote.Option{
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(keychain),
+	}
+	if i.Transport != nil {
+		remoteOpts = append(remoteOpts, remote.WithTransport(i.Transport))
+	}
+	return verifyImageSignature(keys, ref.Context().Digest(digest), remoteOpts...)
+}
+
+func (i *Image) getBundleContents(bundle *rukpakv1alpha1.Bundle) (fs.FS, error) {
+	contentPath := imageContentPath(i.Upload.ContentDir, bundle.UID)
+	contentFile, err := os.Open(contentPath)
+	if err != nil {
+		return nil, err
+	}
+	defer contentFile.Close()
+
+	gzr, err := gzip.NewReader(contentFile)
 	if err != nil {
 		return nil, fmt.Errorf("read bundle content gzip: %v", err)
 	}
-	return tarfs.New(gzr)
+	bundleFS, err := tarfs.New(gzr)
+	if err != nil {
+		return nil, fmt.Errorf("untar bundle content: %v", err)
+	}
+
+	// The bundle content is fully loaded into memory, so the uploaded file is
+	// no longer needed.
+	if err := os.Remove(contentPath); err != nil {
+		return nil, fmt.Errorf("remove uploaded bundle content: %v", err)
+	}
+	return bundleFS, nil
 }
 
 func (i *Image) getBundleImageDigest(pod *corev1.Pod) (string, error) {
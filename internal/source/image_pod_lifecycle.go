@@ -0,0 +1,159 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+	"github.com/operator-framework/rukpak/internal/util"
+)
+
+// ImagePodCleanupPolicy selects what happens to the pods that successfully
+// unpacked image bundles.
+type ImagePodCleanupPolicy string
+
+const (
+	// ImagePodCleanupPolicyRetain keeps the unpack pods of unpacked bundles
+	// until the bundles are deleted.
+	ImagePodCleanupPolicyRetain ImagePodCleanupPolicy = "retain"
+
+	// ImagePodCleanupPolicyDeleteOnSuccess deletes the unpack pods as soon
+	// as the content they uploaded has been unpacked.
+	ImagePodCleanupPolicyDeleteOnSuccess ImagePodCleanupPolicy = "delete-on-success"
+)
+
+// ImagePodCleanupPolicies is the list of supported image unpack pod cleanup policies.
+var ImagePodCleanupPolicies = []ImagePodCleanupPolicy{ImagePodCleanupPolicyRetain, ImagePodCleanupPolicyDeleteOnSuccess}
+
+// imagePodQueueInterval is how often bundles that wait for an unpack pod
+// slot check whether one is available.
+const imagePodQueueInterval = 10 * time.Second
+
+// ImagePodLifecycleConfig configures the lifecycle of the pods that unpack
+// image bundles.
+type ImagePodLifecycleConfig struct {
+	// CleanupPolicy selects what happens to the pods that successfully
+	// unpacked bundles. If empty, they are retained.
+	CleanupPolicy ImagePodCleanupPolicy
+
+	// FailedPodTTL is how long pods that failed or timed out are kept, e.g.
+	// for troubleshooting, before they are deleted so that the bundle is
+	// unpacked again by a new pod. While a failed pod is kept, the bundle
+	// keeps failing with the error of that pod.
+	FailedPodTTL time.Duration
+
+	// MaxConcurrentPods is the maximum number of unpack pods that may be
+	// pending or running in the pod namespace at the same time. Bundles that
+	// need an unpack pod when the maximum is reached stay pending until
+	// another pod finishes. If zero, the number of pods is not limited.
+	MaxConcurrentPods int
+
+	// UnpackTimeout is how long an unpack pod may be pending or running
+	// before the bundle fails, e.g. because its image cannot be pulled. If
+	// zero, unpack pods never time out.
+	UnpackTimeout time.Duration
+}
+
+// queuedPodResult returns a pending result if the bundle has no unpack pod
+// yet and the maximum number of concurrent unpack pods has been reached, or
+// nil if the bundle can proceed to create or observe its unpack pod.
+func (i *Image) queuedPodResult(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (*Result, error) {
+	maxPods := i.Lifecycle.MaxConcurrentPods
+	if maxPods <= 0 {
+		return nil, nil
+	}
+	existingPod := &corev1.Pod{}
+	err := i.Client.Get(ctx, client.ObjectKey{Namespace: i.PodNamespace, Name: bundle.Name}, existingPod)
+	if err == nil {
+		return nil, nil
+	}
+	if client.IgnoreNotFound(err) != nil {
+		return nil, err
+	}
+
+	pods := &corev1.PodList{}
+	if err := i.Client.List(ctx, pods, client.InNamespace(i.PodNamespace), client.HasLabels{util.CoreOwnerKindKey, util.CoreOwnerNameKey}); err != nil {
+		return nil, fmt.Errorf("list unpack pods: %v", err)
+	}
+	active := 0
+	for _, pod := range pods.Items {
+		if pod.Labels[util.CoreOwnerKindKey] != rukpakv1alpha1.BundleKind || pod.DeletionTimestamp != nil {
+			continue
+		}
+		if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+			active++
+		}
+	}
+	if active < maxPods {
+		return nil, nil
+	}
+	return &Result{
+		State:        StatePending,
+		Message:      fmt.Sprintf("waiting for an unpack pod slot: %d of %d unpack pods are pending or running", active, maxPods),
+		RequeueAfter: imagePodQueueInterval,
+	}, nil
+}
+
+// checkUnpackTimeout returns an error if the unfinished unpack pod has been
+// pending or running for longer than the unpack timeout. Otherwise, the
+// result is requeued at the deadline of the pod, so that the timeout fires
+// even if the pod does not change again. The pod is deleted once the failed
+// pod TTL has passed since it timed out.
+func (i *Image) checkUnpackTimeout(ctx context.Context, pod *corev1.Pod, result *Result) error {
+	timeout := i.Lifecycle.UnpackTimeout
+	if timeout <= 0 {
+		return nil
+	}
+	deadline := pod.CreationTimestamp.Add(timeout)
+	if untilDeadline := time.Until(deadline); untilDeadline > 0 {
+		if result.RequeueAfter == 0 || untilDeadline < result.RequeueAfter {
+			result.RequeueAfter = untilDeadline
+		}
+		return nil
+	}
+
+	err := fmt.Errorf("unpack timed out: pod %q did not finish within %v", pod.Name, timeout)
+	if result.Message != "" {
+		err = fmt.Errorf("%v: %s", err, result.Message)
+	}
+	return &UnpackError{Reason: rukpakv1alpha1.ReasonUnpackTimedOut, Err: err, RetryAfter: i.deleteExpiredPod(ctx, pod, deadline)}
+}
+
+// deleteExpiredPod deletes the unpack pod that failed at the given time once
+// the failed pod TTL has passed, so that the bundle is unpacked again. It
+// returns the time until the pod is deleted if it is kept.
+func (i *Image) deleteExpiredPod(ctx context.Context, pod *corev1.Pod, failedAt time.Time) time.Duration {
+	if untilExpiry := time.Until(failedAt.Add(i.Lifecycle.FailedPodTTL)); untilExpiry > 0 {
+		return untilExpiry
+	}
+	_ = i.Client.Delete(ctx, pod)
+	return 0
+}
+
+// deleteSucceededPod deletes the unpack pod of an unpacked bundle if the
+// cleanup policy requires it.
+func (i *Image) deleteSucceededPod(ctx context.Context, pod *corev1.Pod) error {
+	if i.Lifecycle.CleanupPolicy != ImagePodCleanupPolicyDeleteOnSuccess {
+		return nil
+	}
+	if err := i.Client.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("delete unpack pod: %v", err)
+	}
+	return nil
+}
+
+// podFinishedAt returns the time at which the last container of a finished
+// pod terminated, or its creation time if no container terminated.
+func podFinishedAt(pod *corev1.Pod) time.Time {
+	finishedAt := pod.CreationTimestamp.Time
+	for _, cStatus := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		if terminated := cStatus.State.Terminated; terminated != nil && terminated.FinishedAt.After(finishedAt) {
+			finishedAt = terminated.FinishedAt.Time
+		}
+	}
+	return finishedAt
+}
@@ -0,0 +1,172 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+	"github.com/operator-framework/rukpak/internal/util"
+)
+
+var _ = Describe("Image unpack pod lifecycle", func() {
+	var (
+		ctx    context.Context
+		source *Image
+		bundle *rukpakv1alpha1.Bundle
+	)
+
+	unpackPod := func(name string, phase corev1.PodPhase, created time.Time) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "rukpak-system",
+				CreationTimestamp: metav1.NewTime(created),
+				Labels: map[string]string{
+					util.CoreOwnerKindKey: rukpakv1alpha1.BundleKind,
+					util.CoreOwnerNameKey: name,
+				},
+			},
+			Status: corev1.PodStatus{Phase: phase},
+		}
+	}
+
+	podExists := func(pod *corev1.Pod) bool {
+		err := source.Client.Get(ctx, client.ObjectKeyFromObject(pod), &corev1.Pod{})
+		Expect(client.IgnoreNotFound(err)).NotTo(HaveOccurred())
+		return !apierrors.IsNotFound(err)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		source = &Image{
+			Client:       fake.NewClientBuilder().Build(),
+			PodNamespace: "rukpak-system",
+		}
+		bundle = &rukpakv1alpha1.Bundle{ObjectMeta: metav1.ObjectMeta{Name: "test-bundle"}}
+	})
+
+	Describe("concurrency", func() {
+		BeforeEach(func() {
+			source.Lifecycle.MaxConcurrentPods = 2
+			source.Client = fake.NewClientBuilder().WithObjects(
+				unpackPod("running", corev1.PodRunning, time.Now()),
+				unpackPod("pending", corev1.PodPending, time.Now()),
+				unpackPod("succeeded", corev1.PodSucceeded, time.Now()),
+			).Build()
+		})
+
+		It("should queue bundles when the maximum number of unpack pods is active", func() {
+			result, err := source.queuedPodResult(ctx, bundle)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.State).To(Equal(StatePending))
+			Expect(result.Message).To(ContainSubstring("2 of 2 unpack pods are pending or running"))
+			Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+		})
+
+		It("should not queue bundles below the maximum", func() {
+			source.Lifecycle.MaxConcurrentPods = 3
+			Expect(source.queuedPodResult(ctx, bundle)).To(BeNil())
+		})
+
+		It("should not queue bundles that already have an unpack pod", func() {
+			bundle.Name = "pending"
+			Expect(source.queuedPodResult(ctx, bundle)).To(BeNil())
+		})
+	})
+
+	Describe("timeouts", func() {
+		BeforeEach(func() {
+			source.Lifecycle.UnpackTimeout = time.Minute
+		})
+
+		It("should fail bundles whose unpack pod is pending past the timeout", func() {
+			pod := unpackPod("test-bundle", corev1.PodPending, time.Now().Add(-2*time.Minute))
+			Expect(source.Client.Create(ctx, pod)).To(Succeed())
+
+			err := source.checkUnpackTimeout(ctx, pod, &Result{State: StatePending, Message: "Back-off pulling image"})
+			var unpackErr *UnpackError
+			Expect(errors.As(err, &unpackErr)).To(BeTrue())
+			Expect(unpackErr.Reason).To(Equal(rukpakv1alpha1.ReasonUnpackTimedOut))
+			Expect(err).To(MatchError(ContainSubstring("Back-off pulling image")))
+			Expect(podExists(pod)).To(BeFalse())
+		})
+
+		It("should keep timed out pods until the failed pod TTL has passed", func() {
+			source.Lifecycle.FailedPodTTL = time.Hour
+			pod := unpackPod("test-bundle", corev1.PodPending, time.Now().Add(-2*time.Minute))
+			Expect(source.Client.Create(ctx, pod)).To(Succeed())
+
+			err := source.checkUnpackTimeout(ctx, pod, &Result{State: StatePending})
+			var unpackErr *UnpackError
+			Expect(errors.As(err, &unpackErr)).To(BeTrue())
+			Expect(unpackErr.RetryAfter).To(BeNumerically("~", time.Hour-time.Minute, time.Minute))
+			Expect(podExists(pod)).To(BeTrue())
+		})
+
+		It("should fail bundles whose unpack pod is running past the timeout", func() {
+			pod := unpackPod("test-bundle", corev1.PodRunning, time.Now().Add(-2*time.Minute))
+			Expect(source.Client.Create(ctx, pod)).To(Succeed())
+
+			err := source.checkUnpackTimeout(ctx, pod, &Result{State: StateUnpacking})
+			var unpackErr *UnpackError
+			Expect(errors.As(err, &unpackErr)).To(BeTrue())
+			Expect(unpackErr.Reason).To(Equal(rukpakv1alpha1.ReasonUnpackTimedOut))
+			Expect(unpackErr.RetryAfter).To(BeZero())
+			Expect(podExists(pod)).To(BeFalse())
+		})
+
+		It("should requeue bundles whose unpack pod is within the timeout at its deadline", func() {
+			pod := unpackPod("test-bundle", corev1.PodRunning, time.Now().Add(-20*time.Second))
+			result := &Result{State: StateUnpacking}
+			Expect(source.checkUnpackTimeout(ctx, pod, result)).To(Succeed())
+			Expect(result.RequeueAfter).To(BeNumerically("~", 40*time.Second, 5*time.Second))
+
+			result = &Result{State: StatePending, RequeueAfter: 10 * time.Second}
+			Expect(source.checkUnpackTimeout(ctx, pod, result)).To(Succeed())
+			Expect(result.RequeueAfter).To(Equal(10 * time.Second))
+		})
+	})
+
+	Describe("cleanup", func() {
+		It("should delete succeeded pods with the delete-on-success policy", func() {
+			source.Lifecycle.CleanupPolicy = ImagePodCleanupPolicyDeleteOnSuccess
+			pod := unpackPod("test-bundle", corev1.PodSucceeded, time.Now())
+			Expect(source.Client.Create(ctx, pod)).To(Succeed())
+			Expect(source.deleteSucceededPod(ctx, pod)).To(Succeed())
+			Expect(podExists(pod)).To(BeFalse())
+		})
+
+		It("should retain succeeded pods by default", func() {
+			pod := unpackPod("test-bundle", corev1.PodSucceeded, time.Now())
+			Expect(source.Client.Create(ctx, pod)).To(Succeed())
+			Expect(source.deleteSucceededPod(ctx, pod)).To(Succeed())
+			Expect(podExists(pod)).To(BeTrue())
+		})
+
+		It("should delete failed pods once the failed pod TTL has passed since they finished", func() {
+			source.Lifecycle.FailedPodTTL = time.Hour
+			pod := unpackPod("test-bundle", corev1.PodFailed, time.Now().Add(-3*time.Hour))
+			pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+				Name:  imageBundleUnpackContainerName,
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{FinishedAt: metav1.NewTime(time.Now().Add(-30 * time.Minute))}},
+			}}
+			Expect(source.Client.Create(ctx, pod)).To(Succeed())
+
+			Expect(source.deleteExpiredPod(ctx, pod, podFinishedAt(pod))).To(BeNumerically("~", 30*time.Minute, time.Minute))
+			Expect(podExists(pod)).To(BeTrue())
+
+			source.Lifecycle.FailedPodTTL = 10 * time.Minute
+			Expect(source.deleteExpiredPod(ctx, pod, podFinishedAt(pod))).To(BeZero())
+			Expect(podExists(pod)).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,191 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	applyconfigurationcorev1 "k8s.io/client-go/applyconfigurations/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+// UnpackPodTemplateKey is the key of the unpack pod template configmap that
+// holds the YAML-encoded pod template. The template's metadata and spec are
+// merged into the unpack pods with the strategic merge patch semantics of
+// pods, so the containers of the template are matched to the "install-unpacker"
+// init container and the "bundle" container of the unpack pods by name.
+const UnpackPodTemplateKey = "pod-template.yaml"
+
+// UnpackPodAllowedOverridesKey is the key of the unpack pod template
+// configmap that holds the comma-separated fields of the pod overrides that
+// bundles may set, e.g. "labels,annotations,resources,nodeSelector". If it is
+// not set, bundles may only set the default allowed pod overrides.
+const UnpackPodAllowedOverridesKey = "allowed-pod-overrides"
+
+// defaultAllowedPodOverrides are the pod overrides that bundles may set unless
+// the unpack pod template configmap allows others. The scheduling and
+// environment of the unpack pods, which run in the system namespace, are left
+// to cluster admins unless they allow bundle authors to override them.
+var defaultAllowedPodOverrides = map[string]bool{"labels": true, "annotations": true, "resources": true}
+
+// podOverrideFields are the fields of the pod overrides that can be allowed.
+var podOverrideFields = map[string]bool{
+	"labels": true, "annotations": true, "resources": true,
+	"nodeSelector": true, "tolerations": true, "priorityClassName": true, "env": true,
+}
+
+// podTemplate is the configuration of the unpack pods that is read from the
+// PodTemplateConfigMap.
+type podTemplate struct {
+	// template is the JSON-encoded pod template, or nil if it is not set.
+	template json.RawMessage
+
+	// allowedOverrides are the fields of the pod overrides that bundles may
+	// set.
+	allowedOverrides map[string]bool
+}
+
+// getPodTemplate returns the unpack pod configuration of the
+// PodTemplateConfigMap, or the default configuration if the configmap does not
+// exist.
+func (i *Image) getPodTemplate(ctx context.Context) (*podTemplate, error) {
+	pt := &podTemplate{allowedOverrides: defaultAllowedPodOverrides}
+	if i.PodTemplateConfigMap == "" {
+		return pt, nil
+	}
+	cm := &corev1.ConfigMap{}
+	if err := i.Client.Get(ctx, client.ObjectKey{Namespace: i.PodNamespace, Name: i.PodTemplateConfigMap}, cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			return pt, nil
+		}
+		return nil, fmt.Errorf("get unpack pod template configmap %q: %v", i.PodTemplateConfigMap, err)
+	}
+
+	if allowed, ok := cm.Data[UnpackPodAllowedOverridesKey]; ok {
+		pt.allowedOverrides = map[string]bool{}
+		for _, field := range strings.Split(allowed, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			if !podOverrideFields[field] {
+				return nil, fmt.Errorf("unpack pod template configmap %q allows unknown pod override %q", i.PodTemplateConfigMap, field)
+			}
+			pt.allowedOverrides[field] = true
+		}
+	}
+
+	data, ok := cm.Data[UnpackPodTemplateKey]
+	if !ok {
+		if _, ok := cm.Data[UnpackPodAllowedOverridesKey]; ok {
+			return pt, nil
+		}
+		return nil, fmt.Errorf("unpack pod template configmap %q has no key %q", i.PodTemplateConfigMap, UnpackPodTemplateKey)
+	}
+
+	// The template is decoded into a pod template spec to validate it, but
+	// the original document is merged so that unset fields are not reset.
+	if err := yaml.UnmarshalStrict([]byte(data), &corev1.PodTemplateSpec{}); err != nil {
+		return nil, fmt.Errorf("parse unpack pod template of configmap %q: %v", i.PodTemplateConfigMap, err)
+	}
+	template, err := yaml.YAMLToJSON([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("parse unpack pod template of configmap %q: %v", i.PodTemplateConfigMap, err)
+	}
+	pt.template = template
+	return pt, nil
+}
+
+// checkPodOverrides returns an error if the pod overrides set fields that are
+// not allowed.
+func (pt *podTemplate) checkPodOverrides(overrides *rukpakv1alpha1.UnpackPodOverrides) error {
+	var disallowed []string
+	for field, set := range map[string]bool{
+		"labels":            len(overrides.Labels) > 0,
+		"annotations":       len(overrides.Annotations) > 0,
+		"resources":         overrides.Resources != nil,
+		"nodeSelector":      len(overrides.NodeSelector) > 0,
+		"tolerations":       len(overrides.Tolerations) > 0,
+		"priorityClassName": overrides.PriorityClassName != "",
+		"env":               len(overrides.Env) > 0,
+	} {
+		if set && !pt.allowedOverrides[field] {
+			disallowed = append(disallowed, field)
+		}
+	}
+	if len(disallowed) > 0 {
+		sort.Strings(disallowed)
+		return fmt.Errorf("pod overrides %v are not allowed, the allowed pod overrides are configured with the %q key of the unpack pod template configmap", disallowed, UnpackPodAllowedOverridesKey)
+	}
+	return nil
+}
+
+// podOverridesPatch returns a pod patch that applies the pod overrides of a
+// bundle to the unpack pod.
+func podOverridesPatch(overrides *rukpakv1alpha1.UnpackPodOverrides) map[string]interface{} {
+	metadata := map[string]interface{}{}
+	if len(overrides.Labels) > 0 {
+		metadata["labels"] = overrides.Labels
+	}
+	if len(overrides.Annotations) > 0 {
+		metadata["annotations"] = overrides.Annotations
+	}
+
+	spec := map[string]interface{}{}
+	if len(overrides.NodeSelector) > 0 {
+		spec["nodeSelector"] = overrides.NodeSelector
+	}
+	if len(overrides.Tolerations) > 0 {
+		spec["tolerations"] = overrides.Tolerations
+	}
+	if overrides.PriorityClassName != "" {
+		spec["priorityClassName"] = overrides.PriorityClassName
+	}
+	if overrides.Resources != nil || len(overrides.Env) > 0 {
+		container := func(name string) []interface{} {
+			c := map[string]interface{}{"name": name}
+			if overrides.Resources != nil {
+				c["resources"] = overrides.Resources
+			}
+			if len(overrides.Env) > 0 {
+				c["env"] = overrides.Env
+			}
+			return []interface{}{c}
+		}
+		spec["initContainers"] = container(imageUnpackerInitContainerName)
+		spec["containers"] = container(imageBundleUnpackContainerName)
+	}
+	return map[string]interface{}{"metadata": metadata, "spec": spec}
+}
+
+// mergePodLayers merges the given pod apply configurations and patches, in
+// order, with the strategic merge patch semantics of pods.
+func mergePodLayers(layers ...interface{}) (*applyconfigurationcorev1.PodApplyConfiguration, error) {
+	merged, err := json.Marshal(layers[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, layer := range layers[1:] {
+		patch, err := json.Marshal(layer)
+		if err != nil {
+			return nil, err
+		}
+		merged, err = strategicpatch.StrategicMergePatch(merged, patch, corev1.Pod{})
+		if err != nil {
+			return nil, fmt.Errorf("merge unpack pod template: %v", err)
+		}
+	}
+	podApply := &applyconfigurationcorev1.PodApplyConfiguration{}
+	if err := json.Unmarshal(merged, podApply); err != nil {
+		return nil, fmt.Errorf("decode unpack pod: %v", err)
+	}
+	return podApply, nil
+}
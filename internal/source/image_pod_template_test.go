@@ -0,0 +1,165 @@
+package source
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+	"github.com/operator-framework/rukpak/internal/util"
+)
+
+var _ = Describe("Image unpack pod template", func() {
+	var (
+		ctx      context.Context
+		template *corev1.ConfigMap
+		source   *Image
+		bundle   *rukpakv1alpha1.Bundle
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		template = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "unpack-pod-template", Namespace: "rukpak-system"},
+			Data: map[string]string{UnpackPodTemplateKey: `
+metadata:
+  labels:
+    team: platform
+    core.rukpak.io/owner-name: other
+spec:
+  priorityClassName: system-cluster-critical
+  tolerations:
+  - key: dedicated
+    operator: Exists
+  securityContext:
+    runAsNonRoot: true
+  containers:
+  - name: bundle
+    image: quay.io/other/image:latest
+    resources:
+      requests:
+        cpu: 10m
+`},
+		}
+		source = &Image{
+			Client:               fake.NewClientBuilder().WithObjects(template).Build(),
+			PodNamespace:         "rukpak-system",
+			UnpackImage:          "quay.io/operator-framework/rukpak:latest",
+			Upload:               ImageUploadConfig{BaseURL: "https://core.rukpak-system.svc"},
+			PodTemplateConfigMap: "unpack-pod-template",
+		}
+		bundle = &rukpakv1alpha1.Bundle{
+			TypeMeta:   metav1.TypeMeta{Kind: rukpakv1alpha1.BundleKind, APIVersion: rukpakv1alpha1.GroupVersion.String()},
+			ObjectMeta: metav1.ObjectMeta{Name: "test-bundle", UID: "test-uid"},
+			Spec: rukpakv1alpha1.BundleSpec{
+				Source: rukpakv1alpha1.BundleSource{
+					Type:  rukpakv1alpha1.SourceTypeImage,
+					Image: &rukpakv1alpha1.ImageSource{Ref: "quay.io/operator-framework/bundle:latest"},
+				},
+			},
+		}
+	})
+
+	It("should merge the template without overriding the fields required to unpack the bundle", func() {
+		pod, err := source.getDesiredPodApplyConfig(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.Labels).To(HaveKeyWithValue("team", "platform"))
+		Expect(pod.Labels).To(HaveKeyWithValue(util.CoreOwnerNameKey, "test-bundle"))
+		Expect(*pod.Spec.PriorityClassName).To(Equal("system-cluster-critical"))
+		Expect(pod.Spec.Tolerations).To(HaveLen(1))
+		Expect(*pod.Spec.SecurityContext.RunAsNonRoot).To(BeTrue())
+		Expect(*pod.Spec.SecurityContext.SeccompProfile.Type).To(Equal(corev1.SeccompProfileTypeRuntimeDefault))
+
+		Expect(pod.Spec.Containers).To(HaveLen(1))
+		container := pod.Spec.Containers[0]
+		Expect(*container.Name).To(Equal(imageBundleUnpackContainerName))
+		Expect(*container.Image).To(Equal("quay.io/operator-framework/bundle:latest"))
+		Expect((*container.Resources.Requests)[corev1.ResourceCPU]).To(Equal(resource.MustParse("10m")))
+		Expect(container.Command).To(ContainElement("/bin/unpack"))
+		Expect(container.Command).To(ContainElement("https://core.rukpak-system.svc/unpacks/test-uid.tgz"))
+		Expect(pod.Spec.Volumes).To(ContainElement(HaveField("Secret.SecretName", HaveValue(Equal("test-uid-unpack")))))
+		Expect(*container.SecurityContext.AllowPrivilegeEscalation).To(BeFalse())
+		Expect(pod.Spec.InitContainers).To(HaveLen(1))
+		Expect(pod.Spec.InitContainers[0].Resources).To(BeNil())
+	})
+
+	It("should merge the bundle's pod overrides after the template", func() {
+		template.Data[UnpackPodAllowedOverridesKey] = "resources, nodeSelector, priorityClassName, env"
+		source.Client = fake.NewClientBuilder().WithObjects(template).Build()
+		bundle.Spec.Source.Image.PodOverrides = &rukpakv1alpha1.UnpackPodOverrides{
+			NodeSelector:      map[string]string{"kubernetes.io/os": "linux"},
+			PriorityClassName: "bundle-unpack",
+			Resources: &corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+			},
+			Env: []corev1.EnvVar{{Name: "HTTPS_PROXY", Value: "http://proxy:3128"}},
+		}
+		pod, err := source.getDesiredPodApplyConfig(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.Spec.NodeSelector).To(Equal(map[string]string{"kubernetes.io/os": "linux"}))
+		Expect(*pod.Spec.PriorityClassName).To(Equal("bundle-unpack"))
+		for _, container := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+			Expect((*container.Resources.Limits)[corev1.ResourceMemory]).To(Equal(resource.MustParse("64Mi")))
+			Expect(container.Env).To(HaveLen(1))
+			Expect(*container.Env[0].Value).To(Equal("http://proxy:3128"))
+		}
+		Expect((*pod.Spec.Containers[0].Resources.Requests)[corev1.ResourceCPU]).To(Equal(resource.MustParse("10m")))
+	})
+
+	It("should reject the pod overrides that are not allowed", func() {
+		bundle.Spec.Source.Image.PodOverrides = &rukpakv1alpha1.UnpackPodOverrides{
+			Labels:      map[string]string{"team": "bundles"},
+			Tolerations: []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+			Env:         []corev1.EnvVar{{Name: "HTTPS_PROXY", Value: "http://proxy:3128"}},
+		}
+		_, err := source.getDesiredPodApplyConfig(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring("pod overrides [env tolerations] are not allowed")))
+
+		source.PodTemplateConfigMap = "missing"
+		_, err = source.getDesiredPodApplyConfig(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring("pod overrides [env tolerations] are not allowed")))
+	})
+
+	It("should allow pod overrides without a template", func() {
+		template.Data = map[string]string{UnpackPodAllowedOverridesKey: "tolerations"}
+		source.Client = fake.NewClientBuilder().WithObjects(template).Build()
+		bundle.Spec.Source.Image.PodOverrides = &rukpakv1alpha1.UnpackPodOverrides{
+			Tolerations: []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+		}
+		pod, err := source.getDesiredPodApplyConfig(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.Spec.Tolerations).To(HaveLen(1))
+		Expect(pod.Spec.PriorityClassName).To(BeNil())
+
+		bundle.Spec.Source.Image.PodOverrides.Labels = map[string]string{"team": "bundles"}
+		_, err = source.getDesiredPodApplyConfig(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring("pod overrides [labels] are not allowed")))
+	})
+
+	It("should reject unknown allowed pod overrides", func() {
+		template.Data[UnpackPodAllowedOverridesKey] = "volumes"
+		source.Client = fake.NewClientBuilder().WithObjects(template).Build()
+		_, err := source.getDesiredPodApplyConfig(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring(`allows unknown pod override "volumes"`)))
+	})
+
+	It("should not customize the pod if the template configmap does not exist", func() {
+		source.PodTemplateConfigMap = "missing"
+		pod, err := source.getDesiredPodApplyConfig(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.Spec.PriorityClassName).To(BeNil())
+		Expect(*pod.Spec.SecurityContext.RunAsNonRoot).To(BeFalse())
+	})
+
+	It("should reject an invalid template", func() {
+		template.Data[UnpackPodTemplateKey] = "spec:\n  unknownField: true\n"
+		source.Client = fake.NewClientBuilder().WithObjects(template).Build()
+		_, err := source.getDesiredPodApplyConfig(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring(`parse unpack pod template of configmap "unpack-pod-template"`)))
+	})
+})
@@ -0,0 +1,209 @@
+package source
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/nlepage/go-tarfs"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+	"github.com/operator-framework/rukpak/internal/unpack"
+)
+
+// ImageRegistry is a bundle source that sources bundles from container images
+// by pulling the image manifest and layers directly from the image registry.
+// Unlike the Image source, it does not require an unpack Pod to be scheduled,
+// and the bundle content never passes through the Pod logs.
+type ImageRegistry struct {
+	Reader          client.Reader
+	SecretNamespace string
+	Transport       http.RoundTripper
+}
+
+func (i *ImageRegistry) Unpack(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (*Result, error) {
+	if bundle.Spec.Source.Type != rukpakv1alpha1.SourceTypeImage {
+		return nil, fmt.Errorf("bundle source type %q not supported", bundle.Spec.Source.Type)
+	}
+	if bundle.Spec.Source.Image == nil {
+		return nil, fmt.Errorf("bundle source image configuration is unset")
+	}
+	imgSource := bundle.Spec.Source.Image
+
+	ref, err := name.ParseReference(imgSource.Ref)
+	if err != nil {
+		return nil, permanentError(fmt.Errorf("parse image reference %q: %v", imgSource.Ref, err))
+	}
+
+	keychain := authn.DefaultKeychain
+	if imgSource.ImagePullSecretName != "" {
+		keychain, err = getPullSecretKeychain(ctx, i.Reader, i.SecretNamespace, imgSource.ImagePullSecretName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	remoteOpts := []remote.Option{
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(keychain),
+	}
+	if i.Transport != nil {
+		remoteOpts = append(remoteOpts, remote.WithTransport(i.Transport))
+	}
+	img, err := remote.Image(ref, remoteOpts...)
+	if err != nil {
+		fetchErr := fmt.Errorf("fetch image %q: %v", imgSource.Ref, err)
+		var transportErr *transport.Error
+		if errors.As(err, &transportErr) {
+			return nil, httpStatusError(transportErr.StatusCode, fetchErr)
+		}
+		return nil, fetchErr
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("get digest of image %q: %v", imgSource.Ref, err)
+	}
+	digestRef := ref.Context().Digest(digest.String())
+
+	if verification := bundle.Spec.Source.Verification; verification != nil {
+		keys, err := getVerificationKeys(ctx, i.Reader, i.SecretNamespace, verification)
+		if err != nil {
+			return nil, verificationError(err)
+		}
+		if err := verifyImageSignature(keys, digestRef, remoteOpts...); err != nil {
+			return nil, verificationError(err)
+		}
+	}
+
+	// mutate.Extract flattens the image layers (honoring whiteout files) into
+	// a single tar stream of the image's root filesystem, which is the bundle
+	// root directory once the same paths as the unpack pods are skipped.
+	layerReader := mutate.Extract(img)
+	defer layerReader.Close()
+	bundleReader := bundleTar(layerReader)
+	defer bundleReader.Close()
+	bundleFS, err := tarfs.New(bundleReader)
+	if err != nil {
+		return nil, fmt.Errorf("read filesystem of image %q: %v", imgSource.Ref, err)
+	}
+
+	resolvedSource := &rukpakv1alpha1.BundleSource{
+		Type: rukpakv1alpha1.SourceTypeImage,
+		Image: &rukpakv1alpha1.ImageSource{
+			Ref:                 digestRef.String(),
+			ImagePullSecretName: imgSource.ImagePullSecretName,
+		},
+		Verification: bundle.Spec.Source.Verification.DeepCopy(),
+	}
+
+	message := generateMessage("image")
+
+	return &Result{Bundle: bundleFS, ResolvedSource: resolvedSource, State: StateUnpacked, Message: message}, nil
+}
+
+// bundleTar returns a tar stream of the entries of the image root filesystem
+// tar stream r that the unpack pods would upload as the bundle content: the
+// root directories that are not part of the bundle and symlinks are skipped,
+// so that both unpack methods produce the same bundle content.
+func bundleTar(r io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(filterBundleTar(pw, r))
+	}()
+	return pr
+}
+
+func filterBundleTar(w io.Writer, r io.Reader) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return tw.Close()
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag == tar.TypeSymlink || unpack.IsSkippedRootPath(h.Name) {
+			continue
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// getPullSecretKeychain reads the image pull secret with the given name and
+// returns a keychain that resolves registry credentials from it. Both the
+// kubernetes.io/dockerconfigjson and kubernetes.io/dockercfg secret types are
+// supported.
+func getPullSecretKeychain(ctx context.Context, cl client.Reader, namespace, secretName string) (authn.Keychain, error) {
+	secret := &corev1.Secret{}
+	if err := cl.Get(ctx, client.ObjectKey{Namespace: namespace, Name: secretName}, secret); err != nil {
+		return nil, fmt.Errorf("get image pull secret %s/%s: %v", namespace, secretName, err)
+	}
+
+	auths := map[string]authn.AuthConfig{}
+	switch {
+	case len(secret.Data[corev1.DockerConfigJsonKey]) > 0:
+		cfg := struct {
+			Auths map[string]authn.AuthConfig `json:"auths"`
+		}{}
+		if err := json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &cfg); err != nil {
+			return nil, fmt.Errorf("parse image pull secret %s/%s: %v", namespace, secretName, err)
+		}
+		auths = cfg.Auths
+	case len(secret.Data[corev1.DockerConfigKey]) > 0:
+		if err := json.Unmarshal(secret.Data[corev1.DockerConfigKey], &auths); err != nil {
+			return nil, fmt.Errorf("parse image pull secret %s/%s: %v", namespace, secretName, err)
+		}
+	default:
+		return nil, fmt.Errorf("image pull secret %s/%s does not contain %q or %q", namespace, secretName, corev1.DockerConfigJsonKey, corev1.DockerConfigKey)
+	}
+
+	kc := pullSecretKeychain{}
+	for registry, cfg := range auths {
+		kc[normalizeRegistryHost(registry)] = cfg
+	}
+	return kc, nil
+}
+
+// pullSecretKeychain is an authn.Keychain backed by the registry credentials
+// found in an image pull secret, keyed by registry host.
+type pullSecretKeychain map[string]authn.AuthConfig
+
+func (k pullSecretKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	cfg, ok := k[normalizeRegistryHost(target.RegistryStr())]
+	if !ok {
+		return authn.Anonymous, nil
+	}
+	return authn.FromConfig(cfg), nil
+}
+
+// normalizeRegistryHost converts the registry keys that may be found in a
+// docker config file (e.g. "https://index.docker.io/v1/") into bare registry
+// hosts (e.g. "index.docker.io").
+func normalizeRegistryHost(registry string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(registry, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	switch host {
+	case "docker.io", "registry-1.docker.io":
+		return name.DefaultRegistry
+	}
+	return host
+}
@@ -0,0 +1,142 @@
+package source
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+var _ = Describe("ImageRegistry", func() {
+	var (
+		ctx      context.Context
+		server   *httptest.Server
+		imageRef name.Reference
+		digest   string
+		source   *ImageRegistry
+		bundle   *rukpakv1alpha1.Bundle
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		server = httptest.NewServer(requireBasicAuth("user", "pass", registry.New()))
+
+		var err error
+		imageRef, err = name.ParseReference(fmt.Sprintf("%s/test/bundle:latest", strings.TrimPrefix(server.URL, "http://")))
+		Expect(err).NotTo(HaveOccurred())
+
+		img, err := crane.Image(map[string][]byte{
+			"manifests/configmap.yaml": []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: test\n"),
+			"etc/passwd":               []byte("root:x:0:0:root:/root:/bin/sh\n"),
+			"bin/unpack":               []byte("#!/bin/sh\n"),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(remote.Write(imageRef, img, remote.WithAuth(&authn.Basic{Username: "user", Password: "pass"}))).To(Succeed())
+		imgDigest, err := img.Digest()
+		Expect(err).NotTo(HaveOccurred())
+		digest = imgDigest.String()
+
+		dockerConfig, err := json.Marshal(map[string]interface{}{
+			"auths": map[string]interface{}{
+				imageRef.Context().RegistryStr(): map[string]string{
+					"auth": base64.StdEncoding.EncodeToString([]byte("user:pass")),
+				},
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		pullSecret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "pull-secret", Namespace: "rukpak-system"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: dockerConfig},
+		}
+
+		source = &ImageRegistry{
+			Reader:          fake.NewClientBuilder().WithObjects(pullSecret).Build(),
+			SecretNamespace: "rukpak-system",
+		}
+		bundle = &rukpakv1alpha1.Bundle{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-bundle"},
+			Spec: rukpakv1alpha1.BundleSpec{
+				Source: rukpakv1alpha1.BundleSource{
+					Type: rukpakv1alpha1.SourceTypeImage,
+					Image: &rukpakv1alpha1.ImageSource{
+						Ref:                 imageRef.String(),
+						ImagePullSecretName: "pull-secret",
+					},
+				},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should unpack the image filesystem and resolve the image digest", func() {
+		result, err := source.Unpack(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.State).To(Equal(StateUnpacked))
+		Expect(result.ResolvedSource.Image.Ref).To(Equal(fmt.Sprintf("%s@%s", imageRef.Context().Name(), digest)))
+		Expect(result.ResolvedSource.Image.ImagePullSecretName).To(Equal("pull-secret"))
+
+		data, err := fs.ReadFile(result.Bundle, "manifests/configmap.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(ContainSubstring("kind: ConfigMap"))
+	})
+
+	It("should skip the root directories that unpack pods skip", func() {
+		result, err := source.Unpack(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = fs.Stat(result.Bundle, "etc")
+		Expect(err).To(MatchError(fs.ErrNotExist))
+		_, err = fs.Stat(result.Bundle, "bin/unpack")
+		Expect(err).To(MatchError(fs.ErrNotExist))
+		_, err = fs.Stat(result.Bundle, "manifests/configmap.yaml")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should fail to unpack the image without credentials", func() {
+		bundle.Spec.Source.Image.ImagePullSecretName = ""
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring("UNAUTHORIZED")))
+	})
+
+	It("should fail when the pull secret does not exist", func() {
+		bundle.Spec.Source.Image.ImagePullSecretName = "missing"
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring("get image pull secret rukpak-system/missing")))
+	})
+})
+
+// requireBasicAuth wraps a registry handler so that all requests must present
+// the given basic auth credentials.
+func requireBasicAuth(username, password string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != username || p != password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"errors":[{"code":"UNAUTHORIZED","message":"authentication required"}]}`))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,141 @@
+package source
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DefaultImageContentDir is the default directory in which bundle content
+	// uploaded by image unpack pods is staged.
+	DefaultImageContentDir = "/var/cache/unpacks"
+
+	// DefaultImageContentMaxSize is the default maximum size, in bytes, of the
+	// gzipped bundle content that an image unpack pod may upload.
+	DefaultImageContentMaxSize int64 = 100 << 20
+
+	// ImageUploadPathPrefix is the path prefix under which the image upload
+	// handler must be mounted.
+	ImageUploadPathPrefix = "/unpacks/"
+
+	imageUploadSecretTokenKey = "token"
+	imageUploadSecretCAKey    = "ca.crt"
+)
+
+// ImageUploadConfig configures how image unpack pods upload bundle content
+// back to the provisioner.
+type ImageUploadConfig struct {
+	// BaseURL is the URL at which unpack pods can reach the provisioner's
+	// HTTP server.
+	BaseURL string
+
+	// CAData is the PEM-encoded certificate authority bundle unpack pods use
+	// to verify the certificate of the provisioner's HTTP server. If empty,
+	// unpack pods use the system certificate authorities of the bundle image.
+	CAData []byte
+
+	// ContentDir is the directory in which uploaded bundle content is
+	// staged until it is unpacked.
+	ContentDir string
+
+	// MaxContentSize is the maximum size, in bytes, of the gzipped bundle
+	// content that an unpack pod may upload.
+	MaxContentSize int64
+}
+
+// NewImageUploadHandler returns an http.Handler that accepts bundle content
+// uploaded by image unpack pods at the UID of their bundle. Each unpack pod
+// authenticates with a bearer token that is generated for its bundle and
+// stored in a secret in the given namespace. The handler streams the content into the configured content
+// directory, from which the Image source reads it once the unpack pod has
+// succeeded.
+func NewImageUploadHandler(cl client.Reader, namespace string, cfg ImageUploadConfig) http.Handler {
+	r := mux.NewRouter()
+	r.Methods(http.MethodPut).Path(ImageUploadPathPrefix + "{bundleUID}.tgz").Handler(newImageUploadPutHandler(cl, namespace, cfg))
+	return r
+}
+
+func newImageUploadPutHandler(cl client.Reader, namespace string, cfg ImageUploadConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bundleUID := types.UID(mux.Vars(r)["bundleUID"])
+
+		secret := &corev1.Secret{}
+		if err := cl.Get(r.Context(), client.ObjectKey{Namespace: namespace, Name: imageUploadSecretName(bundleUID)}, secret); err != nil {
+			if apierrors.IsNotFound(err) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			http.Error(w, fmt.Sprintf("get upload token: %v", err), http.StatusInternalServerError)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || len(secret.Data[imageUploadSecretTokenKey]) == 0 || subtle.ConstantTimeCompare([]byte(token), secret.Data[imageUploadSecretTokenKey]) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if r.ContentLength > cfg.MaxContentSize {
+			http.Error(w, fmt.Sprintf("bundle content size %d exceeds the maximum of %d bytes", r.ContentLength, cfg.MaxContentSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		// Stream the content into a temporary file and atomically rename it
+		// once the upload is complete, so that the Image source never reads
+		// partially uploaded content.
+		tmpFile, err := os.CreateTemp(cfg.ContentDir, fmt.Sprintf(".%s-*.tgz", bundleUID))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to store bundle content: %v", err), http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(tmpFile.Name())
+		defer tmpFile.Close()
+
+		if _, err := io.Copy(tmpFile, http.MaxBytesReader(w, r.Body, cfg.MaxContentSize)); err != nil {
+			if maxBytesErr := (*http.MaxBytesError)(nil); errors.As(err, &maxBytesErr) {
+				http.Error(w, fmt.Sprintf("bundle content exceeds the maximum of %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, fmt.Sprintf("failed to store bundle content: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := tmpFile.Close(); err != nil {
+			http.Error(w, fmt.Sprintf("failed to store bundle content: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := os.Rename(tmpFile.Name(), imageContentPath(cfg.ContentDir, bundleUID)); err != nil {
+			http.Error(w, fmt.Sprintf("failed to store bundle content: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+}
+
+// DeleteImageContent deletes the content that the unpack pod of the bundle
+// with the given UID uploaded to the content directory, if it was not
+// unpacked before the bundle was deleted.
+func DeleteImageContent(contentDir string, bundleUID types.UID) error {
+	if err := os.Remove(imageContentPath(contentDir, bundleUID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("delete uploaded bundle content: %v", err)
+	}
+	return nil
+}
+
+func imageUploadSecretName(bundleUID types.UID) string {
+	return fmt.Sprintf("%s-unpack", bundleUID)
+}
+
+func imageContentPath(contentDir string, bundleUID types.UID) string {
+	return filepath.Join(contentDir, fmt.Sprintf("%s.tgz", bundleUID))
+}
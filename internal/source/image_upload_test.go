@@ -0,0 +1,68 @@
+package source
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("ImageUploadHandler", func() {
+	var (
+		cfg     ImageUploadConfig
+		handler http.Handler
+	)
+
+	BeforeEach(func() {
+		cfg = ImageUploadConfig{
+			ContentDir:     GinkgoT().TempDir(),
+			MaxContentSize: 16,
+		}
+		tokenSecret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: imageUploadSecretName("test-uid"), Namespace: "rukpak-system"},
+			Data:       map[string][]byte{imageUploadSecretTokenKey: []byte("abc123")},
+		}
+		handler = NewImageUploadHandler(fake.NewClientBuilder().WithObjects(tokenSecret).Build(), "rukpak-system", cfg)
+	})
+
+	upload := func(bundleUID, token string, content []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/unpacks/%s.tgz", bundleUID), bytes.NewReader(content))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	It("should store content uploaded with the bundle's token", func() {
+		Expect(upload("test-uid", "abc123", []byte("content")).Code).To(Equal(http.StatusCreated))
+		Expect(os.ReadFile(imageContentPath(cfg.ContentDir, "test-uid"))).To(Equal([]byte("content")))
+	})
+
+	It("should reject content uploaded with an incorrect token", func() {
+		Expect(upload("test-uid", "xyz789", []byte("content")).Code).To(Equal(http.StatusUnauthorized))
+		Expect(imageContentPath(cfg.ContentDir, "test-uid")).NotTo(BeAnExistingFile())
+	})
+
+	It("should delete content that was not unpacked", func() {
+		Expect(upload("test-uid", "abc123", []byte("content")).Code).To(Equal(http.StatusCreated))
+		Expect(DeleteImageContent(cfg.ContentDir, "test-uid")).To(Succeed())
+		Expect(imageContentPath(cfg.ContentDir, "test-uid")).NotTo(BeAnExistingFile())
+		Expect(DeleteImageContent(cfg.ContentDir, "test-uid")).To(Succeed())
+	})
+
+	It("should reject content for bundles without a token", func() {
+		Expect(upload("other-uid", "abc123", []byte("content")).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should reject content that exceeds the maximum size", func() {
+		Expect(upload("test-uid", "abc123", bytes.Repeat([]byte("x"), 17)).Code).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(imageContentPath(cfg.ContentDir, "test-uid")).NotTo(BeAnExistingFile())
+	})
+})
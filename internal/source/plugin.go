@@ -0,0 +1,156 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+	"github.com/operator-framework/rukpak/pkg/sourceplugin"
+)
+
+const (
+	// pluginClientTimeout is the timeout of requests to source plugins,
+	// which may fetch the bundle content before they respond.
+	pluginClientTimeout = 5 * time.Minute
+
+	// pluginMaxErrorSize is the maximum size of the error messages that are
+	// read from the responses of source plugins.
+	pluginMaxErrorSize = 4096
+
+	// pluginPollInterval is how often source plugins that are still
+	// unpacking a bundle are called again, unless they request another
+	// interval. Nothing watches source plugins, so bundles would otherwise
+	// not be reconciled again until they are resynced.
+	pluginPollInterval = 10 * time.Second
+)
+
+// PluginConfig configures the out-of-tree source plugins that unpack the
+// bundles of source types that are not built in. It is typically read from
+// a file that is mounted from a configmap.
+type PluginConfig struct {
+	// Plugins is the list of source plugins.
+	Plugins []PluginEndpoint `json:"plugins"`
+}
+
+// PluginEndpoint maps a source type to the endpoint of the plugin that
+// unpacks the bundles of that type.
+type PluginEndpoint struct {
+	// SourceType is the source type of the bundles that the plugin unpacks.
+	SourceType rukpakv1alpha1.SourceType `json:"sourceType"`
+
+	// Endpoint is the base URL of the plugin, which serves the protocol of
+	// the sourceplugin package.
+	Endpoint string `json:"endpoint"`
+}
+
+// LoadPluginConfig reads the YAML or JSON source plugin configuration from
+// the file at the given path.
+func LoadPluginConfig(path string) (*PluginConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read source plugin configuration: %v", err)
+	}
+	cfg := &PluginConfig{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse source plugin configuration %q: %v", path, err)
+	}
+	seen := map[rukpakv1alpha1.SourceType]struct{}{}
+	for i, plugin := range cfg.Plugins {
+		if plugin.SourceType == "" || plugin.Endpoint == "" {
+			return nil, fmt.Errorf("source plugin configuration %q: plugins[%d]: sourceType and endpoint must be set", path, i)
+		}
+		if _, ok := seen[plugin.SourceType]; ok {
+			return nil, fmt.Errorf("source plugin configuration %q: duplicate source type %q", path, plugin.SourceType)
+		}
+		seen[plugin.SourceType] = struct{}{}
+	}
+	return cfg, nil
+}
+
+// Plugin unpacks bundles by delegating to an out-of-tree source plugin that
+// serves the protocol of the sourceplugin package.
+type Plugin struct {
+	// Endpoint is the base URL of the plugin.
+	Endpoint string
+
+	Client *http.Client
+}
+
+func (p *Plugin) Unpack(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (*Result, error) {
+	if bundle.Spec.Source.Plugin == nil {
+		return nil, fmt.Errorf("bundle source plugin configuration is unset")
+	}
+	sourceType := bundle.Spec.Source.Type
+
+	body, err := json.Marshal(sourceplugin.UnpackRequest{Bundle: bundle})
+	if err != nil {
+		return nil, fmt.Errorf("encode unpack request: %v", err)
+	}
+	url := strings.TrimSuffix(p.Endpoint, "/") + sourceplugin.UnpackPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create unpack request for source plugin %q: %v", sourceType, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unpack with source plugin %q: %v", sourceType, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, pluginMaxErrorSize))
+		return nil, httpStatusError(resp.StatusCode, fmt.Errorf("unpack with source plugin %q: unexpected status %q: %s", sourceType, resp.Status, strings.TrimSpace(string(msg))))
+	}
+
+	br := bufio.NewReader(resp.Body)
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("read response of source plugin %q: %v", sourceType, err)
+	}
+	var unpackResp sourceplugin.UnpackResponse
+	if err := json.Unmarshal(line, &unpackResp); err != nil {
+		return nil, fmt.Errorf("decode response of source plugin %q: %v", sourceType, err)
+	}
+
+	requeueAfter := pluginPollInterval
+	if unpackResp.RequeueAfterSeconds > 0 {
+		requeueAfter = time.Duration(unpackResp.RequeueAfterSeconds) * time.Second
+	}
+	switch unpackResp.State {
+	case sourceplugin.StatePending:
+		return &Result{State: StatePending, Message: unpackResp.Message, RequeueAfter: requeueAfter}, nil
+	case sourceplugin.StateUnpacking:
+		return &Result{State: StateUnpacking, Message: unpackResp.Message, RequeueAfter: requeueAfter}, nil
+	case sourceplugin.StateUnpacked:
+	default:
+		return nil, fmt.Errorf("unpack with source plugin %q: unknown state %q", sourceType, unpackResp.State)
+	}
+
+	resolvedSource := unpackResp.ResolvedSource
+	if resolvedSource == nil {
+		resolvedSource = bundle.Spec.Source.DeepCopy()
+	}
+	if resolvedSource.Type != sourceType {
+		return nil, fmt.Errorf("unpack with source plugin %q: resolved source has type %q", sourceType, resolvedSource.Type)
+	}
+	bundleFS, err := archiveFS(br, rukpakv1alpha1.ArchiveFormatTarGz, "")
+	if err != nil {
+		return nil, fmt.Errorf("read content from source plugin %q: %v", sourceType, err)
+	}
+
+	message := unpackResp.Message
+	if message == "" {
+		message = generateMessage(string(sourceType))
+	}
+	return &Result{Bundle: bundleFS, ResolvedSource: resolvedSource, State: StateUnpacked, Message: message}, nil
+}
@@ -0,0 +1,120 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http/httptest"
+	"testing/fstest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+	"github.com/operator-framework/rukpak/pkg/sourceplugin"
+)
+
+type pluginUnpackerFunc func(context.Context, *rukpakv1alpha1.Bundle) (*sourceplugin.Result, error)
+
+func (f pluginUnpackerFunc) Unpack(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (*sourceplugin.Result, error) {
+	return f(ctx, bundle)
+}
+
+var _ = Describe("Plugin", func() {
+	var (
+		ctx          context.Context
+		server       *httptest.Server
+		pluginResult *sourceplugin.Result
+		pluginErr    error
+		source       *Plugin
+		bundle       *rukpakv1alpha1.Bundle
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		pluginResult = &sourceplugin.Result{
+			Bundle: fstest.MapFS{
+				"manifests/configmap.yaml": &fstest.MapFile{Data: []byte("kind: ConfigMap\n")},
+			},
+			State: sourceplugin.StateUnpacked,
+		}
+		pluginErr = nil
+		server = httptest.NewServer(sourceplugin.NewHandler(pluginUnpackerFunc(func(_ context.Context, b *rukpakv1alpha1.Bundle) (*sourceplugin.Result, error) {
+			Expect(b.Name).To(Equal("test-bundle"))
+			Expect(string(b.Spec.Source.Plugin.Parameters.Raw)).To(MatchJSON(`{"bucket":"bundles"}`))
+			return pluginResult, pluginErr
+		})))
+
+		source = &Plugin{Endpoint: server.URL, Client: server.Client()}
+		bundle = &rukpakv1alpha1.Bundle{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-bundle"},
+			Spec: rukpakv1alpha1.BundleSpec{
+				Source: rukpakv1alpha1.BundleSource{
+					Type: "example",
+					Plugin: &rukpakv1alpha1.PluginSource{
+						Parameters: runtime.RawExtension{Raw: []byte(`{"bucket":"bundles"}`)},
+					},
+				},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should unpack the content streamed by the plugin", func() {
+		pluginResult.ResolvedSource = bundle.Spec.Source.DeepCopy()
+		pluginResult.ResolvedSource.Plugin.Parameters.Raw = []byte(`{"bucket":"bundles","version":"1"}`)
+		result, err := source.Unpack(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.State).To(Equal(StateUnpacked))
+		Expect(result.Message).To(Equal(generateMessage("example")))
+		Expect(string(result.ResolvedSource.Plugin.Parameters.Raw)).To(MatchJSON(`{"bucket":"bundles","version":"1"}`))
+
+		data, err := fs.ReadFile(result.Bundle, "manifests/configmap.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("kind: ConfigMap\n"))
+	})
+
+	It("should report the state of a plugin that is still unpacking", func() {
+		pluginResult = &sourceplugin.Result{State: sourceplugin.StateUnpacking, Message: "downloading"}
+		result, err := source.Unpack(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.State).To(Equal(StateUnpacking))
+		Expect(result.Message).To(Equal("downloading"))
+		Expect(result.Bundle).To(BeNil())
+		Expect(result.RequeueAfter).To(Equal(pluginPollInterval))
+	})
+
+	It("should requeue a pending plugin after the interval it requests", func() {
+		pluginResult = &sourceplugin.Result{State: sourceplugin.StatePending, RequeueAfter: 1500 * time.Millisecond}
+		result, err := source.Unpack(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.State).To(Equal(StatePending))
+		Expect(result.RequeueAfter).To(Equal(2 * time.Second))
+	})
+
+	It("should reject a resolved source of another type", func() {
+		pluginResult.ResolvedSource = &rukpakv1alpha1.BundleSource{Type: rukpakv1alpha1.SourceTypeGit}
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring(`resolved source has type "git"`)))
+	})
+
+	It("should surface the errors of the plugin", func() {
+		pluginErr = errors.New("bucket not found")
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring("bucket not found")))
+		Expect(IsPermanent(err)).To(BeFalse())
+	})
+
+	It("should not retry the permanent errors of the plugin", func() {
+		pluginErr = &sourceplugin.PermanentError{Err: errors.New("invalid bucket name")}
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring("invalid bucket name")))
+		Expect(IsPermanent(err)).To(BeTrue())
+	})
+})
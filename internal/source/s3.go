@@ -0,0 +1,142 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+const (
+	defaultS3Endpoint = "https://s3.amazonaws.com"
+	defaultS3Region   = "us-east-1"
+
+	s3AccessKeyIDKey     = "accessKeyID"
+	s3SecretAccessKeyKey = "secretAccessKey"
+	s3SessionTokenKey    = "sessionToken"
+)
+
+// S3 is a bundle source that sources bundles from objects in S3-compatible
+// object storage.
+type S3 struct {
+	client.Reader
+	SecretNamespace string
+
+	// Transport is used to connect to the object storage service.
+	Transport http.RoundTripper
+}
+
+// Unpack unpacks a bundle by downloading the bundle contents from an object
+// in S3-compatible object storage.
+func (s *S3) Unpack(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (*Result, error) {
+	if bundle.Spec.Source.Type != rukpakv1alpha1.SourceTypeS3 {
+		return nil, fmt.Errorf("cannot unpack source type %q with %q unpacker", bundle.Spec.Source.Type, rukpakv1alpha1.SourceTypeS3)
+	}
+	if bundle.Spec.Source.S3 == nil {
+		return nil, fmt.Errorf("bundle source s3 configuration is unset")
+	}
+	s3Source := bundle.Spec.Source.S3
+	object := fmt.Sprintf("s3://%s/%s", s3Source.Bucket, s3Source.Key)
+
+	s3Client, err := s.newClient(ctx, s3Source)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := minio.GetObjectOptions{VersionID: s3Source.VersionID}
+	if s3Source.ETag != "" {
+		if err := opts.SetMatchETag(s3Source.ETag); err != nil {
+			return nil, fmt.Errorf("get object %q: %v", object, err)
+		}
+	}
+	obj, err := s3Client.GetObject(ctx, s3Source.Bucket, s3Source.Key, opts)
+	if err != nil {
+		return nil, httpStatusError(minio.ToErrorResponse(err).StatusCode, fmt.Errorf("get object %q: %v", object, err))
+	}
+	defer obj.Close()
+
+	// The object info is read from the response to the same request that
+	// downloads the object, so it describes exactly the unpacked content.
+	info, err := obj.Stat()
+	if err != nil {
+		if minio.ToErrorResponse(err).StatusCode == http.StatusPreconditionFailed {
+			return nil, permanentError(fmt.Errorf("get object %q: entity tag does not match expected entity tag %q", object, s3Source.ETag))
+		}
+		return nil, httpStatusError(minio.ToErrorResponse(err).StatusCode, fmt.Errorf("get object %q: %v", object, err))
+	}
+	bundleFS, fsErr := archiveFS(obj, s3Source.Format, info.ContentType)
+
+	// Read the rest of the object, so that errors reading it are told apart
+	// from content that cannot be unpacked.
+	if _, err := io.Copy(io.Discard, obj); err != nil {
+		return nil, fmt.Errorf("read object %q: %v", object, err)
+	}
+	if fsErr != nil {
+		// The object was read in full, so it is the content itself that
+		// cannot be unpacked.
+		return nil, permanentError(fmt.Errorf("read object %q: %v", object, fsErr))
+	}
+
+	resolvedSource := bundle.Spec.Source.DeepCopy()
+	resolvedSource.S3.ETag = info.ETag
+	resolvedSource.S3.VersionID = info.VersionID
+
+	message := generateMessage("s3")
+
+	return &Result{Bundle: bundleFS, ResolvedSource: resolvedSource, State: StateUnpacked, Message: message}, nil
+}
+
+func (s *S3) newClient(ctx context.Context, s3Source *rukpakv1alpha1.S3Source) (*minio.Client, error) {
+	endpoint := s3Source.Endpoint
+	if endpoint == "" {
+		endpoint = defaultS3Endpoint
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parse endpoint %q: %v", endpoint, err)
+	}
+	if endpointURL.Scheme != "http" && endpointURL.Scheme != "https" {
+		return nil, permanentError(fmt.Errorf("parse endpoint %q: scheme must be http or https", endpoint))
+	}
+	// The client addresses buckets from the root of the endpoint host, so
+	// endpoints that are served under a path are not supported.
+	if strings.TrimSuffix(endpointURL.Path, "/") != "" || endpointURL.RawQuery != "" {
+		return nil, permanentError(fmt.Errorf("parse endpoint %q: endpoint must not have a path or query", endpoint))
+	}
+
+	region := s3Source.Region
+	if region == "" {
+		region = defaultS3Region
+	}
+
+	var accessKeyID, secretAccessKey, sessionToken string
+	if s3Source.CredentialsSecret.Name != "" {
+		secret := &corev1.Secret{}
+		if err := s.Get(ctx, client.ObjectKey{Namespace: s.SecretNamespace, Name: s3Source.CredentialsSecret.Name}, secret); err != nil {
+			return nil, fmt.Errorf("get credentials secret %s/%s: %v", s.SecretNamespace, s3Source.CredentialsSecret.Name, err)
+		}
+		accessKeyID = string(secret.Data[s3AccessKeyIDKey])
+		secretAccessKey = string(secret.Data[s3SecretAccessKeyKey])
+		sessionToken = string(secret.Data[s3SessionTokenKey])
+	}
+
+	s3Client, err := minio.New(endpointURL.Host, &minio.Options{
+		Creds:     credentials.NewStaticV4(accessKeyID, secretAccessKey, sessionToken),
+		Secure:    endpointURL.Scheme == "https",
+		Region:    region,
+		Transport: s.Transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create s3 client for endpoint %q: %v", endpoint, err)
+	}
+	return s3Client, nil
+}
@@ -0,0 +1,118 @@
+package source
+
+import (
+	"context"
+	"io/fs"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+	"github.com/operator-framework/rukpak/test/testutil"
+)
+
+var _ = Describe("S3", func() {
+	var (
+		ctx       context.Context
+		server    *testutil.S3Server
+		etag      string
+		versionID string
+		source    *S3
+		bundle    *rukpakv1alpha1.Bundle
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		server = testutil.NewS3Server("test-access-key")
+		etag, versionID = server.PutObject("bundles", "my-bundle/v1.tgz", tarGZ(map[string]string{"manifests/configmap.yaml": "kind: ConfigMap\n"}), "application/gzip")
+
+		credentialsSecret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "s3-credentials", Namespace: "rukpak-system"},
+			Data: map[string][]byte{
+				s3AccessKeyIDKey:     []byte("test-access-key"),
+				s3SecretAccessKeyKey: []byte("test-secret-key"),
+			},
+		}
+		source = &S3{
+			Reader:          fake.NewClientBuilder().WithObjects(credentialsSecret).Build(),
+			SecretNamespace: "rukpak-system",
+		}
+		bundle = &rukpakv1alpha1.Bundle{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-bundle"},
+			Spec: rukpakv1alpha1.BundleSpec{
+				Source: rukpakv1alpha1.BundleSource{
+					Type: rukpakv1alpha1.SourceTypeS3,
+					S3: &rukpakv1alpha1.S3Source{
+						Endpoint:          server.URL,
+						Bucket:            "bundles",
+						Key:               "my-bundle/v1.tgz",
+						CredentialsSecret: corev1.LocalObjectReference{Name: "s3-credentials"},
+					},
+				},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should unpack the object and resolve its entity tag and version", func() {
+		result, err := source.Unpack(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.State).To(Equal(StateUnpacked))
+		Expect(result.ResolvedSource.S3.ETag).To(Equal(etag))
+		Expect(result.ResolvedSource.S3.VersionID).To(Equal(versionID))
+
+		data, err := fs.ReadFile(result.Bundle, "manifests/configmap.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("kind: ConfigMap\n"))
+	})
+
+	It("should unpack the pinned version of the object", func() {
+		server.PutObject("bundles", "my-bundle/v1.tgz", tarGZ(map[string]string{"manifests/secret.yaml": "kind: Secret\n"}), "application/gzip")
+		bundle.Spec.Source.S3.VersionID = versionID
+
+		result, err := source.Unpack(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.ResolvedSource.S3.VersionID).To(Equal(versionID))
+		_, err = fs.Stat(result.Bundle, "manifests/configmap.yaml")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should reject an object whose entity tag does not match", func() {
+		bundle.Spec.Source.S3.ETag = "0123456789abcdef0123456789abcdef"
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring("entity tag does not match")))
+	})
+
+	It("should fail without credentials", func() {
+		bundle.Spec.Source.S3.CredentialsSecret.Name = ""
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring("Access Denied")))
+	})
+
+	It("should fail when the object does not exist", func() {
+		bundle.Spec.Source.S3.Key = "missing.tgz"
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring("The specified key does not exist")))
+	})
+
+	It("should not retry an object that is not a bundle archive", func() {
+		server.PutObject("bundles", "not-a-bundle.tgz", []byte("not a tar.gz"), "application/gzip")
+		bundle.Spec.Source.S3.Key = "not-a-bundle.tgz"
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(HaveOccurred())
+		Expect(IsPermanent(err)).To(BeTrue())
+	})
+
+	It("should reject an endpoint with a path", func() {
+		bundle.Spec.Source.S3.Endpoint = server.URL + "/storage"
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring("endpoint must not have a path")))
+		Expect(IsPermanent(err)).To(BeTrue())
+	})
+})
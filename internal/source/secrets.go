@@ -0,0 +1,79 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing/fstest"
+
+	corev1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+type Secrets struct {
+	Reader          client.Reader
+	SecretNamespace string
+}
+
+func (o *Secrets) Unpack(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (*Result, error) {
+	if bundle.Spec.Source.Type != rukpakv1alpha1.SourceTypeSecrets {
+		return nil, fmt.Errorf("bundle source type %q not supported", bundle.Spec.Source.Type)
+	}
+	if bundle.Spec.Source.Secrets == nil {
+		return nil, fmt.Errorf("bundle source secrets configuration is unset")
+	}
+
+	secretSources := bundle.Spec.Source.Secrets
+
+	bundleFS := fstest.MapFS{}
+	seenFilepaths := map[string]sets.Set[string]{}
+
+	for _, secretSource := range secretSources {
+		secretName := secretSource.Secret.Name
+		dir := filepath.Clean(secretSource.Path)
+
+		// Validating admission webhook handles validation for:
+		//  - paths outside the bundle root
+		//  - secrets referenced by bundles must be immutable
+
+		var secret corev1.Secret
+		if err := o.Reader.Get(ctx, client.ObjectKey{Name: secretName, Namespace: o.SecretNamespace}, &secret); err != nil {
+			return nil, fmt.Errorf("get secret %s/%s: %v", o.SecretNamespace, secretName, err)
+		}
+
+		for filename, data := range secret.Data {
+			filepath := filepath.Join(dir, filename)
+			if _, ok := seenFilepaths[filepath]; !ok {
+				seenFilepaths[filepath] = sets.New[string]()
+			}
+			seenFilepaths[filepath].Insert(secretName)
+			bundleFS[filepath] = &fstest.MapFile{
+				Data: data,
+			}
+		}
+	}
+
+	errs := []error{}
+	for filepath, secretNames := range seenFilepaths {
+		if len(secretNames) > 1 {
+			errs = append(errs, fmt.Errorf("duplicate path %q found in secrets %v", filepath, sets.List(secretNames)))
+		}
+	}
+	if len(errs) > 0 {
+		// Only immutable configmaps and secrets are admitted, so their
+		// paths will not stop conflicting.
+		return nil, permanentError(utilerrors.NewAggregate(errs))
+	}
+
+	resolvedSource := &rukpakv1alpha1.BundleSource{
+		Type:    rukpakv1alpha1.SourceTypeSecrets,
+		Secrets: bundle.Spec.Source.DeepCopy().Secrets,
+	}
+
+	message := generateMessage("secrets")
+	return &Result{Bundle: bundleFS, ResolvedSource: resolvedSource, State: StateUnpacked, Message: message}, nil
+}
@@ -0,0 +1,77 @@
+package source
+
+import (
+	"context"
+	"io/fs"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+var _ = Describe("Secrets", func() {
+	var (
+		ctx    context.Context
+		source *Secrets
+		bundle *rukpakv1alpha1.Bundle
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		secrets := []*corev1.Secret{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "manifests", Namespace: "rukpak-system"},
+				Data:       map[string][]byte{"deployment.yaml": []byte("kind: Deployment\n")},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "tls", Namespace: "rukpak-system"},
+				Data:       map[string][]byte{"secret.yaml": []byte("kind: Secret\n")},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "rukpak-system"},
+				Data:       map[string][]byte{"deployment.yaml": []byte("kind: Deployment\n")},
+			},
+		}
+		builder := fake.NewClientBuilder()
+		for _, secret := range secrets {
+			builder = builder.WithObjects(secret)
+		}
+		source = &Secrets{Reader: builder.Build(), SecretNamespace: "rukpak-system"}
+		bundle = &rukpakv1alpha1.Bundle{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-bundle"},
+			Spec: rukpakv1alpha1.BundleSpec{
+				Source: rukpakv1alpha1.BundleSource{
+					Type: rukpakv1alpha1.SourceTypeSecrets,
+					Secrets: []rukpakv1alpha1.SecretSource{
+						{Secret: corev1.LocalObjectReference{Name: "manifests"}, Path: "manifests"},
+						{Secret: corev1.LocalObjectReference{Name: "tls"}, Path: "manifests/tls"},
+					},
+				},
+			},
+		}
+	})
+
+	It("should unpack the data of the secrets at their paths", func() {
+		result, err := source.Unpack(ctx, bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.State).To(Equal(StateUnpacked))
+		Expect(result.ResolvedSource).To(Equal(&bundle.Spec.Source))
+
+		data, err := fs.ReadFile(result.Bundle, "manifests/deployment.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("kind: Deployment\n"))
+		data, err = fs.ReadFile(result.Bundle, "manifests/tls/secret.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("kind: Secret\n"))
+	})
+
+	It("should fail when secrets map files to the same path", func() {
+		bundle.Spec.Source.Secrets[1] = rukpakv1alpha1.SecretSource{Secret: corev1.LocalObjectReference{Name: "other"}, Path: "manifests"}
+		_, err := source.Unpack(ctx, bundle)
+		Expect(err).To(MatchError(ContainSubstring(`duplicate path "manifests/deployment.yaml" found in secrets [manifests other]`)))
+	})
+})
@@ -0,0 +1,14 @@
+package source
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestSource(t *testing.T) {
+	RegisterFailHandler(Fail)
+	InstallGitHTTPTransport()
+	RunSpecs(t, "Source Suite")
+}
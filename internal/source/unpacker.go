@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io/fs"
 	"net/http"
@@ -60,6 +61,12 @@ type Result struct {
 	// Message is contextual information about the progress of unpacking the
 	// bundle content.
 	Message string
+
+	// RequeueAfter is the time after which the bundle should be unpacked
+	// again, for asynchronous sources whose progress is not observed by a
+	// watch, e.g. because they are waiting for capacity. If zero, the bundle
+	// is only unpacked again when a watch observes progress.
+	RequeueAfter time.Duration
 }
 
 type State string
@@ -78,6 +85,72 @@ const (
 	StateUnpacked State = "Unpacked"
 )
 
+// UnpackError is an error that occurred while unpacking bundle content,
+// annotated with a reason that describes the class of failure. Bundle
+// controllers surface the reason on the bundle's Unpacked condition, which
+// allows users and tools to distinguish failures that need different
+// remediation.
+type UnpackError struct {
+	Reason string
+	Err    error
+
+	// RetryAfter is the minimum time until unpacking the bundle again can
+	// succeed, e.g. until a failed unpack pod that is kept for
+	// troubleshooting is deleted. If zero, it can be retried at any time.
+	RetryAfter time.Duration
+}
+
+func (e *UnpackError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UnpackError) Unwrap() error {
+	return e.Err
+}
+
+// PermanentError is an error that unpacking the bundle again cannot resolve,
+// e.g. an invalid reference, rejected credentials or invalid bundle content.
+// Bundle controllers retry unpacking bundles that failed with other errors
+// with a backoff, but do not retry bundles that failed with a permanent error
+// until the bundle changes.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// IsPermanent returns true if err is, or wraps, a PermanentError.
+func IsPermanent(err error) bool {
+	var permanentErr *PermanentError
+	return errors.As(err, &permanentErr)
+}
+
+func permanentError(err error) error {
+	return &PermanentError{Err: err}
+}
+
+// httpStatusError returns err, which reports an unexpected status code of an
+// http response, as a permanent error if the status is a client error that
+// repeating the request cannot resolve. Timeouts and rate limiting are
+// transient, and so is a missing resource, which may be published later or
+// hidden from the credentials that are used until their secret is fixed.
+func httpStatusError(statusCode int, err error) error {
+	switch statusCode {
+	case http.StatusNotFound, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return err
+	}
+	if statusCode >= 400 && statusCode < 500 {
+		return permanentError(err)
+	}
+	return err
+}
+
 type unpacker struct {
 	sources map[rukpakv1alpha1.SourceType]Unpacker
 }
@@ -91,17 +164,107 @@ func NewUnpacker(sources map[rukpakv1alpha1.SourceType]Unpacker) Unpacker {
 func (s *unpacker) Unpack(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (*Result, error) {
 	source, ok := s.sources[bundle.Spec.Source.Type]
 	if !ok {
-		return nil, fmt.Errorf("source type %q not supported", bundle.Spec.Source.Type)
+		return nil, permanentError(fmt.Errorf("source type %q not supported", bundle.Spec.Source.Type))
 	}
 	return source.Unpack(ctx, bundle)
 }
 
+// ImageUnpackMethod selects the implementation used to unpack image bundle sources.
+type ImageUnpackMethod string
+
+const (
+	// ImageUnpackMethodPod unpacks image bundles by running the bundle image in
+	// an unpack Pod, so that the image is pulled by the kubelet.
+	ImageUnpackMethodPod ImageUnpackMethod = "pod"
+
+	// ImageUnpackMethodRegistry unpacks image bundles in-process by pulling the
+	// image manifest and layers directly from the image registry.
+	ImageUnpackMethodRegistry ImageUnpackMethod = "registry"
+)
+
+// ImageUnpackMethods is the list of supported image unpack methods.
+var ImageUnpackMethods = []ImageUnpackMethod{ImageUnpackMethodPod, ImageUnpackMethodRegistry}
+
+type defaultUnpackerOptions struct {
+	imageUnpackMethod ImageUnpackMethod
+	imageUpload       ImageUploadConfig
+	imagePodTemplate  string
+	imagePodLifecycle ImagePodLifecycleConfig
+	sourceCAData      []byte
+	gitCache          GitCacheConfig
+	plugins           PluginConfig
+}
+
+// DefaultUnpackerOption configures optional behavior of the unpacker returned
+// by NewDefaultUnpacker.
+type DefaultUnpackerOption func(*defaultUnpackerOptions)
+
+// WithImageUnpackMethod configures the implementation used to unpack image
+// bundle sources. By default, image bundles are unpacked with ImageUnpackMethodPod.
+func WithImageUnpackMethod(method ImageUnpackMethod) DefaultUnpackerOption {
+	return func(o *defaultUnpackerOptions) {
+		o.imageUnpackMethod = method
+	}
+}
+
+// WithImageUploadConfig configures how image unpack pods upload bundle content
+// back to the provisioner. It is required when image bundles are unpacked with
+// ImageUnpackMethodPod, in which case the provisioner must also serve the
+// handler returned by NewImageUploadHandler with the same configuration.
+func WithImageUploadConfig(cfg ImageUploadConfig) DefaultUnpackerOption {
+	return func(o *defaultUnpackerOptions) {
+		o.imageUpload = cfg
+	}
+}
+
+// WithImagePodTemplateConfigMap configures the name of the configmap, in the
+// namespace of the unpack pods, that holds the pod template that is merged
+// into the pods that unpack image bundles. See UnpackPodTemplateKey.
+func WithImagePodTemplateConfigMap(name string) DefaultUnpackerOption {
+	return func(o *defaultUnpackerOptions) {
+		o.imagePodTemplate = name
+	}
+}
+
+// WithImagePodLifecycleConfig configures the cleanup, concurrency and
+// timeout of the pods that unpack image bundles.
+func WithImagePodLifecycleConfig(cfg ImagePodLifecycleConfig) DefaultUnpackerOption {
+	return func(o *defaultUnpackerOptions) {
+		o.imagePodLifecycle = cfg
+	}
+}
+
+// WithSourceCAData configures additional PEM-encoded certificate authorities
+// that are trusted, along with the system certificate authorities, to verify
+// the servers that http, git and image sources fetch bundle content from.
+func WithSourceCAData(caData []byte) DefaultUnpackerOption {
+	return func(o *defaultUnpackerOptions) {
+		o.sourceCAData = caData
+	}
+}
+
+// WithGitCacheConfig configures the on-disk cache of the objects of the git
+// repositories that pinned commits are fetched from. By default, the objects
+// are not cached.
+func WithGitCacheConfig(cfg GitCacheConfig) DefaultUnpackerOption {
+	return func(o *defaultUnpackerOptions) {
+		o.gitCache = cfg
+	}
+}
+
 // NewDefaultUnpacker returns a new composite Source that unpacks bundles using
 // a default source mapping with built-in implementations of all of the supported
 // source types.
 //
 // TODO: refactor NewDefaultUnpacker due to growing parameter list
-func NewDefaultUnpacker(systemNsCluster cluster.Cluster, namespace, unpackImage string, baseUploadManagerURL string, rootCAs *x509.CertPool) (Unpacker, error) {
+func NewDefaultUnpacker(systemNsCluster cluster.Cluster, namespace, unpackImage string, baseUploadManagerURL string, rootCAs *x509.CertPool, opts ...DefaultUnpackerOption) (Unpacker, error) {
+	options := defaultUnpackerOptions{
+		imageUnpackMethod: ImageUnpackMethodPod,
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+
 	cfg := systemNsCluster.GetConfig()
 	kubeClient, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
@@ -114,21 +277,76 @@ func NewDefaultUnpacker(systemNsCluster cluster.Cluster, namespace, unpackImage
 		}
 	}
 	httpTransport.TLSClientConfig.RootCAs = rootCAs
-	return NewUnpacker(map[rukpakv1alpha1.SourceType]Unpacker{
-		rukpakv1alpha1.SourceTypeImage: &Image{
+
+	// Unlike rootCAs, which verify the provisioner's own services, the
+	// certificate authorities of the servers of external sources extend the
+	// system certificate authorities.
+	sourceRootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		sourceRootCAs = x509.NewCertPool()
+	}
+	if len(options.sourceCAData) > 0 && !sourceRootCAs.AppendCertsFromPEM(options.sourceCAData) {
+		return nil, errors.New("no certificates found in source certificate authority data")
+	}
+	sourceTransport := http.DefaultTransport.(*http.Transport).Clone()
+	sourceTransport.TLSClientConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    sourceRootCAs,
+	}
+
+	var gitCache *gitCache
+	if options.gitCache.Dir != "" {
+		gitCache = newGitCache(options.gitCache)
+	}
+
+	var imageUnpacker Unpacker
+	switch options.imageUnpackMethod {
+	case ImageUnpackMethodPod:
+		if options.imageUpload.BaseURL == "" || options.imageUpload.ContentDir == "" {
+			return nil, errors.New("image upload base URL and content directory are required to unpack images with pods")
+		}
+		switch options.imagePodLifecycle.CleanupPolicy {
+		case "", ImagePodCleanupPolicyRetain, ImagePodCleanupPolicyDeleteOnSuccess:
+		default:
+			return nil, fmt.Errorf("unknown image unpack pod cleanup policy %q", options.imagePodLifecycle.CleanupPolicy)
+		}
+		imageUnpacker = &Image{
 			Client:       systemNsCluster.GetClient(),
 			KubeClient:   kubeClient,
 			PodNamespace: namespace,
 			UnpackImage:  unpackImage,
-		},
+			Upload:       options.imageUpload,
+			Transport:    sourceTransport,
+
+			PodTemplateConfigMap: options.imagePodTemplate,
+			Lifecycle:            options.imagePodLifecycle,
+		}
+	case ImageUnpackMethodRegistry:
+		imageUnpacker = &ImageRegistry{
+			Reader:          systemNsCluster.GetClient(),
+			SecretNamespace: namespace,
+			Transport:       sourceTransport,
+		}
+	default:
+		return nil, fmt.Errorf("unknown image unpack method %q", options.imageUnpackMethod)
+	}
+
+	sources := map[rukpakv1alpha1.SourceType]Unpacker{
+		rukpakv1alpha1.SourceTypeImage: imageUnpacker,
 		rukpakv1alpha1.SourceTypeGit: &Git{
 			Reader:          systemNsCluster.GetClient(),
 			SecretNamespace: namespace,
+			RootCAs:         sourceRootCAs,
+			cache:           gitCache,
 		},
 		rukpakv1alpha1.SourceTypeConfigMaps: &ConfigMaps{
 			Reader:             systemNsCluster.GetClient(),
 			ConfigMapNamespace: namespace,
 		},
+		rukpakv1alpha1.SourceTypeSecrets: &Secrets{
+			Reader:          systemNsCluster.GetClient(),
+			SecretNamespace: namespace,
+		},
 		rukpakv1alpha1.SourceTypeUpload: &Upload{
 			baseDownloadURL: baseUploadManagerURL,
 			bearerToken:     systemNsCluster.GetConfig().BearerToken,
@@ -137,6 +355,36 @@ func NewDefaultUnpacker(systemNsCluster cluster.Cluster, namespace, unpackImage
 		rukpakv1alpha1.SourceTypeHTTP: &HTTP{
 			Reader:          systemNsCluster.GetClient(),
 			SecretNamespace: namespace,
+			RootCAs:         sourceRootCAs,
+		},
+		rukpakv1alpha1.SourceTypeS3: &S3{
+			Reader:          systemNsCluster.GetClient(),
+			SecretNamespace: namespace,
+			Transport:       sourceTransport,
 		},
-	}), nil
+		rukpakv1alpha1.SourceTypeHelmRepository: &HelmRepository{
+			Reader:          systemNsCluster.GetClient(),
+			SecretNamespace: namespace,
+			RootCAs:         sourceRootCAs,
+		},
+	}
+	sources[rukpakv1alpha1.SourceTypeComposite] = &Composite{Sources: sources}
+	for _, plugin := range options.plugins.Plugins {
+		if _, ok := sources[plugin.SourceType]; ok {
+			return nil, fmt.Errorf("source plugin type %q conflicts with a built-in source type", plugin.SourceType)
+		}
+		sources[plugin.SourceType] = &Plugin{
+			Endpoint: plugin.Endpoint,
+			Client:   &http.Client{Timeout: pluginClientTimeout, Transport: sourceTransport},
+		}
+	}
+	return NewUnpacker(sources), nil
+}
+
+// WithPluginConfig configures the out-of-tree source plugins that unpack the
+// bundles of source types that are not built in.
+func WithPluginConfig(cfg PluginConfig) DefaultUnpackerOption {
+	return func(o *defaultUnpackerOptions) {
+		o.plugins = cfg
+	}
 }
@@ -1,13 +1,10 @@
 package source
 
 import (
-	"compress/gzip"
 	"context"
 	"fmt"
 	"net/http"
 
-	"github.com/nlepage/go-tarfs"
-
 	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
 )
 
@@ -44,13 +41,15 @@ func (b *Upload) Unpack(ctx context.Context, bundle *rukpakv1alpha1.Bundle) (*Re
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("%s: unexpected status %q", action, resp.Status)
 	}
-	gzipReader, err := gzip.NewReader(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("read response as gzip: %v", err)
+	var format rukpakv1alpha1.ArchiveFormat
+	if bundle.Spec.Source.Upload != nil {
+		format = bundle.Spec.Source.Upload.Format
 	}
-	bundleFS, err := tarfs.New(gzipReader)
+	// The upload service serves all uploads with the same file extension,
+	// so the Content-Type of the response does not reflect the format.
+	bundleFS, err := archiveFS(resp.Body, format, "")
 	if err != nil {
-		return nil, fmt.Errorf("untar bundle contents from response: %v", err)
+		return nil, err
 	}
 
 	message := generateMessage("upload")
@@ -0,0 +1,222 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+const (
+	// cosignSignatureAnnotation is the annotation on the layers of a cosign
+	// signature image that holds the base64-encoded signature of the layer.
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+
+	// cosignSignatureTagSuffix is the suffix of the tag under which cosign
+	// stores the signatures of an image.
+	cosignSignatureTagSuffix = ".sig"
+)
+
+// verificationError returns an UnpackError for a bundle whose content could
+// not be verified.
+func verificationError(err error) error {
+	return &UnpackError{Reason: rukpakv1alpha1.ReasonVerificationFailed, Err: fmt.Errorf("verify bundle signature: %v", err)}
+}
+
+// getVerificationKeys reads the public keys referenced by the given
+// verification from the configmap or secret in the given namespace.
+func getVerificationKeys(ctx context.Context, cl client.Reader, namespace string, verification *rukpakv1alpha1.Verification) ([]crypto.PublicKey, error) {
+	data, source, err := getPublicKeysData(ctx, cl, namespace, verification.PublicKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []crypto.PublicKey
+	for _, k := range sortedKeys(data) {
+		rest := data[k]
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("parse public key %q in %s: %v", k, source, err)
+			}
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public keys found in %s", source)
+	}
+	return keys, nil
+}
+
+// getPublicKeysData returns the data of the configmap or secret in the given
+// namespace that is referenced by keysSource, along with a description of
+// the configmap or secret for error messages.
+func getPublicKeysData(ctx context.Context, cl client.Reader, namespace string, keysSource rukpakv1alpha1.PublicKeysSource) (map[string][]byte, string, error) {
+	switch {
+	case keysSource.ConfigMap != nil:
+		cm := &corev1.ConfigMap{}
+		source := fmt.Sprintf("configmap %s/%s", namespace, keysSource.ConfigMap.Name)
+		if err := cl.Get(ctx, client.ObjectKey{Namespace: namespace, Name: keysSource.ConfigMap.Name}, cm); err != nil {
+			return nil, "", fmt.Errorf("get public keys %s: %v", source, err)
+		}
+		data := map[string][]byte{}
+		for k, v := range cm.Data {
+			data[k] = []byte(v)
+		}
+		for k, v := range cm.BinaryData {
+			data[k] = v
+		}
+		return data, source, nil
+	case keysSource.Secret != nil:
+		secret := &corev1.Secret{}
+		source := fmt.Sprintf("secret %s/%s", namespace, keysSource.Secret.Name)
+		if err := cl.Get(ctx, client.ObjectKey{Namespace: namespace, Name: keysSource.Secret.Name}, secret); err != nil {
+			return nil, "", fmt.Errorf("get public keys %s: %v", source, err)
+		}
+		return secret.Data, source, nil
+	}
+	return nil, "", errors.New("public keys configmap or secret must be set")
+}
+
+// sortedKeys returns the keys of data in a stable order, so that the public
+// keys are parsed in a stable order and errors are deterministic.
+func sortedKeys(data map[string][]byte) []string {
+	names := make([]string, 0, len(data))
+	for k := range data {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// verifyBlobSignature verifies that sig is a signature of blob created by
+// any of the given keys. ECDSA and RSA signatures are expected to sign the
+// SHA-256 digest of the blob, and Ed25519 signatures the blob itself, as
+// created by `cosign sign-blob`.
+func verifyBlobSignature(keys []crypto.PublicKey, blob, sig []byte) error {
+	digest := sha256.Sum256(blob)
+	for _, key := range keys {
+		switch k := key.(type) {
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(k, digest[:], sig) {
+				return nil
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil {
+				return nil
+			}
+		case ed25519.PublicKey:
+			if ed25519.Verify(k, blob, sig) {
+				return nil
+			}
+		}
+	}
+	return errors.New("no valid signature found for any of the trusted public keys")
+}
+
+// decodeSignature decodes a base64-encoded signature, as generated by cosign.
+func decodeSignature(encoded []byte) ([]byte, error) {
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("decode signature: %v", err)
+	}
+	return sig, nil
+}
+
+// simpleSigningPayload is the subset of the cosign simple signing payload
+// that is needed to bind a signature to an image digest.
+type simpleSigningPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+	} `json:"critical"`
+}
+
+// verifyImageSignature verifies that the image with the given digest has a
+// signature, stored in the image's repository using cosign's tag-based
+// convention, that was created by any of the given keys and that signs the
+// image digest.
+func verifyImageSignature(keys []crypto.PublicKey, ref name.Digest, opts ...remote.Option) error {
+	sigRef := ref.Context().Tag(signatureTag(ref.DigestStr()))
+	sigImg, err := remote.Image(sigRef, opts...)
+	if err != nil {
+		return fmt.Errorf("fetch signatures %q: %v", sigRef, err)
+	}
+	manifest, err := sigImg.Manifest()
+	if err != nil {
+		return fmt.Errorf("get signatures manifest %q: %v", sigRef, err)
+	}
+
+	for _, desc := range manifest.Layers {
+		encodedSig, ok := desc.Annotations[cosignSignatureAnnotation]
+		if !ok {
+			continue
+		}
+		sig, err := decodeSignature([]byte(encodedSig))
+		if err != nil {
+			continue
+		}
+		layer, err := sigImg.LayerByDigest(desc.Digest)
+		if err != nil {
+			return fmt.Errorf("get signature payload %q: %v", desc.Digest, err)
+		}
+		payload, err := readLayer(layer.Compressed)
+		if err != nil {
+			return fmt.Errorf("read signature payload %q: %v", desc.Digest, err)
+		}
+		if err := verifyBlobSignature(keys, payload, sig); err != nil {
+			continue
+		}
+
+		// The signature is valid, so check that it actually signs the
+		// image being unpacked rather than some other image.
+		var p simpleSigningPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			continue
+		}
+		if p.Critical.Image.DockerManifestDigest == ref.DigestStr() {
+			return nil
+		}
+	}
+	return fmt.Errorf("no valid signature for %q found for any of the trusted public keys", ref)
+}
+
+func readLayer(open func() (io.ReadCloser, error)) ([]byte, error) {
+	rc, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// signatureTag returns the tag under which cosign stores the signatures of
+// the image with the given digest (e.g. "sha256-abc.sig" for "sha256:abc").
+func signatureTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + cosignSignatureTagSuffix
+}
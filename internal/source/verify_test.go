@@ -0,0 +1,398 @@
+package source
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+var _ = Describe("Signature verification", func() {
+	var (
+		ctx        context.Context
+		trustedKey *ecdsa.PrivateKey
+		otherKey   *ecdsa.PrivateKey
+		cl         client.Reader
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		var err error
+		trustedKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		otherKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+
+		pubKeyDER, err := x509.MarshalPKIXPublicKey(trustedKey.Public())
+		Expect(err).NotTo(HaveOccurred())
+		keysConfigMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "bundle-keys", Namespace: "rukpak-system"},
+			Data: map[string]string{
+				"cosign.pub": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubKeyDER})),
+			},
+		}
+		cl = fake.NewClientBuilder().WithObjects(keysConfigMap).Build()
+	})
+
+	verification := func() *rukpakv1alpha1.Verification {
+		return &rukpakv1alpha1.Verification{
+			PublicKeys: rukpakv1alpha1.PublicKeysSource{
+				ConfigMap: &corev1.LocalObjectReference{Name: "bundle-keys"},
+			},
+		}
+	}
+
+	expectVerificationFailed := func(err error) {
+		Expect(err).To(HaveOccurred())
+		var unpackErr *UnpackError
+		Expect(errors.As(err, &unpackErr)).To(BeTrue())
+		Expect(unpackErr.Reason).To(Equal(rukpakv1alpha1.ReasonVerificationFailed))
+	}
+
+	Describe("HTTP", func() {
+		var (
+			server    *httptest.Server
+			content   []byte
+			signature []byte
+			source    *HTTP
+			bundle    *rukpakv1alpha1.Bundle
+		)
+
+		BeforeEach(func() {
+			content = tarGZ(map[string]string{"manifests/configmap.yaml": "kind: ConfigMap\n"})
+			signature = signBlob(trustedKey, content)
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("/bundle.tgz", func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write(content) })
+			mux.HandleFunc("/bundle.tgz.sig", func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write(signature) })
+			server = httptest.NewServer(mux)
+
+			source = &HTTP{Reader: cl, SecretNamespace: "rukpak-system"}
+			bundle = &rukpakv1alpha1.Bundle{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-bundle"},
+				Spec: rukpakv1alpha1.BundleSpec{
+					Source: rukpakv1alpha1.BundleSource{
+						Type:         rukpakv1alpha1.SourceTypeHTTP,
+						HTTP:         &rukpakv1alpha1.HTTPSource{URL: server.URL + "/bundle.tgz"},
+						Verification: verification(),
+					},
+				},
+			}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should unpack content signed by a trusted key", func() {
+			result, err := source.Unpack(ctx, bundle)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.State).To(Equal(StateUnpacked))
+		})
+
+		It("should reject content signed by an untrusted key", func() {
+			signature = signBlob(otherKey, content)
+			_, err := source.Unpack(ctx, bundle)
+			expectVerificationFailed(err)
+		})
+
+		It("should reject content without a signature", func() {
+			bundle.Spec.Source.Verification.SignatureURL = server.URL + "/missing.sig"
+			_, err := source.Unpack(ctx, bundle)
+			expectVerificationFailed(err)
+		})
+
+		It("should reject content when the public keys are missing", func() {
+			bundle.Spec.Source.Verification.PublicKeys.ConfigMap.Name = "missing"
+			_, err := source.Unpack(ctx, bundle)
+			expectVerificationFailed(err)
+		})
+	})
+
+	Describe("ImageRegistry", func() {
+		var (
+			server    *httptest.Server
+			digestRef name.Digest
+			source    *ImageRegistry
+			bundle    *rukpakv1alpha1.Bundle
+		)
+
+		BeforeEach(func() {
+			server = httptest.NewServer(registry.New())
+
+			imageRef, err := name.ParseReference(fmt.Sprintf("%s/test/bundle:latest", strings.TrimPrefix(server.URL, "http://")))
+			Expect(err).NotTo(HaveOccurred())
+			img, err := crane.Image(map[string][]byte{"manifests/configmap.yaml": []byte("kind: ConfigMap\n")})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(remote.Write(imageRef, img)).To(Succeed())
+			digest, err := img.Digest()
+			Expect(err).NotTo(HaveOccurred())
+			digestRef = imageRef.Context().Digest(digest.String())
+
+			source = &ImageRegistry{Reader: cl, SecretNamespace: "rukpak-system"}
+			bundle = &rukpakv1alpha1.Bundle{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-bundle"},
+				Spec: rukpakv1alpha1.BundleSpec{
+					Source: rukpakv1alpha1.BundleSource{
+						Type:         rukpakv1alpha1.SourceTypeImage,
+						Image:        &rukpakv1alpha1.ImageSource{Ref: imageRef.String()},
+						Verification: verification(),
+					},
+				},
+			}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should unpack an image signed by a trusted key", func() {
+			writeImageSignature(trustedKey, digestRef, digestRef.DigestStr())
+			result, err := source.Unpack(ctx, bundle)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.State).To(Equal(StateUnpacked))
+			Expect(result.ResolvedSource.Verification).To(Equal(bundle.Spec.Source.Verification))
+		})
+
+		It("should reject an image signed by an untrusted key", func() {
+			writeImageSignature(otherKey, digestRef, digestRef.DigestStr())
+			_, err := source.Unpack(ctx, bundle)
+			expectVerificationFailed(err)
+		})
+
+		It("should reject an image whose signature signs a different digest", func() {
+			writeImageSignature(trustedKey, digestRef, "sha256:0000000000000000000000000000000000000000000000000000000000000000")
+			_, err := source.Unpack(ctx, bundle)
+			expectVerificationFailed(err)
+		})
+
+		It("should reject an unsigned image", func() {
+			_, err := source.Unpack(ctx, bundle)
+			expectVerificationFailed(err)
+		})
+	})
+
+	Describe("Git", func() {
+		var (
+			repoDir    string
+			commits    map[string]plumbing.Hash
+			pgpEntity  *openpgp.Entity
+			sshKeyFile string
+			source     *Git
+			bundle     *rukpakv1alpha1.Bundle
+		)
+
+		runGit := func(args ...string) string {
+			cmd := exec.Command("git", append([]string{"-c", "user.name=rukpak", "-c", "user.email=rukpak@example.com", "-c", "gpg.format=ssh", "-c", "user.signingkey=" + sshKeyFile}, args...)...)
+			cmd.Dir = repoDir
+			out, err := cmd.CombinedOutput()
+			Expect(err).NotTo(HaveOccurred(), string(out))
+			return strings.TrimSpace(string(out))
+		}
+
+		commitFile := func(content string) {
+			Expect(os.MkdirAll(filepath.Join(repoDir, "manifests"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(repoDir, "manifests", "version.txt"), []byte(content), 0600)).To(Succeed())
+			runGit("add", ".")
+		}
+
+		BeforeEach(func() {
+			repoDir = GinkgoT().TempDir()
+			commits = map[string]plumbing.Hash{}
+
+			var err error
+			pgpEntity, err = openpgp.NewEntity("rukpak", "", "rukpak@example.com", nil)
+			Expect(err).NotTo(HaveOccurred())
+			sshKeyFile = filepath.Join(GinkgoT().TempDir(), "id_ed25519")
+			out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "rukpak@example.com", "-f", sshKeyFile).CombinedOutput()
+			Expect(err).NotTo(HaveOccurred(), string(out))
+			sshPublicKey, err := os.ReadFile(sshKeyFile + ".pub")
+			Expect(err).NotTo(HaveOccurred())
+
+			// The main branch has a commit and an annotated tag signed with
+			// the GPG key, followed by a commit and an annotated tag signed
+			// with the SSH key and a lightweight tag. The unsigned branch
+			// has an unsigned commit.
+			runGit("init", "-b", "main")
+			repo, err := git.PlainOpen(repoDir)
+			Expect(err).NotTo(HaveOccurred())
+			wt, err := repo.Worktree()
+			Expect(err).NotTo(HaveOccurred())
+			signature := &object.Signature{Name: "rukpak", Email: "rukpak@example.com", When: time.Now()}
+			commitFile("gpg")
+			commits["gpg"], err = wt.Commit("gpg", &git.CommitOptions{Author: signature, SignKey: pgpEntity})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = repo.CreateTag("v1.0.0", commits["gpg"], &git.CreateTagOptions{Tagger: signature, Message: "v1.0.0", SignKey: pgpEntity})
+			Expect(err).NotTo(HaveOccurred())
+
+			commitFile("ssh")
+			runGit("commit", "-S", "-m", "ssh")
+			commits["ssh"] = plumbing.NewHash(runGit("rev-parse", "HEAD"))
+			runGit("tag", "-s", "-m", "v2.0.0", "v2.0.0")
+			runGit("tag", "lightweight")
+
+			runGit("checkout", "-b", "unsigned")
+			commitFile("unsigned")
+			runGit("commit", "--no-gpg-sign", "-m", "unsigned")
+
+			armored := &bytes.Buffer{}
+			w, err := armor.Encode(armored, openpgp.PublicKeyType, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pgpEntity.Serialize(w)).To(Succeed())
+			Expect(w.Close()).To(Succeed())
+			keysSecret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "git-keys", Namespace: "rukpak-system"},
+				Data: map[string][]byte{
+					"gpg.asc":         armored.Bytes(),
+					"authorized_keys": sshPublicKey,
+				},
+			}
+
+			source = &Git{Reader: fake.NewClientBuilder().WithObjects(keysSecret).Build(), SecretNamespace: "rukpak-system"}
+			bundle = &rukpakv1alpha1.Bundle{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-bundle"},
+				Spec: rukpakv1alpha1.BundleSpec{
+					Source: rukpakv1alpha1.BundleSource{
+						Type: rukpakv1alpha1.SourceTypeGit,
+						Git: &rukpakv1alpha1.GitSource{
+							Repository: repoDir,
+							Ref:        rukpakv1alpha1.GitRef{Branch: "main"},
+							Verify: &rukpakv1alpha1.GitVerification{
+								PublicKeys: rukpakv1alpha1.PublicKeysSource{
+									Secret: &corev1.LocalObjectReference{Name: "git-keys"},
+								},
+							},
+						},
+					},
+				},
+			}
+		})
+
+		It("should unpack a commit signed by a trusted SSH key", func() {
+			result, err := source.Unpack(ctx, bundle)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.State).To(Equal(StateUnpacked))
+			Expect(result.Message).To(ContainSubstring(fmt.Sprintf("commit %q is signed by rukpak@example.com (SHA256:", commits["ssh"].String())))
+		})
+
+		It("should unpack a commit signed by a trusted GPG key", func() {
+			bundle.Spec.Source.Git.Ref = rukpakv1alpha1.GitRef{Commit: commits["gpg"].String()}
+			result, err := source.Unpack(ctx, bundle)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.State).To(Equal(StateUnpacked))
+			Expect(result.Message).To(ContainSubstring(fmt.Sprintf("commit %q is signed by rukpak <rukpak@example.com> (%s)", commits["gpg"].String(), pgpEntity.PrimaryKey.KeyIdString())))
+		})
+
+		It("should unpack annotated tags signed by trusted keys", func() {
+			bundle.Spec.Source.Git.Ref = rukpakv1alpha1.GitRef{Tag: "v1.0.0"}
+			result, err := source.Unpack(ctx, bundle)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Message).To(ContainSubstring(`tag "v1.0.0" is signed by rukpak <rukpak@example.com>`))
+
+			bundle.Spec.Source.Git.Ref = rukpakv1alpha1.GitRef{Tag: "v2.0.0"}
+			result, err = source.Unpack(ctx, bundle)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Message).To(ContainSubstring(`tag "v2.0.0" is signed by rukpak@example.com`))
+		})
+
+		It("should reject a commit signed by an untrusted key", func() {
+			source.Reader = fake.NewClientBuilder().WithObjects(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "git-keys", Namespace: "rukpak-system"},
+				Data:       map[string][]byte{"authorized_keys": []byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl other@example.com\n")},
+			}).Build()
+			_, err := source.Unpack(ctx, bundle)
+			expectVerificationFailed(err)
+			Expect(err).To(MatchError(ContainSubstring("not signed by any of the trusted public keys")))
+		})
+
+		It("should reject an unsigned commit", func() {
+			bundle.Spec.Source.Git.Ref = rukpakv1alpha1.GitRef{Branch: "unsigned"}
+			_, err := source.Unpack(ctx, bundle)
+			expectVerificationFailed(err)
+			Expect(err).To(MatchError(ContainSubstring("is not signed")))
+		})
+
+		It("should reject a lightweight tag", func() {
+			bundle.Spec.Source.Git.Ref = rukpakv1alpha1.GitRef{Tag: "lightweight"}
+			_, err := source.Unpack(ctx, bundle)
+			expectVerificationFailed(err)
+			Expect(err).To(MatchError(ContainSubstring(`tag "lightweight" is not an annotated tag`)))
+		})
+	})
+})
+
+// signBlob returns the base64-encoded signature of blob, as generated by
+// `cosign sign-blob`.
+func signBlob(key *ecdsa.PrivateKey, blob []byte) []byte {
+	digest := sha256.Sum256(blob)
+	sig, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	Expect(err).NotTo(HaveOccurred())
+	return []byte(base64.StdEncoding.EncodeToString(sig))
+}
+
+// writeImageSignature pushes a cosign signature for the image with the given
+// reference, whose payload claims to sign signedDigest.
+func writeImageSignature(key *ecdsa.PrivateKey, ref name.Digest, signedDigest string) {
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":%q},"image":{"docker-manifest-digest":%q},"type":"cosign container image signature"},"optional":null}`, ref.Context().Name(), signedDigest))
+	sigImg, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       static.NewLayer(payload, types.MediaType("application/vnd.dev.cosign.simplesigning.v1+json")),
+		Annotations: map[string]string{cosignSignatureAnnotation: string(signBlob(key, payload))},
+	})
+	Expect(err).NotTo(HaveOccurred())
+	Expect(remote.Write(ref.Context().Tag(signatureTag(ref.DigestStr())), sigImg)).To(Succeed())
+}
+
+func tarGZ(files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	gzw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gzw)
+	for path, content := range files {
+		Expect(tw.WriteHeader(&tar.Header{Name: path, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})).To(Succeed())
+		_, err := tw.Write([]byte(content))
+		Expect(err).NotTo(HaveOccurred())
+	}
+	Expect(tw.Close()).To(Succeed())
+	Expect(gzw.Close()).To(Succeed())
+	return buf.Bytes()
+}
@@ -0,0 +1,43 @@
+// Package unpack holds what the unpack binary, which runs in the pods that
+// unpack image bundles, shares with the image source of the provisioners.
+package unpack
+
+import (
+	"path"
+	"strings"
+)
+
+// Exit codes of the unpack binary that identify failures to upload the bundle
+// content, so that the image source can report them.
+const (
+	ExitCodeUploadFailed    = 2
+	ExitCodeContentTooLarge = 3
+)
+
+// skipRootPaths are the directories of the root filesystem of an image that
+// are not part of the bundle when the bundle directory is the image root.
+// They are either populated by the container runtime or belong to the base
+// image of the unpack binary, so skipping them makes unpacking an image with a
+// pod and pulling it from the registry produce the same bundle content.
+var skipRootPaths = []string{
+	"/dev",
+	"/etc",
+	"/proc",
+	"/product_name",
+	"/product_uuid",
+	"/sys",
+	"/bin",
+}
+
+// IsSkippedRootPath returns true if the path, relative to the root of an
+// image filesystem, is in one of the directories that are not part of the
+// bundle when the bundle directory is the image root.
+func IsSkippedRootPath(p string) bool {
+	p = path.Clean("/" + p)
+	for _, skip := range skipRootPaths {
+		if p == skip || strings.HasPrefix(p, skip+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package uploadmgr
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/gorilla/mux"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+const (
+	// UploadSubresource is the subresource of bundles on which users must be
+	// allowed the create verb to upload the content of a bundle.
+	UploadSubresource = "upload"
+
+	// proxyGroupsSeparator separates the groups in the groups header of an
+	// authenticating proxy.
+	proxyGroupsSeparator = "|"
+)
+
+// Authorizer authenticates the users that upload bundle content and
+// authorizes them to upload the content of bundles.
+type Authorizer interface {
+	// Authenticate returns the user that the bearer token belongs to, or
+	// false if the token is not valid.
+	Authenticate(ctx context.Context, token string) (authenticationv1.UserInfo, bool, error)
+
+	// Authorize returns whether the user may upload the content of the named
+	// bundle and, if not, the reason why.
+	Authorize(ctx context.Context, user authenticationv1.UserInfo, bundleName string) (bool, string, error)
+}
+
+// NewReviewAuthorizer returns an Authorizer that authenticates users with
+// TokenReviews and authorizes them with SubjectAccessReviews of the create
+// verb on the upload subresource of bundles.
+func NewReviewAuthorizer(cl client.Client) Authorizer {
+	return &reviewAuthorizer{cl: cl}
+}
+
+type reviewAuthorizer struct {
+	cl client.Client
+}
+
+func (a *reviewAuthorizer) Authenticate(ctx context.Context, token string) (authenticationv1.UserInfo, bool, error) {
+	review := &authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: token}}
+	if err := a.cl.Create(ctx, review); err != nil {
+		return authenticationv1.UserInfo{}, false, fmt.Errorf("review token: %v", err)
+	}
+	return review.Status.User, review.Status.Authenticated, nil
+}
+
+func (a *reviewAuthorizer) Authorize(ctx context.Context, user authenticationv1.UserInfo, bundleName string) (bool, string, error) {
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	review := &authorizationv1.SubjectAccessReview{Spec: authorizationv1.SubjectAccessReviewSpec{
+		User:   user.Username,
+		UID:    user.UID,
+		Groups: user.Groups,
+		Extra:  extra,
+		ResourceAttributes: &authorizationv1.ResourceAttributes{
+			Group:       rukpakv1alpha1.GroupVersion.Group,
+			Version:     rukpakv1alpha1.GroupVersion.Version,
+			Resource:    "bundles",
+			Subresource: UploadSubresource,
+			Name:        bundleName,
+			Verb:        "create",
+		},
+	}}
+	if err := a.cl.Create(ctx, review); err != nil {
+		return false, "", fmt.Errorf("review access: %v", err)
+	}
+	return review.Status.Allowed, review.Status.Reason, nil
+}
+
+// ProxyAuthConfig configures the headers in which an authenticating proxy in
+// front of the upload handler, such as kube-rbac-proxy, passes the identity
+// of the users that it authenticated. The handler must only be reachable
+// through the proxy if these headers are trusted.
+type ProxyAuthConfig struct {
+	// UserHeader is the header that holds the name of the user. If empty,
+	// users are only authenticated with their bearer tokens.
+	UserHeader string
+
+	// GroupsHeader is the header that holds the groups of the user,
+	// separated by "|".
+	GroupsHeader string
+}
+
+// authorize returns a handler that authenticates the user of the request,
+// authorizes it to upload the content of the bundle of the request, and
+// writes an audit log line with the user and the outcome of each request.
+func (u *uploads) authorize(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bundleName := mux.Vars(r)["bundleName"]
+		l := u.auditLog.WithValues("bundle", bundleName, "method", r.Method, "digest", r.Header.Get(DigestHeader))
+		if offset := r.Header.Get(OffsetHeader); offset != "" {
+			l = l.WithValues("offset", offset)
+		}
+
+		user, ok := u.authenticate(w, r, l)
+		if !ok {
+			return
+		}
+		l = l.WithValues("user", user.Username, "groups", user.Groups)
+
+		allowed, reason, err := u.authorizer.Authorize(r.Context(), user, bundleName)
+		if err != nil {
+			l.Error(err, "upload authorization failed")
+			http.Error(w, "upload authorization failed", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			l.Info("upload denied", "reason", reason)
+			msg := fmt.Sprintf("user %q cannot create resource \"bundles/%s\" in API group %q for bundle %q", user.Username, UploadSubresource, rukpakv1alpha1.GroupVersion.Group, bundleName)
+			if reason != "" {
+				msg = fmt.Sprintf("%s: %s", msg, reason)
+			}
+			http.Error(w, msg, http.StatusForbidden)
+			return
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h(sw, r)
+		l.Info("upload handled", "status", sw.status)
+	})
+}
+
+// authenticate returns the user of the request. If the user cannot be
+// authenticated, it writes the error to the response and returns false.
+func (u *uploads) authenticate(w http.ResponseWriter, r *http.Request, l logr.Logger) (authenticationv1.UserInfo, bool) {
+	if u.proxyAuth.UserHeader != "" {
+		if username := r.Header.Get(u.proxyAuth.UserHeader); username != "" {
+			user := authenticationv1.UserInfo{Username: username}
+			if groups := r.Header.Get(u.proxyAuth.GroupsHeader); u.proxyAuth.GroupsHeader != "" && groups != "" {
+				user.Groups = strings.Split(groups, proxyGroupsSeparator)
+			}
+			return user, true
+		}
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		l.Info("upload denied", "reason", "missing bearer token")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return authenticationv1.UserInfo{}, false
+	}
+	user, authenticated, err := u.authorizer.Authenticate(r.Context(), token)
+	if err != nil {
+		l.Error(err, "upload authentication failed")
+		http.Error(w, "upload authentication failed", http.StatusInternalServerError)
+		return authenticationv1.UserInfo{}, false
+	}
+	if !authenticated {
+		l.Info("upload denied", "reason", "invalid bearer token")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return authenticationv1.UserInfo{}, false
+	}
+	return user, true
+}
+
+// statusWriter records the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
@@ -2,6 +2,7 @@ package uploadmgr
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -17,6 +18,10 @@ import (
 	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
 )
 
+// partialUploadTTL is how long incomplete uploads of bundles that are not
+// unpacked yet are kept since they were last written to.
+const partialUploadTTL = 24 * time.Hour
+
 type bundleGC struct {
 	storageDir          string
 	storageSyncInterval time.Duration
@@ -81,6 +86,9 @@ func (gc *bundleGC) Start(ctx context.Context) error {
 			}
 			existingFiles := sets.NewString()
 			for _, e := range storageDirEntries {
+				if e.Name() == partialDir {
+					continue
+				}
 				existingFiles.Insert(e.Name())
 			}
 			bundles := &rukpakv1alpha1.BundleList{}
@@ -88,12 +96,17 @@ func (gc *bundleGC) Start(ctx context.Context) error {
 				gc.log.Error(err, "failed to list bundles from cache", err)
 				continue
 			}
+			pendingBundles := sets.NewString()
 			for _, bundle := range bundles.Items {
 				if bundle.Spec.Source.Type != rukpakv1alpha1.SourceTypeUpload {
 					continue
 				}
 				existingFiles.Delete(filepath.Base(bundlePath(gc.storageDir, bundle.Name)))
+				if bundle.Status.Phase != rukpakv1alpha1.PhaseUnpacked {
+					pendingBundles.Insert(bundle.Name)
+				}
 			}
+			gc.removeStalePartials(pendingBundles)
 			for _, staleFile := range existingFiles.List() {
 				filename := filepath.Join(gc.storageDir, staleFile)
 				gc.log.Info("removing file", "path", filename)
@@ -104,3 +117,38 @@ func (gc *bundleGC) Start(ctx context.Context) error {
 		}
 	}
 }
+
+// removeStalePartials removes the incomplete uploads that are not of one of
+// the given bundles, which are not unpacked yet, or that were not written to
+// within the partial upload TTL.
+func (gc *bundleGC) removeStalePartials(pendingBundles sets.String) {
+	dir := filepath.Join(gc.storageDir, partialDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			gc.log.Error(err, "failed to read partial upload directory")
+		}
+		return
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		keep := time.Since(info.ModTime()) < partialUploadTTL
+		if bundleName, ok := partialBundleName(e.Name()); ok {
+			keep = keep && pendingBundles.Has(bundleName)
+		}
+		// Other files are the temporary files of uploads in a single request,
+		// which are only left behind if the request was interrupted by a
+		// restart.
+		if keep {
+			continue
+		}
+		filename := filepath.Join(dir, e.Name())
+		gc.log.Info("removing file", "path", filename)
+		if err := os.RemoveAll(filename); err != nil {
+			gc.log.Error(err, "failed to remove file", "path", filename)
+		}
+	}
+}
@@ -1,108 +1,332 @@
 package uploadmgr
 
 import (
-	"bytes"
+	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sync"
 
+	"github.com/go-logr/logr"
 	"github.com/gorilla/mux"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
 	"github.com/operator-framework/rukpak/internal/util"
 )
 
-const DefaultBundleCacheDir = "/var/cache/uploads"
+const (
+	DefaultBundleCacheDir = "/var/cache/uploads"
+
+	// DefaultMaxUploadSize is the default maximum size, in bytes, of uploaded
+	// bundle content.
+	DefaultMaxUploadSize int64 = 100 << 20
+
+	// DigestHeader is the header in which clients supply the digest of the
+	// complete upload, in the form `sha256:<hex>`. The upload is rejected if
+	// the digest of the content received by the server does not match. It is
+	// optional for uploads in a single request and required for chunked
+	// uploads.
+	DigestHeader = "Upload-Digest"
+
+	// OffsetHeader is the header in which the offset of a chunk is supplied
+	// by clients, and the offset at which an incomplete upload continues is
+	// returned by the server.
+	OffsetHeader = "Upload-Offset"
+
+	// LengthHeader is the header in which clients supply the length of the
+	// complete upload with each chunk.
+	LengthHeader = "Upload-Length"
+
+	// partialDir is the directory, in the storage directory, in which
+	// incomplete uploads are stored.
+	partialDir = ".partial"
+)
+
+var digestRegexp = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
 
 //+kubebuilder:rbac:groups=core.rukpak.io,resources=bundles,verbs=list;watch
 //+kubebuilder:rbac:groups=core.rukpak.io,resources=bundles/status,verbs=update;patch
 //+kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
 //+kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
 
-func NewUploadHandler(cl client.Client, storageDir string) http.Handler {
+type handlerOptions struct {
+	maxUploadSize int64
+	authorizer    Authorizer
+	proxyAuth     ProxyAuthConfig
+}
+
+// HandlerOption configures optional behavior of the handler returned by
+// NewUploadHandler.
+type HandlerOption func(*handlerOptions)
+
+// WithMaxUploadSize configures the maximum size, in bytes, of uploaded bundle
+// content. By default, it is DefaultMaxUploadSize.
+func WithMaxUploadSize(size int64) HandlerOption {
+	return func(o *handlerOptions) {
+		o.maxUploadSize = size
+	}
+}
+
+// WithAuthorizer configures how the users that upload bundle content are
+// authenticated and authorized. By default, they are authenticated and
+// authorized with the Authorizer returned by NewReviewAuthorizer.
+func WithAuthorizer(a Authorizer) HandlerOption {
+	return func(o *handlerOptions) {
+		o.authorizer = a
+	}
+}
+
+// WithProxyAuthConfig configures the headers in which an authenticating
+// proxy passes the identity of the users that upload bundle content. By
+// default, users are only authenticated with their bearer tokens.
+func WithProxyAuthConfig(cfg ProxyAuthConfig) HandlerOption {
+	return func(o *handlerOptions) {
+		o.proxyAuth = cfg
+	}
+}
+
+// NewUploadHandler returns an http.Handler that serves and accepts the
+// content of upload bundles.
+//
+// Content is uploaded either in a single PUT request to
+// /uploads/{bundleName}.tgz, or in chunks with the resumable protocol served
+// at /uploads/{bundleName}.tgz/partial:
+//
+//   - A HEAD request, with the digest of the complete content in the
+//     Upload-Digest header, returns the Upload-Offset at which the upload
+//     continues, or 204 No Content if the bundle already has that content.
+//   - Each PATCH request appends a chunk at its Upload-Offset, and supplies
+//     the Upload-Digest and Upload-Length of the complete content. Chunks at
+//     any other offset than the current one are rejected with 409 Conflict
+//     and the current Upload-Offset. Incomplete uploads return 202 Accepted
+//     and the new Upload-Offset.
+//   - The PATCH request that completes the upload verifies its digest and
+//     returns 201 Created, or 204 No Content if the bundle already had that
+//     content, like a PUT request.
+//
+// In either case, the content is stored in a temporary file that is renamed
+// atomically once it is complete, so that incomplete content is never served.
+//
+// Uploads are only accepted from users that are allowed to create the upload
+// subresource of the bundle, see Authorizer. Each upload request is logged
+// along with its user.
+func NewUploadHandler(cl client.Client, storageDir string, opts ...HandlerOption) http.Handler {
+	options := handlerOptions{
+		maxUploadSize: DefaultMaxUploadSize,
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+	if options.authorizer == nil {
+		options.authorizer = NewReviewAuthorizer(cl)
+	}
+	u := &uploads{
+		cl:            cl,
+		storageDir:    storageDir,
+		maxUploadSize: options.maxUploadSize,
+		authorizer:    options.authorizer,
+		proxyAuth:     options.proxyAuth,
+		auditLog:      ctrl.Log.WithName("uploads").WithName("audit"),
+	}
+
 	r := mux.NewRouter()
 	r.Methods(http.MethodGet).Path("/uploads/{bundleName}.tgz").Handler(http.StripPrefix("/uploads/", http.FileServer(http.FS(&util.FilesOnlyFilesystem{FS: os.DirFS(storageDir)}))))
-	r.Methods(http.MethodPut).Path("/uploads/{bundleName}.tgz").Handler(newPutHandler(cl, storageDir))
+	r.Methods(http.MethodPut).Path("/uploads/{bundleName}.tgz").Handler(u.authorize(u.put))
+	r.Methods(http.MethodHead).Path("/uploads/{bundleName}.tgz/partial").Handler(u.authorize(u.headPartial))
+	r.Methods(http.MethodPatch).Path("/uploads/{bundleName}.tgz/partial").Handler(u.authorize(u.patchPartial))
 	return r
 }
 
-func newPutHandler(cl client.Client, storageDir string) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		bundleName := mux.Vars(r)["bundleName"]
+type uploads struct {
+	cl            client.Client
+	storageDir    string
+	maxUploadSize int64
+	authorizer    Authorizer
+	proxyAuth     ProxyAuthConfig
+	auditLog      logr.Logger
 
-		bundle := &rukpakv1alpha1.Bundle{}
-		if err := cl.Get(r.Context(), types.NamespacedName{Name: bundleName}, bundle); err != nil {
-			http.Error(w, err.Error(), int(getCode(err)))
-			return
-		}
-		if bundle.Spec.Source.Type != rukpakv1alpha1.SourceTypeUpload {
-			http.Error(w, fmt.Sprintf("bundle source type is %q; expected %q", bundle.Spec.Source.Type, rukpakv1alpha1.SourceTypeUpload), http.StatusConflict)
-			return
-		}
+	mu sync.Mutex
 
-		uploadBundleData, err := io.ReadAll(r.Body)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("read request body: %v", err), http.StatusInternalServerError)
-			return
-		}
-		bundleFilePath := bundlePath(storageDir, bundleName)
-		if existingData, err := os.ReadFile(bundleFilePath); err == nil {
-			if bytes.Equal(uploadBundleData, existingData) {
-				w.WriteHeader(http.StatusNoContent)
-				return
-			}
-		}
+	// partialLocks serializes the requests for the same incomplete upload.
+	// Locks are removed once no request holds or waits for them.
+	partialLocks map[string]*partialLock
 
-		if bundle.Status.Phase == rukpakv1alpha1.PhaseUnpacked {
-			http.Error(w, "bundle has already been unpacked, cannot change content of existing bundle", http.StatusConflict)
-			return
-		}
+	// digests are the digests of the stored bundle content, so that the
+	// content is not hashed again by every request.
+	digests util.FileDigests
+}
 
-		bundleFile, err := os.Create(bundleFilePath)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("failed to store bundle data: %v", err), http.StatusInternalServerError)
-			return
-		}
-		defer bundleFile.Close()
+func (u *uploads) put(w http.ResponseWriter, r *http.Request) {
+	bundleName := mux.Vars(r)["bundleName"]
+	bundle, ok := u.getUploadBundle(w, r, bundleName)
+	if !ok {
+		return
+	}
+	expectedDigest, ok := parseDigestHeader(w, r, false)
+	if !ok {
+		return
+	}
+	if r.ContentLength > u.maxUploadSize {
+		http.Error(w, fmt.Sprintf("bundle content size %d exceeds the maximum of %d bytes", r.ContentLength, u.maxUploadSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	tmpDir, err := u.partialDir()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to store bundle data: %v", err), http.StatusInternalServerError)
+		return
+	}
+	tmpFile, err := os.CreateTemp(tmpDir, fmt.Sprintf("%s-*.tmp", bundleName))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to store bundle data: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
 
-		if _, err := bundleFile.Write(uploadBundleData); err != nil {
-			http.Error(w, fmt.Sprintf("failed to store bundle data: %v", err), http.StatusInternalServerError)
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmpFile, hasher), http.MaxBytesReader(w, r.Body, u.maxUploadSize)); err != nil {
+		if maxBytesErr := (*http.MaxBytesError)(nil); errors.As(err, &maxBytesErr) {
+			http.Error(w, fmt.Sprintf("bundle content exceeds the maximum of %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
 			return
 		}
+		http.Error(w, fmt.Sprintf("read request body: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := tmpFile.Close(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to store bundle data: %v", err), http.StatusInternalServerError)
+		return
+	}
+	digest := fmt.Sprintf("sha256:%x", hasher.Sum(nil))
+	if expectedDigest != "" && digest != expectedDigest {
+		http.Error(w, fmt.Sprintf("bundle content digest %q does not match expected digest %q", digest, expectedDigest), http.StatusBadRequest)
+		return
+	}
+	u.commit(w, r, bundle, tmpFile.Name(), digest)
+}
 
-		if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-			if err := cl.Get(r.Context(), types.NamespacedName{Name: bundleName}, bundle); err != nil {
-				return err
-			}
-			if bundle.Status.Phase == rukpakv1alpha1.PhaseUnpacked {
-				return nil
-			}
-
-			bundle.Status.Phase = rukpakv1alpha1.PhasePending
-			meta.SetStatusCondition(&bundle.Status.Conditions, metav1.Condition{
-				Type:    rukpakv1alpha1.TypeUnpacked,
-				Status:  metav1.ConditionFalse,
-				Reason:  rukpakv1alpha1.ReasonUnpackPending,
-				Message: "received bundle upload, waiting for provisioner to unpack it.",
-			})
-			return cl.Status().Update(r.Context(), bundle)
-		}); err != nil {
-			http.Error(w, err.Error(), int(getCode(err)))
-			return
+// getUploadBundle gets the bundle of an upload. If the bundle cannot be
+// uploaded, it writes the error to the response and returns false.
+func (u *uploads) getUploadBundle(w http.ResponseWriter, r *http.Request, bundleName string) (*rukpakv1alpha1.Bundle, bool) {
+	bundle := &rukpakv1alpha1.Bundle{}
+	if err := u.cl.Get(r.Context(), types.NamespacedName{Name: bundleName}, bundle); err != nil {
+		http.Error(w, err.Error(), int(getCode(err)))
+		return nil, false
+	}
+	if bundle.Spec.Source.Type != rukpakv1alpha1.SourceTypeUpload {
+		http.Error(w, fmt.Sprintf("bundle source type is %q; expected %q", bundle.Spec.Source.Type, rukpakv1alpha1.SourceTypeUpload), http.StatusConflict)
+		return nil, false
+	}
+	return bundle, true
+}
+
+// commit stores the complete content of an upload, with the given digest,
+// from the given file as the content of the bundle, unless the bundle already
+// has that content, and marks the bundle as pending to be unpacked.
+func (u *uploads) commit(w http.ResponseWriter, r *http.Request, bundle *rukpakv1alpha1.Bundle, path, digest string) {
+	if !u.checkContentChange(w, bundle, digest) {
+		return
+	}
+	if err := os.Rename(path, bundlePath(u.storageDir, bundle.Name)); err != nil {
+		http.Error(w, fmt.Sprintf("failed to store bundle data: %v", err), http.StatusInternalServerError)
+		return
+	}
+	u.setBundleDigest(bundle.Name, digest)
+	if err := markUploadPending(r.Context(), u.cl, bundle.Name); err != nil {
+		http.Error(w, err.Error(), int(getCode(err)))
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// checkContentChange returns true if the content of the bundle can be changed
+// to the content with the given digest. Otherwise, it writes 204 No Content
+// to the response if the bundle already has that content, or the reason why
+// its content cannot be changed. The digest of the stored content is cached
+// by its size and modification time, so content that was replaced in place
+// without changing them is still reported as unchanged, and the upload is
+// not stored again. Stored content is only expected to be written by this
+// handler, which renames complete files into place.
+func (u *uploads) checkContentChange(w http.ResponseWriter, bundle *rukpakv1alpha1.Bundle, digest string) bool {
+	if existingDigest, err := u.bundleDigest(bundle.Name); err == nil && existingDigest == digest {
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+	if bundle.Status.Phase == rukpakv1alpha1.PhaseUnpacked {
+		http.Error(w, "bundle has already been unpacked, cannot change content of existing bundle", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+func markUploadPending(ctx context.Context, cl client.Client, bundleName string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		bundle := &rukpakv1alpha1.Bundle{}
+		if err := cl.Get(ctx, types.NamespacedName{Name: bundleName}, bundle); err != nil {
+			return err
 		}
-		w.WriteHeader(http.StatusCreated)
+		if bundle.Status.Phase == rukpakv1alpha1.PhaseUnpacked {
+			return nil
+		}
+
+		bundle.Status.Phase = rukpakv1alpha1.PhasePending
+		meta.SetStatusCondition(&bundle.Status.Conditions, metav1.Condition{
+			Type:    rukpakv1alpha1.TypeUnpacked,
+			Status:  metav1.ConditionFalse,
+			Reason:  rukpakv1alpha1.ReasonUnpackPending,
+			Message: "received bundle upload, waiting for provisioner to unpack it.",
+		})
+		return cl.Status().Update(ctx, bundle)
 	})
 }
 
+// parseDigestHeader returns the digest supplied by the client. If the digest
+// is invalid, or missing and required, it writes the error to the response
+// and returns false.
+func parseDigestHeader(w http.ResponseWriter, r *http.Request, required bool) (string, bool) {
+	digest := r.Header.Get(DigestHeader)
+	if digest == "" && !required {
+		return "", true
+	}
+	if !digestRegexp.MatchString(digest) {
+		http.Error(w, fmt.Sprintf("invalid %s header %q: expected sha256:<hex>", DigestHeader, digest), http.StatusBadRequest)
+		return "", false
+	}
+	return digest, true
+}
+
+func (u *uploads) partialDir() (string, error) {
+	dir := filepath.Join(u.storageDir, partialDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// bundleDigest returns the digest of the stored content of the bundle. It
+// is only computed if the content changed since it was last computed.
+func (u *uploads) bundleDigest(bundleName string) (string, error) {
+	return u.digests.Get(bundlePath(u.storageDir, bundleName))
+}
+
+// setBundleDigest records the digest of the content that was just stored
+// for the bundle.
+func (u *uploads) setBundleDigest(bundleName, digest string) {
+	u.digests.Set(bundlePath(u.storageDir, bundleName), digest)
+}
+
 func getCode(err error) int32 {
 	if status := apierrors.APIStatus(nil); errors.As(err, &status) {
 		return status.Status().Code
@@ -0,0 +1,208 @@
+package uploadmgr
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+type fakeAuthorizer struct {
+	allowedBundle string
+}
+
+func (a *fakeAuthorizer) Authenticate(_ context.Context, token string) (authenticationv1.UserInfo, bool, error) {
+	return authenticationv1.UserInfo{Username: token}, token != "invalid", nil
+}
+
+func (a *fakeAuthorizer) Authorize(_ context.Context, user authenticationv1.UserInfo, bundleName string) (bool, string, error) {
+	return user.Username == "uploader" && bundleName == a.allowedBundle, "", nil
+}
+
+var _ = Describe("Upload handler", func() {
+	var (
+		cl         client.Client
+		storageDir string
+		handler    http.Handler
+		token      string
+		content    []byte
+		digest     string
+	)
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(rukpakv1alpha1.AddToScheme(scheme)).To(Succeed())
+		cl = fake.NewClientBuilder().WithScheme(scheme).WithObjects(&rukpakv1alpha1.Bundle{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-bundle"},
+			Spec: rukpakv1alpha1.BundleSpec{
+				Source: rukpakv1alpha1.BundleSource{Type: rukpakv1alpha1.SourceTypeUpload},
+			},
+		}).Build()
+		storageDir = GinkgoT().TempDir()
+		token = "uploader"
+		handler = NewUploadHandler(cl, storageDir, WithMaxUploadSize(1024), WithAuthorizer(&fakeAuthorizer{allowedBundle: "test-bundle"}))
+		content = bytes.Repeat([]byte("bundle content "), 10)
+		digest = fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+	})
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	head := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodHead, "/uploads/test-bundle.tgz/partial", nil)
+		req.Header.Set(DigestHeader, digest)
+		return serve(req)
+	}
+
+	patch := func(offset, end int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/uploads/test-bundle.tgz/partial", bytes.NewReader(content[offset:end]))
+		req.Header.Set(DigestHeader, digest)
+		req.Header.Set(OffsetHeader, strconv.Itoa(offset))
+		req.Header.Set(LengthHeader, strconv.Itoa(len(content)))
+		return serve(req)
+	}
+
+	It("should store content uploaded in chunks once the upload is complete", func() {
+		Expect(head().Header().Get(OffsetHeader)).To(Equal("0"))
+
+		rec := patch(0, 50)
+		Expect(rec.Code).To(Equal(http.StatusAccepted))
+		Expect(rec.Header().Get(OffsetHeader)).To(Equal("50"))
+		Expect(bundlePath(storageDir, "test-bundle")).NotTo(BeAnExistingFile())
+		Expect(head().Header().Get(OffsetHeader)).To(Equal("50"))
+
+		Expect(patch(50, len(content)).Code).To(Equal(http.StatusCreated))
+		Expect(os.ReadFile(bundlePath(storageDir, "test-bundle"))).To(Equal(content))
+
+		bundle := &rukpakv1alpha1.Bundle{}
+		Expect(cl.Get(context.Background(), client.ObjectKey{Name: "test-bundle"}, bundle)).To(Succeed())
+		Expect(bundle.Status.Phase).To(Equal(rukpakv1alpha1.PhasePending))
+
+		Expect(head().Code).To(Equal(http.StatusNoContent))
+	})
+
+	It("should reject chunks at another offset than the upload offset", func() {
+		Expect(patch(0, 50).Code).To(Equal(http.StatusAccepted))
+		rec := patch(60, len(content))
+		Expect(rec.Code).To(Equal(http.StatusConflict))
+		Expect(rec.Header().Get(OffsetHeader)).To(Equal("50"))
+	})
+
+	It("should discard complete uploads that do not match their digest", func() {
+		digest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+		rec := patch(0, len(content))
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+		Expect(rec.Body.String()).To(ContainSubstring("does not match expected digest"))
+		Expect(head().Header().Get(OffsetHeader)).To(Equal("0"))
+		Expect(bundlePath(storageDir, "test-bundle")).NotTo(BeAnExistingFile())
+	})
+
+	It("should reject uploads that exceed the maximum size", func() {
+		rec := serve(httptest.NewRequest(http.MethodPut, "/uploads/test-bundle.tgz", bytes.NewReader(make([]byte, 2048))))
+		Expect(rec.Code).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(bundlePath(storageDir, "test-bundle")).NotTo(BeAnExistingFile())
+	})
+
+	It("should verify the digest of uploads in a single request", func() {
+		req := httptest.NewRequest(http.MethodPut, "/uploads/test-bundle.tgz", bytes.NewReader(content))
+		req.Header.Set(DigestHeader, digest)
+		Expect(serve(req).Code).To(Equal(http.StatusCreated))
+		Expect(os.ReadFile(bundlePath(storageDir, "test-bundle"))).To(Equal(content))
+	})
+
+	It("should reject uploads without a valid bearer token", func() {
+		token = ""
+		Expect(patch(0, len(content)).Code).To(Equal(http.StatusUnauthorized))
+		token = "invalid"
+		Expect(patch(0, len(content)).Code).To(Equal(http.StatusUnauthorized))
+		Expect(bundlePath(storageDir, "test-bundle")).NotTo(BeAnExistingFile())
+	})
+
+	It("should reject uploads of users that are not allowed to upload the bundle", func() {
+		token = "other-user"
+		rec := patch(0, len(content))
+		Expect(rec.Code).To(Equal(http.StatusForbidden))
+		Expect(rec.Body.String()).To(ContainSubstring(`user "other-user" cannot create resource "bundles/upload"`))
+		Expect(bundlePath(storageDir, "test-bundle")).NotTo(BeAnExistingFile())
+	})
+
+	It("should authorize the users authenticated by a trusted proxy", func() {
+		handler = NewUploadHandler(cl, storageDir, WithAuthorizer(&fakeAuthorizer{allowedBundle: "test-bundle"}), WithProxyAuthConfig(ProxyAuthConfig{UserHeader: "X-Remote-User"}))
+		token = ""
+		req := httptest.NewRequest(http.MethodPut, "/uploads/test-bundle.tgz", bytes.NewReader(content))
+		req.Header.Set("X-Remote-User", "uploader")
+		Expect(serve(req).Code).To(Equal(http.StatusCreated))
+	})
+
+	It("should return the bundle name of partial uploads", func() {
+		bundleName, ok := partialBundleName(partialName("my-bundle", digest))
+		Expect(ok).To(BeTrue())
+		Expect(bundleName).To(Equal("my-bundle"))
+		_, ok = partialBundleName("my-bundle-1234.tmp")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should remove the locks of incomplete uploads once they are unlocked", func() {
+		u := &uploads{storageDir: storageDir}
+		unlock := u.lockPartial("test-bundle", digest)
+		Expect(u.partialLocks).To(HaveLen(1))
+
+		locked := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			u.lockPartial("test-bundle", digest)()
+			close(locked)
+		}()
+		Eventually(func() int {
+			u.mu.Lock()
+			defer u.mu.Unlock()
+			return u.partialLocks[partialName("test-bundle", digest)].refs
+		}).Should(Equal(2))
+
+		unlock()
+		Eventually(locked).Should(BeClosed())
+		Expect(u.partialLocks).To(BeEmpty())
+	})
+
+	It("should not hash stored content again until it changes", func() {
+		u := &uploads{storageDir: storageDir}
+		path := bundlePath(storageDir, "test-bundle")
+		Expect(os.WriteFile(path, content, 0600)).To(Succeed())
+		Expect(u.bundleDigest("test-bundle")).To(Equal(digest))
+
+		// Content with the same size and modification time is not hashed again.
+		info, err := os.Stat(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(path, bytes.ToUpper(content), 0600)).To(Succeed())
+		Expect(os.Chtimes(path, info.ModTime(), info.ModTime())).To(Succeed())
+		Expect(u.bundleDigest("test-bundle")).To(Equal(digest))
+
+		Expect(os.Chtimes(path, info.ModTime().Add(time.Second), info.ModTime().Add(time.Second))).To(Succeed())
+		Expect(u.bundleDigest("test-bundle")).To(Equal(fmt.Sprintf("sha256:%x", sha256.Sum256(bytes.ToUpper(content)))))
+
+		Expect(os.Remove(path)).To(Succeed())
+		_, err = u.bundleDigest("test-bundle")
+		Expect(err).To(HaveOccurred())
+	})
+})
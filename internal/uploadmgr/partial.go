@@ -0,0 +1,210 @@
+package uploadmgr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+
+	"github.com/operator-framework/rukpak/internal/util"
+)
+
+// partialSuffix is the file name suffix of incomplete uploads, which are
+// named after their bundle and the hex-encoded sha256 digest of their
+// complete content, e.g. "my-bundle-<hex>.partial".
+const partialSuffix = ".partial"
+
+func (u *uploads) headPartial(w http.ResponseWriter, r *http.Request) {
+	bundleName := mux.Vars(r)["bundleName"]
+	bundle, ok := u.getUploadBundle(w, r, bundleName)
+	if !ok {
+		return
+	}
+	digest, ok := parseDigestHeader(w, r, true)
+	if !ok {
+		return
+	}
+	if !u.checkContentChange(w, bundle, digest) {
+		return
+	}
+
+	unlock := u.lockPartial(bundleName, digest)
+	defer unlock()
+
+	var offset int64
+	info, err := os.Stat(u.partialPath(bundleName, digest))
+	if err == nil {
+		offset = info.Size()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		http.Error(w, fmt.Sprintf("failed to read partial upload: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(OffsetHeader, strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (u *uploads) patchPartial(w http.ResponseWriter, r *http.Request) {
+	bundleName := mux.Vars(r)["bundleName"]
+	bundle, ok := u.getUploadBundle(w, r, bundleName)
+	if !ok {
+		return
+	}
+	digest, ok := parseDigestHeader(w, r, true)
+	if !ok {
+		return
+	}
+	offset, ok := parseSizeHeader(w, r, OffsetHeader)
+	if !ok {
+		return
+	}
+	length, ok := parseSizeHeader(w, r, LengthHeader)
+	if !ok {
+		return
+	}
+	if length > u.maxUploadSize {
+		http.Error(w, fmt.Sprintf("bundle content size %d exceeds the maximum of %d bytes", length, u.maxUploadSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !u.checkContentChange(w, bundle, digest) {
+		return
+	}
+
+	unlock := u.lockPartial(bundleName, digest)
+	defer unlock()
+
+	dir, err := u.partialDir()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to store bundle data: %v", err), http.StatusInternalServerError)
+		return
+	}
+	path := filepath.Join(dir, partialName(bundleName, digest))
+	partialFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to store bundle data: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer partialFile.Close()
+
+	info, err := partialFile.Stat()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to store bundle data: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if info.Size() != offset {
+		w.Header().Set(OffsetHeader, strconv.FormatInt(info.Size(), 10))
+		http.Error(w, fmt.Sprintf("chunk offset %d does not match upload offset %d", offset, info.Size()), http.StatusConflict)
+		return
+	}
+
+	// Whatever part of the chunk is received is kept, so that an upload that
+	// is interrupted in the middle of a chunk continues from there.
+	n, err := io.Copy(partialFile, http.MaxBytesReader(w, r.Body, length-offset))
+	offset += n
+	w.Header().Set(OffsetHeader, strconv.FormatInt(offset, 10))
+	if err != nil {
+		if maxBytesErr := (*http.MaxBytesError)(nil); errors.As(err, &maxBytesErr) {
+			http.Error(w, fmt.Sprintf("chunk exceeds the upload length of %d bytes", length), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, fmt.Sprintf("read request body: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := partialFile.Close(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to store bundle data: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if offset < length {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// A complete upload that does not match its digest cannot be resumed,
+	// so it is discarded.
+	partialDigest, err := util.FileDigest(path)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read partial upload: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if partialDigest != digest {
+		_ = os.Remove(path)
+		w.Header().Set(OffsetHeader, "0")
+		http.Error(w, fmt.Sprintf("bundle content digest %q does not match expected digest %q", partialDigest, digest), http.StatusBadRequest)
+		return
+	}
+	defer os.Remove(path)
+	u.commit(w, r, bundle, path, digest)
+}
+
+// partialLock is the lock of an incomplete upload, with the number of
+// requests that hold or wait for it.
+type partialLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockPartial locks the incomplete upload of the given bundle and digest,
+// and returns the function that unlocks it. The lock is removed once it is
+// unlocked by the last request that used it, e.g. when the upload was
+// committed or discarded.
+func (u *uploads) lockPartial(bundleName, digest string) func() {
+	name := partialName(bundleName, digest)
+	u.mu.Lock()
+	if u.partialLocks == nil {
+		u.partialLocks = map[string]*partialLock{}
+	}
+	l, ok := u.partialLocks[name]
+	if !ok {
+		l = &partialLock{}
+		u.partialLocks[name] = l
+	}
+	l.refs++
+	u.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		if l.refs--; l.refs == 0 {
+			delete(u.partialLocks, name)
+		}
+	}
+}
+
+func (u *uploads) partialPath(bundleName, digest string) string {
+	return filepath.Join(u.storageDir, partialDir, partialName(bundleName, digest))
+}
+
+func partialName(bundleName, digest string) string {
+	return fmt.Sprintf("%s-%s%s", bundleName, strings.TrimPrefix(digest, "sha256:"), partialSuffix)
+}
+
+// partialBundleName returns the name of the bundle of an incomplete upload
+// with the given file name.
+func partialBundleName(name string) (string, bool) {
+	// The name ends with a dash, 64 hex characters and the suffix.
+	trimmed := strings.TrimSuffix(name, partialSuffix)
+	if trimmed == name || len(trimmed) < 66 {
+		return "", false
+	}
+	return trimmed[:len(trimmed)-65], true
+}
+
+// parseSizeHeader returns the non-negative size in the given header. If the
+// size is missing or invalid, it writes the error to the response and returns
+// false.
+func parseSizeHeader(w http.ResponseWriter, r *http.Request, header string) (int64, bool) {
+	size, err := strconv.ParseInt(r.Header.Get(header), 10, 64)
+	if err != nil || size < 0 {
+		http.Error(w, fmt.Sprintf("invalid %s header %q", header, r.Header.Get(header)), http.StatusBadRequest)
+		return 0, false
+	}
+	return size, true
+}
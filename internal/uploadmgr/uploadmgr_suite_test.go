@@ -0,0 +1,13 @@
+package uploadmgr
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestUploadmgr(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Uploadmgr Suite")
+}
@@ -0,0 +1,96 @@
+package util
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileDigest returns the sha256 digest of the content of the file at path, in
+// the "sha256:<hex>" format of bundle content digests.
+func FileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
+// FileDigests caches the digests of files, so that a file is only hashed
+// again when its size or modification time changes.
+//
+// The cache trusts the size and modification time of files: content that is
+// rewritten in place with the same size and modification time, e.g. within the
+// timestamp granularity of the filesystem or by a process that restores the
+// modification time, keeps the digest of the previous content. It is meant
+// for files that rukpak writes itself, by renaming complete files into place.
+// Callers that must detect content that was changed behind their back verify
+// the content when they read it, as the bundle storage does.
+type FileDigests struct {
+	mu      sync.Mutex
+	digests map[string]fileDigest
+}
+
+type fileDigest struct {
+	size    int64
+	modTime time.Time
+	digest  string
+}
+
+// Get returns the digest of the file at path. If the file does not exist, its
+// digest is forgotten.
+func (d *FileDigests) Get(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		d.Delete(path)
+		return "", err
+	}
+	d.mu.Lock()
+	cached, ok := d.digests[path]
+	d.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.digest, nil
+	}
+
+	digest, err := FileDigest(path)
+	if err != nil {
+		return "", err
+	}
+	d.set(path, info, digest)
+	return digest, nil
+}
+
+// Set records the digest of the content that was just written to the file at
+// path. If the file does not exist, its digest is forgotten.
+func (d *FileDigests) Set(path, digest string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		d.Delete(path)
+		return
+	}
+	d.set(path, info, digest)
+}
+
+func (d *FileDigests) set(path string, info os.FileInfo, digest string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.digests == nil {
+		d.digests = map[string]fileDigest{}
+	}
+	d.digests[path] = fileDigest{size: info.Size(), modTime: info.ModTime(), digest: digest}
+}
+
+// Delete forgets the digest of the file at path.
+func (d *FileDigests) Delete(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.digests, path)
+}
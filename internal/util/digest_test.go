@@ -0,0 +1,70 @@
+package util
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileDigests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bundle.tgz")
+	digestOf := func(content string) string {
+		return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(content)))
+	}
+	get := func(d *FileDigests) string {
+		t.Helper()
+		digest, err := d.Get(path)
+		if err != nil {
+			t.Fatalf("get digest: %v", err)
+		}
+		return digest
+	}
+
+	var d FileDigests
+	if err := os.WriteFile(path, []byte("content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := get(&d), digestOf("content"); got != want {
+		t.Errorf("got digest %q, want %q", got, want)
+	}
+
+	// Content with the same size and modification time is not hashed again.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("CONTENT"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := get(&d), digestOf("content"); got != want {
+		t.Errorf("got digest %q of unchanged file, want cached %q", got, want)
+	}
+
+	if err := os.Chtimes(path, info.ModTime().Add(time.Second), info.ModTime().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := get(&d), digestOf("CONTENT"); got != want {
+		t.Errorf("got digest %q of changed file, want %q", got, want)
+	}
+
+	d.Set(path, "sha256:stored")
+	if got := get(&d); got != "sha256:stored" {
+		t.Errorf("got digest %q, want the digest that was set", got)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(path); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+	if len(d.digests) != 0 {
+		t.Errorf("expected the digest of the missing file to be forgotten, got %v", d.digests)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"io/fs"
 	"os"
+	"time"
 )
 
 // FSToTarGZ writes the filesystem represented by fsys to w as a gzipped tar archive.
@@ -14,6 +15,19 @@ import (
 // of archives produced by this function do not need to account for differences in
 // permissions between source and destination filesystems.
 func FSToTarGZ(w io.Writer, fsys fs.FS) error {
+	return fsToTarGZ(w, fsys, false)
+}
+
+// FSToNormalizedTarGZ writes the filesystem represented by fsys to w as a gzipped
+// tar archive, like FSToTarGZ, but also normalizes the modification times and
+// permissions of the entries in the archive, so that the same content always
+// produces the same archive, regardless of when it was written to fsys. Files
+// remain executable if they were executable by anyone.
+func FSToNormalizedTarGZ(w io.Writer, fsys fs.FS) error {
+	return fsToTarGZ(w, fsys, true)
+}
+
+func fsToTarGZ(w io.Writer, fsys fs.FS, normalize bool) error {
 	gzw := gzip.NewWriter(w)
 	tw := tar.NewWriter(gzw)
 	if err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
@@ -38,6 +52,9 @@ func FSToTarGZ(w io.Writer, fsys fs.FS) error {
 		h.Uname = ""
 		h.Gname = ""
 		h.Name = path
+		if normalize {
+			normalizeTarHeader(h)
+		}
 
 		if err := tw.WriteHeader(h); err != nil {
 			return fmt.Errorf("write tar header for %q: %v", path, err)
@@ -61,3 +78,15 @@ func FSToTarGZ(w io.Writer, fsys fs.FS) error {
 	}
 	return gzw.Close()
 }
+
+func normalizeTarHeader(h *tar.Header) {
+	h.ModTime = time.Unix(0, 0)
+	h.AccessTime = time.Time{}
+	h.ChangeTime = time.Time{}
+	switch {
+	case h.Typeflag == tar.TypeDir, h.Mode&0111 != 0:
+		h.Mode = 0755
+	default:
+		h.Mode = 0644
+	}
+}
@@ -221,6 +221,19 @@ func MapBundleToBundleDeploymentHandler(ctx context.Context, cl client.Client, p
 		return []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(managingBD)}}
 	}
 }
+
+// BundleSources returns the source of the bundle and, if it is a composite
+// source, the sources that it layers.
+func BundleSources(b *rukpakv1alpha1.Bundle) []rukpakv1alpha1.BundleSource {
+	sources := []rukpakv1alpha1.BundleSource{b.Spec.Source}
+	if b.Spec.Source.Composite != nil {
+		for _, layer := range b.Spec.Source.Composite.Sources {
+			sources = append(sources, layer.BundleSource())
+		}
+	}
+	return sources
+}
+
 func MapConfigMapToBundles(ctx context.Context, cl client.Client, cmNamespace string, cm corev1.ConfigMap) []*rukpakv1alpha1.Bundle {
 	bundleList := &rukpakv1alpha1.BundleList{}
 	if err := cl.List(ctx, bundleList); err != nil {
@@ -229,10 +242,12 @@ func MapConfigMapToBundles(ctx context.Context, cl client.Client, cmNamespace st
 	var bs []*rukpakv1alpha1.Bundle
 	for _, b := range bundleList.Items {
 		b := b
-		for _, cmSource := range b.Spec.Source.ConfigMaps {
-			cmName := cmSource.ConfigMap.Name
-			if cm.Name == cmName && cm.Namespace == cmNamespace {
-				bs = append(bs, &b)
+		for _, source := range BundleSources(&b) {
+			for _, cmSource := range source.ConfigMaps {
+				cmName := cmSource.ConfigMap.Name
+				if cm.Name == cmName && cm.Namespace == cmNamespace {
+					bs = append(bs, &b)
+				}
 			}
 		}
 	}
@@ -253,6 +268,68 @@ func MapConfigMapToBundlesHandler(ctx context.Context, cl client.Client, configM
 	})
 }
 
+func MapSecretToBundles(ctx context.Context, cl client.Client, secretNamespace string, secret corev1.Secret) []*rukpakv1alpha1.Bundle {
+	bundleList := &rukpakv1alpha1.BundleList{}
+	if err := cl.List(ctx, bundleList); err != nil {
+		return nil
+	}
+	var bs []*rukpakv1alpha1.Bundle
+	for _, b := range bundleList.Items {
+		b := b
+		for _, source := range BundleSources(&b) {
+			for _, secretSource := range source.Secrets {
+				if secret.Name == secretSource.Secret.Name && secret.Namespace == secretNamespace {
+					bs = append(bs, &b)
+				}
+			}
+		}
+	}
+	return bs
+}
+func MapSecretToBundlesHandler(ctx context.Context, cl client.Client, secretNamespace string, provisionerClassName string) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
+		secret := object.(*corev1.Secret)
+		var requests []reconcile.Request
+		matchingBundles := MapSecretToBundles(ctx, cl, secretNamespace, *secret)
+		for _, b := range matchingBundles {
+			if b.Spec.ProvisionerClassName != provisionerClassName {
+				continue
+			}
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(b)})
+		}
+		return requests
+	})
+}
+
+// MapUnpackPodTemplateToBundlesHandler maps changes of the unpack pod template
+// configmap to the bundles of the provisioner that have image sources and are
+// not unpacked yet, so that their unpack pods are updated with the template.
+func MapUnpackPodTemplateToBundlesHandler(ctx context.Context, cl client.Client, configMapNamespace, configMapName string, provisionerClassName string) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
+		if object.GetNamespace() != configMapNamespace || object.GetName() != configMapName {
+			return nil
+		}
+		bundleList := &rukpakv1alpha1.BundleList{}
+		if err := cl.List(ctx, bundleList); err != nil {
+			return nil
+		}
+		var requests []reconcile.Request
+		for _, b := range bundleList.Items {
+			b := b
+			if b.Spec.ProvisionerClassName != provisionerClassName || b.Status.Phase == rukpakv1alpha1.PhaseUnpacked {
+				continue
+			}
+			for _, source := range BundleSources(&b) {
+				if source.Type == rukpakv1alpha1.SourceTypeImage {
+					requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&b)})
+					break
+				}
+			}
+		}
+		return requests
+	})
+}
+
 // GetBundlesForBundleDeploymentSelector is responsible for returning a list of
 // Bundle resource that exist on cluster that match the label selector specified
 // in the BD parameter's spec.Selector field.
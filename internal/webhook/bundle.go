@@ -23,6 +23,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/Masterminds/semver/v3"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -38,9 +39,15 @@ import (
 type Bundle struct {
 	Client          client.Client
 	SystemNamespace string
+
+	// APIReader reads the secrets that bundles reference directly from the
+	// API server, so that the webhook does not cache every secret of the
+	// system namespace and only needs to get them.
+	APIReader client.Reader
 }
 
 //+kubebuilder:rbac:groups=core,resources=configmaps,verbs=list;watch
+//+kubebuilder:rbac:groups=core,namespace=system,resources=secrets,verbs=get
 //+kubebuilder:webhook:path=/validate-core-rukpak-io-v1alpha1-bundle,mutating=false,failurePolicy=fail,sideEffects=None,groups=core.rukpak.io,resources=bundles,verbs=create;update,versions=v1alpha1,name=vbundles.core.rukpak.io,admissionReviewVersions=v1
 
 // ValidateCreate implements webhook.Validator so a webhook will be registered for the type
@@ -78,6 +85,38 @@ func (b *Bundle) checkBundleSource(ctx context.Context, bundle *rukpakv1alpha1.B
 		if strings.HasPrefix(filepath.Clean(bundle.Spec.Source.Git.Directory), "../") {
 			return fmt.Errorf(`bundle.spec.source.git.directory begins with "../": directory must define path within the repository`)
 		}
+		if bundle.Spec.Source.Git.SparseCheckout && bundle.Spec.Source.Git.Directory == "" {
+			return fmt.Errorf("bundle.spec.source.git.directory must be set when bundle.spec.source.git.sparseCheckout is set")
+		}
+		if semverConstraint := bundle.Spec.Source.Git.Ref.Semver; semverConstraint != "" {
+			if _, err := semver.NewConstraint(semverConstraint); err != nil {
+				return fmt.Errorf("bundle.spec.source.git.ref.semver is invalid: %v", err)
+			}
+		}
+		if err := checkAuthorization("bundle.spec.source.git.auth", bundle.Spec.Source.Git.Auth, strings.HasPrefix(bundle.Spec.Source.Git.Repository, "http")); err != nil {
+			return err
+		}
+		if verify := bundle.Spec.Source.Git.Verify; verify != nil && (verify.PublicKeys.ConfigMap == nil) == (verify.PublicKeys.Secret == nil) {
+			return fmt.Errorf("exactly one of bundle.spec.source.git.verify.publicKeys.configMap or bundle.spec.source.git.verify.publicKeys.secret must be set")
+		}
+	case rukpakv1alpha1.SourceTypeHTTP:
+		if bundle.Spec.Source.HTTP == nil {
+			return fmt.Errorf("bundle.spec.source.http must be set for source type \"http\"")
+		}
+		if err := checkAuthorization("bundle.spec.source.http.auth", bundle.Spec.Source.HTTP.Auth, true); err != nil {
+			return err
+		}
+	case rukpakv1alpha1.SourceTypeS3:
+		if bundle.Spec.Source.S3 == nil {
+			return fmt.Errorf("bundle.spec.source.s3 must be set for source type \"s3\"")
+		}
+	case rukpakv1alpha1.SourceTypeHelmRepository:
+		if bundle.Spec.Source.HelmRepository == nil {
+			return fmt.Errorf("bundle.spec.source.helmRepository must be set for source type \"helmRepository\"")
+		}
+		if err := checkAuthorization("bundle.spec.source.helmRepository.auth", bundle.Spec.Source.HelmRepository.Auth, true); err != nil {
+			return err
+		}
 	case rukpakv1alpha1.SourceTypeConfigMaps:
 		if len(bundle.Spec.Source.ConfigMaps) == 0 {
 			return fmt.Errorf(`bundle.spec.source.configmaps must be set for source type "configmaps"`)
@@ -94,6 +133,95 @@ func (b *Bundle) checkBundleSource(ctx context.Context, bundle *rukpakv1alpha1.B
 		if len(errs) > 0 {
 			return utilerrors.NewAggregate(errs)
 		}
+	case rukpakv1alpha1.SourceTypeSecrets:
+		if len(bundle.Spec.Source.Secrets) == 0 {
+			return fmt.Errorf(`bundle.spec.source.secrets must be set for source type "secrets"`)
+		}
+		errs := []error{}
+		for i, secretSource := range bundle.Spec.Source.Secrets {
+			if strings.HasPrefix(filepath.Clean(secretSource.Path), ".."+string(filepath.Separator)) {
+				errs = append(errs, fmt.Errorf("bundle.spec.source.secrets[%d].path is invalid: %q is outside bundle root", i, secretSource.Path))
+			}
+			if err := b.verifySecretImmutable(ctx, secretSource.Secret.Name); err != nil {
+				errs = append(errs, fmt.Errorf("bundle.spec.source.secrets[%d].secret.name is invalid: %v", i, err))
+			}
+		}
+		if len(errs) > 0 {
+			return utilerrors.NewAggregate(errs)
+		}
+	case rukpakv1alpha1.SourceTypeComposite:
+		if bundle.Spec.Source.Composite == nil || len(bundle.Spec.Source.Composite.Sources) == 0 {
+			return fmt.Errorf(`bundle.spec.source.composite.sources must be set for source type "composite"`)
+		}
+		errs := []error{}
+		imageSources := 0
+		for i, layer := range bundle.Spec.Source.Composite.Sources {
+			if layerPath := filepath.Clean(layer.Path); filepath.IsAbs(layerPath) || layerPath == ".." || strings.HasPrefix(layerPath, ".."+string(filepath.Separator)) {
+				errs = append(errs, fmt.Errorf("bundle.spec.source.composite.sources[%d].path is invalid: %q is outside bundle root", i, layer.Path))
+			}
+			switch layer.Type {
+			case rukpakv1alpha1.SourceTypeComposite, rukpakv1alpha1.SourceTypeUpload:
+				errs = append(errs, fmt.Errorf("bundle.spec.source.composite.sources[%d].type is invalid: source type %q cannot be layered", i, layer.Type))
+				continue
+			case rukpakv1alpha1.SourceTypeImage:
+				imageSources++
+			}
+			layerBundle := bundle.DeepCopy()
+			layerBundle.Spec.Source = layer.BundleSource()
+			if err := b.checkBundleSource(ctx, layerBundle); err != nil {
+				errs = append(errs, fmt.Errorf("bundle.spec.source.composite.sources[%d] is invalid: %v", i, err))
+			}
+		}
+		// Image sources may be unpacked by a pod that is named after the
+		// bundle, so a bundle cannot layer more than one of them.
+		if imageSources > 1 {
+			errs = append(errs, fmt.Errorf(`bundle.spec.source.composite.sources can contain at most one source of type "image"`))
+		}
+		if len(errs) > 0 {
+			return utilerrors.NewAggregate(errs)
+		}
+	case rukpakv1alpha1.SourceTypeUpload:
+	default:
+		// Any other source type is unpacked by an out-of-tree source plugin.
+		if bundle.Spec.Source.Plugin == nil {
+			return fmt.Errorf("bundle.spec.source.plugin must be set for source type %q", typ)
+		}
+	}
+	return checkBundleVerification(bundle)
+}
+
+// checkAuthorization checks that the http-only fields of an authorization are
+// only set for http(s) URLs, and that the basic authentication credentials of
+// its secret are not combined with a bearer token.
+func checkAuthorization(path string, auth rukpakv1alpha1.Authorization, isHTTP bool) error {
+	httpOnly := auth.CertificateAuthority != nil || auth.BearerToken != nil || len(auth.Headers) > 0 || auth.ClientCertificate != nil
+	if httpOnly && !isHTTP {
+		return fmt.Errorf("%s.certificateAuthority, %[1]s.bearerToken, %[1]s.headers and %[1]s.clientCertificate are only supported for http(s) URLs", path)
+	}
+	if isHTTP && auth.BearerToken != nil && auth.Secret.Name != "" {
+		return fmt.Errorf("%s.bearerToken and %[1]s.secret cannot both be set", path)
+	}
+	if ca := auth.CertificateAuthority; ca != nil && (ca.ConfigMap == nil) == (ca.Secret == nil) {
+		return fmt.Errorf("exactly one of %s.certificateAuthority.configMap or %[1]s.certificateAuthority.secret must be set", path)
+	}
+	return nil
+}
+
+func checkBundleVerification(bundle *rukpakv1alpha1.Bundle) error {
+	verification := bundle.Spec.Source.Verification
+	if verification == nil {
+		return nil
+	}
+	switch typ := bundle.Spec.Source.Type; typ {
+	case rukpakv1alpha1.SourceTypeImage, rukpakv1alpha1.SourceTypeHTTP:
+	default:
+		return fmt.Errorf("bundle.spec.source.verification is not supported for source type %q", typ)
+	}
+	if (verification.PublicKeys.ConfigMap == nil) == (verification.PublicKeys.Secret == nil) {
+		return fmt.Errorf("exactly one of bundle.spec.source.verification.publicKeys.configMap or bundle.spec.source.verification.publicKeys.secret must be set")
+	}
+	if verification.SignatureURL != "" && bundle.Spec.Source.Type != rukpakv1alpha1.SourceTypeHTTP {
+		return fmt.Errorf(`bundle.spec.source.verification.signatureURL is only supported for source type "http"`)
 	}
 	return nil
 }
@@ -117,6 +245,18 @@ func (b *Bundle) verifyConfigMapImmutable(ctx context.Context, configMapName str
 	return nil
 }
 
+func (b *Bundle) verifySecretImmutable(ctx context.Context, secretName string) error {
+	var secret corev1.Secret
+	err := b.APIReader.Get(ctx, client.ObjectKey{Namespace: b.SystemNamespace, Name: secretName}, &secret)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if secret.Immutable == nil || !*secret.Immutable {
+		return fmt.Errorf("secret %q is not immutable", secretName)
+	}
+	return nil
+}
+
 func (b *Bundle) SetupWebhookWithManager(mgr ctrl.Manager) error {
 	mgr.GetWebhookServer().Register("/validate-core-rukpak-io-v1alpha1-bundle", admission.WithCustomValidator(&rukpakv1alpha1.Bundle{}, b).WithRecoverPanic(true))
 	return nil
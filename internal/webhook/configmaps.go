@@ -12,6 +12,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+	"github.com/operator-framework/rukpak/internal/util"
 )
 
 //+kubebuilder:rbac:groups=core.rukpak.io,resources=bundles,verbs=list;watch
@@ -37,10 +38,13 @@ func (w *ConfigMap) ValidateCreate(ctx context.Context, obj runtime.Object) erro
 	}
 	bundleReferrers := []string{}
 	for _, bundle := range bundleList.Items {
-		if bundle.Spec.Source.Type == rukpakv1alpha1.SourceTypeConfigMaps {
-			for _, bundleConfigMapRef := range bundle.Spec.Source.ConfigMaps {
-				if bundleConfigMapRef.ConfigMap.Name == cm.Name {
-					bundleReferrers = append(bundleReferrers, bundle.Name)
+		bundle := bundle
+		for _, source := range util.BundleSources(&bundle) {
+			if source.Type == rukpakv1alpha1.SourceTypeConfigMaps {
+				for _, bundleConfigMapRef := range source.ConfigMaps {
+					if bundleConfigMapRef.ConfigMap.Name == cm.Name {
+						bundleReferrers = append(bundleReferrers, bundle.Name)
+					}
 				}
 			}
 		}
@@ -63,9 +67,12 @@ func (w *ConfigMap) ValidateDelete(ctx context.Context, obj runtime.Object) erro
 		return err
 	}
 	for _, b := range bundleList.Items {
-		for _, cmSource := range b.Spec.Source.ConfigMaps {
-			if cmSource.ConfigMap.Name == cm.Name {
-				return fmt.Errorf("configmap %q is in-use by bundle %q", cm.Name, b.Name)
+		b := b
+		for _, source := range util.BundleSources(&b) {
+			for _, cmSource := range source.ConfigMaps {
+				if cmSource.ConfigMap.Name == cm.Name {
+					return fmt.Errorf("configmap %q is in-use by bundle %q", cm.Name, b.Name)
+				}
 			}
 		}
 	}
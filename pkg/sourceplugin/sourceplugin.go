@@ -0,0 +1,188 @@
+// Package sourceplugin defines the protocol between rukpak provisioners and
+// out-of-tree source plugins, which unpack the content of bundles whose source
+// type is not built into the provisioners.
+//
+// A provisioner unpacks a bundle by sending a POST request to the UnpackPath
+// of the endpoint of the plugin that is configured for its source type. The
+// request body is a JSON-encoded UnpackRequest. The plugin responds with a
+// 200 status and a body that starts with a single line containing a
+// JSON-encoded UnpackResponse. If the state of the response is StateUnpacked,
+// the line is followed by the content of the bundle root directory as a
+// gzipped tar archive. Any other status is an error, and its body is the error
+// message. Client error statuses, other than 404, 408 and 429, are permanent
+// errors that provisioners do not retry until the bundle changes. Other
+// statuses are transient errors that provisioners retry with a backoff.
+// NewHandler serves errors wrapped in a PermanentError with a 422 status, and
+// any other error with a 500 status.
+//
+// Like the unpackers built into the provisioners, plugins are called
+// repeatedly for the same bundle until they respond with StateUnpacked, so
+// plugins that unpack asynchronously can respond with StatePending or
+// StateUnpacking until the content is ready. Provisioners call them again
+// after the RequeueAfterSeconds of the response, or after a default interval
+// if it is not set.
+package sourceplugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"time"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+	"github.com/operator-framework/rukpak/internal/util"
+)
+
+// UnpackPath is the path of the endpoint of a plugin that unpacks bundles.
+const UnpackPath = "/unpack"
+
+// State is the state of unpacking the content of a bundle.
+type State string
+
+const (
+	// StatePending conveys that a request for unpacking a bundle has been
+	// acknowledged, but not yet started.
+	StatePending State = "Pending"
+
+	// StateUnpacking conveys that the plugin is currently unpacking a bundle.
+	StateUnpacking State = "Unpacking"
+
+	// StateUnpacked conveys that the bundle has been successfully unpacked,
+	// and that the response contains its content.
+	StateUnpacked State = "Unpacked"
+)
+
+// UnpackRequest is the body of a request to unpack the content of a bundle.
+type UnpackRequest struct {
+	// Bundle is the bundle to unpack. The parameters of its source are in
+	// its spec.source.plugin.parameters.
+	Bundle *rukpakv1alpha1.Bundle `json:"bundle"`
+}
+
+// UnpackResponse is the first line of the body of a response to an
+// UnpackRequest.
+type UnpackResponse struct {
+	// State is the state of unpacking the bundle.
+	State State `json:"state"`
+
+	// Message is contextual information about the progress of unpacking
+	// the bundle.
+	Message string `json:"message,omitempty"`
+
+	// ResolvedSource is a reproducible view of the source of the bundle,
+	// e.g. with a version or digest that pins its content. If not set, the
+	// source of the bundle is used as is.
+	ResolvedSource *rukpakv1alpha1.BundleSource `json:"resolvedSource,omitempty"`
+
+	// RequeueAfterSeconds is how long the provisioner waits before it calls
+	// the plugin again for a bundle that is not unpacked yet. If zero, the
+	// provisioner uses its default interval.
+	RequeueAfterSeconds int64 `json:"requeueAfterSeconds,omitempty"`
+}
+
+// Result is the result of unpacking the content of a bundle by a plugin.
+type Result struct {
+	// Bundle contains the full filesystem of a bundle's root directory. It
+	// is only used when State is StateUnpacked.
+	Bundle fs.FS
+
+	// ResolvedSource is a reproducible view of the source of the bundle.
+	ResolvedSource *rukpakv1alpha1.BundleSource
+
+	// State is the current state of unpacking the bundle content.
+	State State
+
+	// Message is contextual information about the progress of unpacking
+	// the bundle content.
+	Message string
+
+	// RequeueAfter is how long the provisioner waits before it calls the
+	// plugin again if State is not StateUnpacked. It is rounded up to whole
+	// seconds. If zero, the provisioner uses its default interval.
+	RequeueAfter time.Duration
+}
+
+// Unpacker unpacks the content of bundles. It mirrors the interface of the
+// unpackers built into the provisioners.
+type Unpacker interface {
+	Unpack(context.Context, *rukpakv1alpha1.Bundle) (*Result, error)
+}
+
+// PermanentError is an error of an Unpacker that retrying cannot fix, e.g.
+// invalid source parameters or content that is not a valid bundle. Other
+// errors are retried by the provisioners.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// NewHandler returns an http.Handler that serves the plugin protocol at
+// UnpackPath by unpacking bundles with the given unpacker.
+func NewHandler(unpacker Unpacker) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(UnpackPath, &handler{unpacker: unpacker})
+	return mux
+}
+
+type handler struct {
+	unpacker Unpacker
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	var req UnpackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("decode unpack request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Bundle == nil {
+		http.Error(w, "unpack request bundle is unset", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.unpacker.Unpack(r.Context(), req.Bundle)
+	if err != nil {
+		status := http.StatusInternalServerError
+		var permanentErr *PermanentError
+		if errors.As(err, &permanentErr) {
+			status = http.StatusUnprocessableEntity
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	// json.Encoder terminates the response line with a newline.
+	if err := json.NewEncoder(w).Encode(UnpackResponse{
+		State:          result.State,
+		Message:        result.Message,
+		ResolvedSource: result.ResolvedSource,
+		// Round up, so that a short interval does not become the default.
+		RequeueAfterSeconds: int64((result.RequeueAfter + time.Second - 1) / time.Second),
+	}); err != nil {
+		return
+	}
+	if result.State != StateUnpacked {
+		return
+	}
+	if err := util.FSToTarGZ(w, result.Bundle); err != nil {
+		// The status has already been sent, so abort the response to make
+		// sure that the provisioner does not read a truncated archive as
+		// if it was complete.
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,316 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/operator-framework/rukpak/internal/util"
+)
+
+var (
+	_ Storage      = &ContentAddressableDirectory{}
+	_ Checker      = &ContentAddressableDirectory{}
+	_ DigestStorer = &ContentAddressableDirectory{}
+)
+
+const (
+	casBlobsDir = "blobs"
+	casRefsDir  = "refs"
+	casTmpDir   = "tmp"
+)
+
+var casDigestRegexp = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// ContentAddressableDirectory is a Storage that stores bundle content in a
+// local directory by digest, so that bundles with the same content share a
+// single copy of it. Each bundle references the digest of its content, and
+// content that is no longer referenced by any bundle is garbage collected.
+//
+// The URL of the content of a bundle is the URL of its digest, so bundles
+// with the same content have the same URL, and the URL of a bundle only
+// changes if its content changes.
+type ContentAddressableDirectory struct {
+	RootDirectory string
+	URL           url.URL
+
+	// mu serializes the changes of the references with the removal of the
+	// content that they no longer reference.
+	mu sync.Mutex
+
+	// refCounts are the numbers of bundles that reference the content of
+	// each digest. They are counted when the storage is first changed, and
+	// then updated whenever a reference changes, so that content is removed
+	// once it is no longer referenced without reading all references.
+	refCounts map[string]int
+}
+
+func (s *ContentAddressableDirectory) Load(_ context.Context, owner client.Object) (fs.FS, error) {
+	digest, err := s.ref(owner.GetName())
+	if err != nil {
+		return nil, err
+	}
+	if expected := expectedDigest(owner); expected != "" && expected != digest {
+		return nil, &DigestMismatchError{Expected: expected, Actual: digest}
+	}
+	blobFile, err := os.Open(s.blobPath(digest))
+	if err != nil {
+		return nil, err
+	}
+	// Content is verified against the digest that it is stored by, so that
+	// corrupted content is removed and stored again when its bundle is
+	// unpacked again, rather than reused.
+	if err := verifyFile(blobFile, digest); err != nil {
+		blobFile.Close()
+		var mismatchErr *DigestMismatchError
+		if errors.As(err, &mismatchErr) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			_ = os.Remove(s.blobPath(digest))
+		}
+		return nil, err
+	}
+	return newTarGZFS(blobFile, filepath.Join(s.RootDirectory, casTmpDir)), nil
+}
+
+// Check checks that the owner references the digest that its content was
+// stored with, and that the content of that digest exists.
+func (s *ContentAddressableDirectory) Check(_ context.Context, owner client.Object) error {
+	digest, err := s.ref(owner.GetName())
+	if err != nil {
+		return err
+	}
+	if expected := expectedDigest(owner); expected != "" && expected != digest {
+		return &DigestMismatchError{Expected: expected, Actual: digest}
+	}
+	_, err = os.Stat(s.blobPath(digest))
+	return err
+}
+
+func (s *ContentAddressableDirectory) Store(ctx context.Context, owner client.Object, bundle fs.FS) error {
+	_, err := s.StoreWithDigest(ctx, owner, bundle)
+	return err
+}
+
+func (s *ContentAddressableDirectory) StoreWithDigest(ctx context.Context, owner client.Object, bundle fs.FS) (string, error) {
+	tmpDir := filepath.Join(s.RootDirectory, casTmpDir)
+	if err := os.MkdirAll(tmpDir, 0700); err != nil {
+		return "", err
+	}
+	tmpFile, err := os.CreateTemp(tmpDir, "blob-*.tgz")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	// The archive is normalized, so that the same content has the same
+	// digest even if it was written at different times, e.g. when it is
+	// checked out of a git repository again.
+	hasher := newDigester()
+	if err := util.FSToNormalizedTarGZ(io.MultiWriter(tmpFile, hasher), bundle); err != nil {
+		return "", fmt.Errorf("convert bundle %q to tar.gz: %v", owner.GetName(), err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return "", err
+	}
+	digest := formatDigest(hasher)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.countRefs(ctx); err != nil {
+		return "", err
+	}
+
+	blobPath := s.blobPath(digest)
+	if _, err := os.Stat(blobPath); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(blobPath), 0700); err != nil {
+			return "", err
+		}
+		if err := os.Rename(tmpFile.Name(), blobPath); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	}
+	// References that cannot be read were not counted.
+	previous, _ := s.ref(owner.GetName())
+	if err := s.writeRef(owner.GetName(), digest); err != nil {
+		return "", err
+	}
+	if previous != digest {
+		s.refCounts[digest]++
+		if previous != "" {
+			if err := s.unref(previous); err != nil {
+				return "", err
+			}
+		}
+	}
+	return digest, nil
+}
+
+func (s *ContentAddressableDirectory) Delete(ctx context.Context, owner client.Object) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.countRefs(ctx); err != nil {
+		return err
+	}
+
+	previous, refErr := s.ref(owner.GetName())
+	if err := ignoreNotExist(os.Remove(s.refPath(owner.GetName()))); err != nil {
+		return err
+	}
+	if refErr != nil {
+		return nil
+	}
+	return s.unref(previous)
+}
+
+func (s *ContentAddressableDirectory) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	fsys := &util.FilesOnlyFilesystem{FS: os.DirFS(filepath.Join(s.RootDirectory, casBlobsDir))}
+	http.StripPrefix(s.URL.Path, http.FileServer(http.FS(fsys))).ServeHTTP(resp, req)
+}
+
+func (s *ContentAddressableDirectory) URLFor(_ context.Context, owner client.Object) (string, error) {
+	digest, err := s.ref(owner.GetName())
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%s", s.URL.String(), casBlobFile(digest)), nil
+}
+
+// GarbageCollect counts the references of the content again, and removes
+// the content that is not referenced by any bundle, e.g. because the
+// provisioner stopped while it was stored. Content is also removed whenever
+// the last bundle that references it is stored again or deleted.
+func (s *ContentAddressableDirectory) GarbageCollect(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.collectGarbage(ctx)
+}
+
+// countRefs counts the references of the content, and removes the content
+// that is not referenced, unless they were already counted. It must be
+// called with mu held.
+func (s *ContentAddressableDirectory) countRefs(ctx context.Context) error {
+	if s.refCounts != nil {
+		return nil
+	}
+	return s.collectGarbage(ctx)
+}
+
+// unref removes a reference to the content with the given digest, and
+// removes the content once it is no longer referenced. It must be called
+// with mu held.
+func (s *ContentAddressableDirectory) unref(digest string) error {
+	s.refCounts[digest]--
+	if s.refCounts[digest] > 0 {
+		return nil
+	}
+	delete(s.refCounts, digest)
+	return ignoreNotExist(os.Remove(s.blobPath(digest)))
+}
+
+// collectGarbage counts the references of the content and removes the
+// content that is not referenced by any bundle. References that cannot be
+// read are skipped, because their bundles cannot be loaded until they are
+// stored again anyway. It must be called with mu held.
+func (s *ContentAddressableDirectory) collectGarbage(ctx context.Context) error {
+	refEntries, err := os.ReadDir(filepath.Join(s.RootDirectory, casRefsDir))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	refCounts := map[string]int{}
+	referenced := map[string]struct{}{}
+	for _, e := range refEntries {
+		digest, err := s.ref(e.Name())
+		if err != nil {
+			log.FromContext(ctx).Error(err, "skipping unreadable content reference", "bundle", e.Name())
+			continue
+		}
+		refCounts[digest]++
+		referenced[casBlobFile(digest)] = struct{}{}
+	}
+	s.refCounts = refCounts
+
+	blobsDir := filepath.Join(s.RootDirectory, casBlobsDir)
+	return ignoreNotExist(filepath.WalkDir(blobsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(blobsDir, path)
+		if err != nil {
+			return err
+		}
+		if _, ok := referenced[filepath.ToSlash(rel)]; ok {
+			return nil
+		}
+		return ignoreNotExist(os.Remove(path))
+	}))
+}
+
+// ref returns the digest of the content that the named bundle references.
+func (s *ContentAddressableDirectory) ref(bundleName string) (string, error) {
+	data, err := os.ReadFile(s.refPath(bundleName))
+	if err != nil {
+		return "", err
+	}
+	digest := strings.TrimSpace(string(data))
+	if !casDigestRegexp.MatchString(digest) {
+		return "", fmt.Errorf("invalid content reference %q of bundle %q", digest, bundleName)
+	}
+	return digest, nil
+}
+
+// writeRef atomically replaces the digest of the content that the named
+// bundle references.
+func (s *ContentAddressableDirectory) writeRef(bundleName, digest string) error {
+	refsDir := filepath.Join(s.RootDirectory, casRefsDir)
+	if err := os.MkdirAll(refsDir, 0700); err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Join(s.RootDirectory, casTmpDir), "ref-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+	if _, err := tmpFile.WriteString(digest); err != nil {
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), s.refPath(bundleName))
+}
+
+func (s *ContentAddressableDirectory) refPath(bundleName string) string {
+	return filepath.Join(s.RootDirectory, casRefsDir, bundleName)
+}
+
+func (s *ContentAddressableDirectory) blobPath(digest string) string {
+	return filepath.Join(s.RootDirectory, casBlobsDir, filepath.FromSlash(casBlobFile(digest)))
+}
+
+// casBlobFile returns the path of the content with the given digest relative
+// to the blobs directory, which is also its path relative to the URL of the
+// storage, e.g. "sha256/<hex>.tgz".
+func casBlobFile(digest string) string {
+	algorithm, hex, _ := strings.Cut(digest, ":")
+	return fmt.Sprintf("%s/%s.tgz", algorithm, hex)
+}
@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing/fstest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+var _ = Describe("ContentAddressableDirectory", func() {
+	var (
+		ctx    context.Context
+		owner  *rukpakv1alpha1.Bundle
+		other  *rukpakv1alpha1.Bundle
+		store  *ContentAddressableDirectory
+		testFS fs.FS
+	)
+
+	blobs := func() []string {
+		var paths []string
+		Expect(filepath.WalkDir(filepath.Join(store.RootDirectory, casBlobsDir), func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			paths = append(paths, path)
+			return nil
+		})).To(Succeed())
+		return paths
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		owner = &rukpakv1alpha1.Bundle{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("test-bundle-%s", rand.String(5))}}
+		other = &rukpakv1alpha1.Bundle{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("test-bundle-%s", rand.String(5))}}
+		storageURL, err := url.Parse("https://core.rukpak-system.svc/bundles/")
+		Expect(err).NotTo(HaveOccurred())
+		store = &ContentAddressableDirectory{RootDirectory: GinkgoT().TempDir(), URL: *storageURL}
+		testFS = generateFS()
+	})
+
+	It("should fail to load a bundle that is not stored", func() {
+		_, err := store.Load(ctx, owner)
+		Expect(errors.Is(err, os.ErrNotExist)).To(BeTrue())
+	})
+
+	It("should store bundles with the same content once", func() {
+		Expect(store.Store(ctx, owner, testFS)).To(Succeed())
+		Expect(store.Store(ctx, other, testFS)).To(Succeed())
+		Expect(blobs()).To(HaveLen(1))
+
+		for _, bundle := range []*rukpakv1alpha1.Bundle{owner, other} {
+			loadedFS, err := store.Load(ctx, bundle)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fsEqual(testFS, loadedFS)).To(BeTrue())
+		}
+
+		ownerURL, err := store.URLFor(ctx, owner)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ownerURL).To(MatchRegexp(`^https://core\.rukpak-system\.svc/bundles/sha256/[0-9a-f]{64}\.tgz$`))
+		Expect(store.URLFor(ctx, other)).To(Equal(ownerURL))
+	})
+
+	It("should store the same content once even if it was written at different times", func() {
+		checkout := func(modTime time.Time) fs.FS {
+			// Files that are checked out of git repositories have the time
+			// of the checkout as their modification time.
+			return fstest.MapFS{
+				"manifests/configmap.yaml": &fstest.MapFile{Data: []byte("kind: ConfigMap\n"), Mode: 0600, ModTime: modTime},
+				"manifests/script.sh":      &fstest.MapFile{Data: []byte("#!/bin/sh\n"), Mode: 0700, ModTime: modTime},
+			}
+		}
+		ownerDigest, err := store.StoreWithDigest(ctx, owner, checkout(time.Now()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.StoreWithDigest(ctx, other, checkout(time.Now().Add(time.Hour)))).To(Equal(ownerDigest))
+		Expect(blobs()).To(HaveLen(1))
+
+		loadedFS, err := store.Load(ctx, owner)
+		Expect(err).NotTo(HaveOccurred())
+		info, err := fs.Stat(loadedFS, "manifests/script.sh")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(fs.FileMode(0755)))
+	})
+
+	It("should check that a bundle references its content and that the content exists", func() {
+		Expect(errors.Is(store.Check(ctx, owner), os.ErrNotExist)).To(BeTrue())
+
+		digest, err := store.StoreWithDigest(ctx, owner, testFS)
+		Expect(err).NotTo(HaveOccurred())
+		owner.Status.ContentDigest = digest
+		Expect(store.Check(ctx, owner)).To(Succeed())
+
+		Expect(os.Remove(store.blobPath(digest))).To(Succeed())
+		Expect(errors.Is(store.Check(ctx, owner), os.ErrNotExist)).To(BeTrue())
+	})
+
+	It("should garbage collect content that is no longer referenced", func() {
+		Expect(store.Store(ctx, owner, testFS)).To(Succeed())
+		Expect(store.Store(ctx, other, testFS)).To(Succeed())
+
+		Expect(store.Delete(ctx, owner)).To(Succeed())
+		Expect(blobs()).To(HaveLen(1))
+		Expect(store.Delete(ctx, other)).To(Succeed())
+		Expect(blobs()).To(BeEmpty())
+	})
+
+	It("should garbage collect the previous content of a bundle that is stored again", func() {
+		Expect(store.Store(ctx, owner, testFS)).To(Succeed())
+		oldURL, err := store.URLFor(ctx, owner)
+		Expect(err).NotTo(HaveOccurred())
+
+		newFS := generateFS()
+		Expect(store.Store(ctx, owner, newFS)).To(Succeed())
+		Expect(blobs()).To(HaveLen(1))
+		Expect(store.URLFor(ctx, owner)).NotTo(Equal(oldURL))
+		loadedFS, err := store.Load(ctx, owner)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fsEqual(newFS, loadedFS)).To(BeTrue())
+	})
+
+	It("should skip unreadable references rather than fail to store bundles", func() {
+		Expect(os.MkdirAll(filepath.Join(store.RootDirectory, casRefsDir), 0700)).To(Succeed())
+		Expect(os.WriteFile(store.refPath(other.GetName()), []byte("not a digest"), 0600)).To(Succeed())
+
+		Expect(store.Store(ctx, owner, testFS)).To(Succeed())
+		Expect(store.GarbageCollect(ctx)).To(Succeed())
+		Expect(blobs()).To(HaveLen(1))
+
+		Expect(store.Store(ctx, other, testFS)).To(Succeed())
+		Expect(store.Delete(ctx, owner)).To(Succeed())
+		Expect(blobs()).To(HaveLen(1))
+	})
+
+	It("should remove unreferenced content when it is garbage collected", func() {
+		Expect(store.Store(ctx, owner, testFS)).To(Succeed())
+		Expect(os.Remove(store.refPath(owner.GetName()))).To(Succeed())
+		Expect(blobs()).To(HaveLen(1))
+
+		Expect(store.GarbageCollect(ctx)).To(Succeed())
+		Expect(blobs()).To(BeEmpty())
+	})
+
+	It("should serve content by digest", func() {
+		Expect(store.Store(ctx, owner, testFS)).To(Succeed())
+		contentURL, err := store.URLFor(ctx, owner)
+		Expect(err).NotTo(HaveOccurred())
+
+		rec := httptest.NewRecorder()
+		store.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, contentURL, nil))
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.Bytes()).To(Equal(mustReadFile(blobs()[0])))
+	})
+
+	It("should remove corrupted content, so that it is stored again", func() {
+		digest, err := store.StoreWithDigest(ctx, owner, testFS)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.URLFor(ctx, owner)).To(HaveSuffix(casBlobFile(digest)))
+		owner.Status.ContentDigest = digest
+		Expect(os.WriteFile(blobs()[0], []byte("corrupted"), 0600)).To(Succeed())
+
+		_, err = store.Load(ctx, owner)
+		var mismatchErr *DigestMismatchError
+		Expect(errors.As(err, &mismatchErr)).To(BeTrue())
+		Expect(blobs()).To(BeEmpty())
+
+		Expect(store.StoreWithDigest(ctx, owner, testFS)).To(Equal(digest))
+		loadedFS, err := store.Load(ctx, owner)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fsEqual(testFS, loadedFS)).To(BeTrue())
+	})
+
+	It("should load content from another storage with the fallback loader", func() {
+		Expect(store.Store(ctx, owner, testFS)).To(Succeed())
+		server := httptest.NewServer(store)
+		defer server.Close()
+		serverURL, err := url.Parse(server.URL + "/bundles/")
+		Expect(err).NotTo(HaveOccurred())
+		store.URL = *serverURL
+		owner.Status.ContentURL, err = store.URLFor(ctx, owner)
+		Expect(err).NotTo(HaveOccurred())
+
+		emptyStore := &ContentAddressableDirectory{RootDirectory: GinkgoT().TempDir()}
+		loadedFS, err := WithFallbackLoader(emptyStore, NewHTTP()).Load(ctx, owner)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fsEqual(testFS, loadedFS)).To(BeTrue())
+	})
+})
+
+func mustReadFile(path string) []byte {
+	data, err := os.ReadFile(path)
+	Expect(err).NotTo(HaveOccurred())
+	return data
+}
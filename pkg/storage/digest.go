@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+// DigestMismatchError is the error of loading bundle content that does not
+// match the digest that it was stored with, e.g. because it was truncated or
+// tampered with.
+type DigestMismatchError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("bundle content digest %q does not match expected digest %q", e.Actual, e.Expected)
+}
+
+// expectedDigest returns the digest that the content of the owner was stored
+// with, or an empty string if it is not known, in which case the content is
+// not verified.
+func expectedDigest(owner client.Object) string {
+	if bundle, ok := owner.(*rukpakv1alpha1.Bundle); ok {
+		return bundle.Status.ContentDigest
+	}
+	return ""
+}
+
+// newDigester returns the hash that the digests of bundle contents are
+// computed with.
+func newDigester() hash.Hash {
+	return sha256.New()
+}
+
+func formatDigest(h hash.Hash) string {
+	return fmt.Sprintf("sha256:%x", h.Sum(nil))
+}
+
+func verifyDigest(expected string, h hash.Hash) error {
+	if actual := formatDigest(h); actual != expected {
+		return &DigestMismatchError{Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// verifyFile verifies the content of the file against the expected digest,
+// unless it is empty, and rewinds the file.
+func verifyFile(f *os.File, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	h := newDigester()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if err := verifyDigest(expected, h); err != nil {
+		return err
+	}
+	_, err := f.Seek(0, io.SeekStart)
+	return err
+}
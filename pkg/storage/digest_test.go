@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"testing/fstest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+)
+
+var _ = Describe("Content digests", func() {
+	var (
+		ctx    context.Context
+		owner  *rukpakv1alpha1.Bundle
+		store  *LocalDirectory
+		testFS fs.FS
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		owner = &rukpakv1alpha1.Bundle{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("test-bundle-%s", rand.String(5))}}
+		store = &LocalDirectory{RootDirectory: GinkgoT().TempDir()}
+		testFS = generateFS()
+
+		digest, err := store.StoreWithDigest(ctx, owner, testFS)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(digest).To(Equal(fmt.Sprintf("sha256:%x", sha256.Sum256(mustReadFile(store.bundlePath(owner.GetName()))))))
+		owner.Status.ContentDigest = digest
+	})
+
+	It("should load content that matches its digest", func() {
+		loadedFS, err := store.Load(ctx, owner)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fsEqual(testFS, loadedFS)).To(BeTrue())
+	})
+
+	It("should fail to load truncated content", func() {
+		data := mustReadFile(store.bundlePath(owner.GetName()))
+		Expect(os.WriteFile(store.bundlePath(owner.GetName()), data[:len(data)/2], 0600)).To(Succeed())
+
+		_, err := store.Load(ctx, owner)
+		var mismatchErr *DigestMismatchError
+		Expect(errors.As(err, &mismatchErr)).To(BeTrue())
+		Expect(mismatchErr.Expected).To(Equal(owner.Status.ContentDigest))
+	})
+
+	It("should fail to load tampered content from the HTTP loader", func() {
+		server := newTLSServer(store, "abc123")
+		DeferCleanup(server.Close)
+		contentURL, err := store.URLFor(ctx, owner)
+		Expect(err).NotTo(HaveOccurred())
+		owner.Status.ContentURL = contentURL
+
+		Expect(store.Store(ctx, owner, generateFS())).To(Succeed())
+
+		_, err = NewHTTP(WithInsecureSkipVerify(true), WithBearerToken("abc123")).Load(ctx, owner)
+		var mismatchErr *DigestMismatchError
+		Expect(errors.As(err, &mismatchErr)).To(BeTrue())
+
+		_, err = WithFallbackLoader(&LocalDirectory{RootDirectory: GinkgoT().TempDir()}, NewHTTP(WithInsecureSkipVerify(true), WithBearerToken("abc123"))).Load(ctx, owner)
+		Expect(errors.As(err, &mismatchErr)).To(BeTrue())
+	})
+
+	It("should check that content is stored and matches its digest", func() {
+		Expect(store.Check(ctx, owner)).To(Succeed())
+
+		Expect(os.WriteFile(store.bundlePath(owner.GetName()), []byte("replaced"), 0600)).To(Succeed())
+		err := store.Check(ctx, owner)
+		var mismatchErr *DigestMismatchError
+		Expect(errors.As(err, &mismatchErr)).To(BeTrue())
+
+		Expect(store.Delete(ctx, owner)).To(Succeed())
+		Expect(errors.Is(store.Check(ctx, owner), fs.ErrNotExist)).To(BeTrue())
+	})
+
+	It("should compute the same digest for the same content written at different times", func() {
+		for _, file := range *testFS.(*fstest.MapFS) {
+			file.ModTime = file.ModTime.Add(time.Hour)
+		}
+		Expect(store.StoreWithDigest(ctx, owner, testFS)).To(Equal(owner.Status.ContentDigest))
+	})
+
+	It("should not verify content without a digest", func() {
+		newFS := generateFS()
+		Expect(store.Store(ctx, owner, newFS)).To(Succeed())
+
+		owner.Status.ContentDigest = ""
+		loadedFS, err := store.Load(ctx, owner)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fsEqual(newFS, loadedFS)).To(BeTrue())
+	})
+})
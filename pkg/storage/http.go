@@ -1,16 +1,15 @@
 package storage
 
 import (
-	"compress/gzip"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"io/fs"
 	"net/http"
+	"net/url"
 	"time"
 
-	"github.com/nlepage/go-tarfs"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
@@ -74,20 +73,25 @@ func (s *HTTP) Load(ctx context.Context, owner client.Object) (fs.FS, error) {
 	if err != nil {
 		return nil, err
 	}
-	for _, f := range s.requestOpts {
-		f(req)
+	// Presigned URLs carry their own credentials, and S3 rejects requests
+	// that are also authenticated otherwise.
+	if !isPresignedURL(req.URL) {
+		for _, f := range s.requestOpts {
+			f(req)
+		}
 	}
 	resp, err := s.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
 		return nil, fmt.Errorf("unexpected response status %q", resp.Status)
 	}
-	tarReader, err := gzip.NewReader(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	return tarfs.New(tarReader)
+	return loadTarGZ(resp.Body, "", bundle.Status.ContentDigest)
+}
+
+// isPresignedURL returns whether the URL is a presigned S3 URL.
+func isPresignedURL(u *url.URL) bool {
+	return u.Query().Has("X-Amz-Signature")
 }
@@ -1,8 +1,6 @@
 package storage
 
 import (
-	"bytes"
-	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
@@ -13,19 +11,26 @@ import (
 	"os"
 	"path/filepath"
 
-	"github.com/nlepage/go-tarfs"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/operator-framework/rukpak/internal/util"
 )
 
-var _ Storage = &LocalDirectory{}
+var (
+	_ Storage      = &LocalDirectory{}
+	_ Checker      = &LocalDirectory{}
+	_ DigestStorer = &LocalDirectory{}
+)
 
 const DefaultBundleCacheDir = "/var/cache/bundles"
 
 type LocalDirectory struct {
 	RootDirectory string
 	URL           url.URL
+
+	// digests caches the digests of bundle files, so that they are only
+	// hashed when they are checked after they changed.
+	digests util.FileDigests
 }
 
 func (s *LocalDirectory) Load(_ context.Context, owner client.Object) (fs.FS, error) {
@@ -33,33 +38,68 @@ func (s *LocalDirectory) Load(_ context.Context, owner client.Object) (fs.FS, er
 	if err != nil {
 		return nil, err
 	}
-	defer bundleFile.Close()
-	tarReader, err := gzip.NewReader(bundleFile)
-	if err != nil {
+	if err := verifyFile(bundleFile, expectedDigest(owner)); err != nil {
+		bundleFile.Close()
 		return nil, err
 	}
-	return tarfs.New(tarReader)
+	// The open file keeps the content that is loaded now, even if the bundle
+	// is stored again before the FS is used.
+	return newTarGZFS(bundleFile, s.RootDirectory), nil
 }
 
-func (s *LocalDirectory) Store(_ context.Context, owner client.Object, bundle fs.FS) error {
-	buf := &bytes.Buffer{}
-	if err := util.FSToTarGZ(buf, bundle); err != nil {
-		return fmt.Errorf("convert bundle %q to tar.gz: %v", owner.GetName(), err)
-	}
+func (s *LocalDirectory) Store(ctx context.Context, owner client.Object, bundle fs.FS) error {
+	_, err := s.StoreWithDigest(ctx, owner, bundle)
+	return err
+}
 
-	bundleFile, err := os.Create(s.bundlePath(owner.GetName()))
+func (s *LocalDirectory) StoreWithDigest(_ context.Context, owner client.Object, bundle fs.FS) (string, error) {
+	// The content is written to a temporary file that replaces the bundle file
+	// once it is complete, so that the bundle file is never partially written.
+	tmpFile, err := os.CreateTemp(s.RootDirectory, fmt.Sprintf(".%s-*.tmp", owner.GetName()))
 	if err != nil {
-		return err
+		return "", err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	h := newDigester()
+	if err := util.FSToNormalizedTarGZ(io.MultiWriter(tmpFile, h), bundle); err != nil {
+		return "", fmt.Errorf("convert bundle %q to tar.gz: %v", owner.GetName(), err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return "", err
 	}
-	defer bundleFile.Close()
+	bundlePath := s.bundlePath(owner.GetName())
+	if err := os.Rename(tmpFile.Name(), bundlePath); err != nil {
+		return "", err
+	}
+	digest := formatDigest(h)
+	s.digests.Set(bundlePath, digest)
+	return digest, nil
+}
 
-	if _, err := io.Copy(bundleFile, buf); err != nil {
+// Check checks that the bundle file of the owner exists and matches the
+// digest that it was stored with. The digest of the file is only computed
+// again if the file changed since it was stored or last checked.
+func (s *LocalDirectory) Check(_ context.Context, owner client.Object) error {
+	bundlePath := s.bundlePath(owner.GetName())
+	expected := expectedDigest(owner)
+	if expected == "" {
+		_, err := os.Stat(bundlePath)
 		return err
 	}
+	digest, err := s.digests.Get(bundlePath)
+	if err != nil {
+		return err
+	}
+	if digest != expected {
+		return &DigestMismatchError{Expected: expected, Actual: digest}
+	}
 	return nil
 }
 
 func (s *LocalDirectory) Delete(_ context.Context, owner client.Object) error {
+	s.digests.Delete(s.bundlePath(owner.GetName()))
 	return ignoreNotExist(os.Remove(s.bundlePath(owner.GetName())))
 }
 
@@ -69,6 +69,16 @@ var _ = Describe("LocalDirectory", func() {
 			It("should re-store a bundle FS", func() {
 				Expect(store.Store(ctx, owner, testFS)).To(Succeed())
 			})
+			It("should not change bundle FSs that were loaded before", func() {
+				loadedTestFS, err := store.Load(ctx, owner)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(store.Store(ctx, owner, generateFS())).To(Succeed())
+				Expect(fsEqual(testFS, loadedTestFS)).To(BeTrue())
+
+				entries, err := os.ReadDir(store.RootDirectory)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(entries).To(HaveLen(1))
+			})
 		})
 
 		Describe("Load", func() {
@@ -125,14 +135,11 @@ func fsEqual(a, b fs.FS) (bool, error) {
 			if err != nil {
 				return err
 			}
-			info, err := d.Info()
-			if err != nil {
-				return err
-			}
+			// Modification times are not compared, because they are not
+			// preserved by storages that normalize the archives of bundles.
 			m[path] = &fstest.MapFile{
-				Data:    data,
-				Mode:    d.Type(),
-				ModTime: info.ModTime().UTC(),
+				Data: data,
+				Mode: d.Type(),
 			}
 			return nil
 		}
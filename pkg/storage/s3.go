@@ -0,0 +1,293 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/operator-framework/rukpak/internal/util"
+)
+
+var (
+	_ Storage           = &S3{}
+	_ Checker           = &S3{}
+	_ DigestStorer      = &S3{}
+	_ ExpiringURLStorer = &S3{}
+)
+
+// s3DigestMetadata is the user metadata of objects that holds the digest of
+// their content.
+const s3DigestMetadata = "Content-Digest"
+
+// S3Config configures an S3 storage.
+type S3Config struct {
+	// Endpoint is the URL of the S3-compatible object storage service, e.g.
+	// https://s3.amazonaws.com.
+	Endpoint string
+
+	// Region is the region of the bucket. If empty, it is us-east-1.
+	Region string
+
+	// Bucket is the bucket in which bundle contents are stored.
+	Bucket string
+
+	// Prefix is prepended to the object keys of bundle contents, e.g.
+	// "rukpak/core/".
+	Prefix string
+
+	// PresignExpiry is how long the URLs of bundle contents are valid for. If
+	// set, the URLs are presigned URLs of the objects. Otherwise, they are
+	// URLs at which the storage serves the contents itself.
+	PresignExpiry time.Duration
+}
+
+// S3 is a Storage that stores bundle contents as objects in a bucket of an
+// S3-compatible object storage service, so that they are shared by all of the
+// replicas of a provisioner and survive its restarts.
+type S3 struct {
+	Client        *minio.Client
+	Bucket        string
+	Prefix        string
+	PresignExpiry time.Duration
+
+	// URL is the URL at which the storage serves bundle contents. It is only
+	// used if PresignExpiry is not set.
+	URL url.URL
+
+	// presigned caches the presigned URLs of objects, so that the URL of a
+	// bundle does not change every time it is requested, but only when less
+	// than half of its validity remains.
+	presignedMu sync.Mutex
+	presigned   map[string]presignedURL
+}
+
+type presignedURL struct {
+	url       string
+	refreshAt time.Time
+}
+
+// NewS3 returns an S3 storage for the given configuration, which serves
+// bundle contents at the given URL. The credentials of the object storage
+// service are read from the standard AWS and MinIO environment variables or
+// credentials file, or from the IAM role of the provisioner.
+func NewS3(cfg S3Config, serveURL url.URL, transport http.RoundTripper) (*S3, error) {
+	endpointURL, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parse endpoint %q: %v", cfg.Endpoint, err)
+	}
+	if endpointURL.Scheme != "http" && endpointURL.Scheme != "https" {
+		return nil, fmt.Errorf("parse endpoint %q: scheme must be http or https", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("bucket is unset")
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	s3Client, err := minio.New(endpointURL.Host, &minio.Options{
+		Creds: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: transport}},
+		}),
+		Secure:    endpointURL.Scheme == "https",
+		Region:    region,
+		Transport: transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create s3 client for endpoint %q: %v", cfg.Endpoint, err)
+	}
+	return &S3{
+		Client:        s3Client,
+		Bucket:        cfg.Bucket,
+		Prefix:        cfg.Prefix,
+		PresignExpiry: cfg.PresignExpiry,
+		URL:           serveURL,
+	}, nil
+}
+
+func (s *S3) Load(ctx context.Context, owner client.Object) (fs.FS, error) {
+	obj, err := s.Client.GetObject(ctx, s.Bucket, s.objectKey(owner.GetName()), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s.objectError(owner.GetName(), err)
+	}
+	fsys, err := loadTarGZ(obj, "", expectedDigest(owner))
+	if err != nil {
+		var mismatchErr *DigestMismatchError
+		if errors.As(err, &mismatchErr) {
+			return nil, fmt.Errorf("get object %q: %w", s.objectKey(owner.GetName()), err)
+		}
+		// The object is only requested when it is first read.
+		return nil, s.objectError(owner.GetName(), err)
+	}
+	return fsys, nil
+}
+
+func (s *S3) Store(ctx context.Context, owner client.Object, bundle fs.FS) error {
+	_, err := s.StoreWithDigest(ctx, owner, bundle)
+	return err
+}
+
+func (s *S3) StoreWithDigest(ctx context.Context, owner client.Object, bundle fs.FS) (string, error) {
+	// The content is staged in a temporary file, so that its size is known
+	// and it is uploaded in a single request if it is small enough.
+	tmpFile, err := os.CreateTemp("", fmt.Sprintf("%s-*.tgz", owner.GetName()))
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	h := newDigester()
+	if err := util.FSToNormalizedTarGZ(io.MultiWriter(tmpFile, h), bundle); err != nil {
+		return "", fmt.Errorf("convert bundle %q to tar.gz: %v", owner.GetName(), err)
+	}
+	size, err := tmpFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	digest := formatDigest(h)
+	if _, err := s.Client.PutObject(ctx, s.Bucket, s.objectKey(owner.GetName()), tmpFile, size, minio.PutObjectOptions{
+		ContentType:  "application/gzip",
+		UserMetadata: map[string]string{s3DigestMetadata: digest},
+	}); err != nil {
+		return "", fmt.Errorf("put object %q: %v", s.objectKey(owner.GetName()), err)
+	}
+	return digest, nil
+}
+
+// Check checks that the object of the owner exists and was stored with the
+// digest of its content, if the object records it.
+func (s *S3) Check(ctx context.Context, owner client.Object) error {
+	info, err := s.Client.StatObject(ctx, s.Bucket, s.objectKey(owner.GetName()), minio.StatObjectOptions{})
+	if err != nil {
+		return s.objectError(owner.GetName(), err)
+	}
+	expected := expectedDigest(owner)
+	if digest := info.UserMetadata[s3DigestMetadata]; expected != "" && digest != "" && digest != expected {
+		return &DigestMismatchError{Expected: expected, Actual: digest}
+	}
+	return nil
+}
+
+func (s *S3) Delete(ctx context.Context, owner client.Object) error {
+	s.presignedMu.Lock()
+	delete(s.presigned, s.objectKey(owner.GetName()))
+	s.presignedMu.Unlock()
+
+	if err := s.Client.RemoveObject(ctx, s.Bucket, s.objectKey(owner.GetName()), minio.RemoveObjectOptions{}); err != nil {
+		if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return fmt.Errorf("remove object %q: %v", s.objectKey(owner.GetName()), err)
+	}
+	return nil
+}
+
+// ServeHTTP serves the contents of bundles from the bucket at the paths
+// returned by URLFor when presigned URLs are not used.
+func (s *S3) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(resp, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(req.URL.Path, s.URL.Path)
+	bundleName, ok := strings.CutSuffix(name, ".tgz")
+	if !ok || bundleName == "" || strings.Contains(bundleName, "/") {
+		http.NotFound(resp, req)
+		return
+	}
+
+	obj, err := s.Client.GetObject(req.Context(), s.Bucket, s.objectKey(bundleName), minio.GetObjectOptions{})
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer obj.Close()
+	info, err := obj.Stat()
+	if err != nil {
+		if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+			http.NotFound(resp, req)
+			return
+		}
+		http.Error(resp, err.Error(), http.StatusBadGateway)
+		return
+	}
+	http.ServeContent(resp, req, name, info.LastModified, obj)
+}
+
+func (s *S3) URLFor(ctx context.Context, owner client.Object) (string, error) {
+	if s.PresignExpiry > 0 {
+		return s.presign(ctx, s.objectKey(owner.GetName()))
+	}
+	return fmt.Sprintf("%s%s.tgz", s.URL.String(), owner.GetName()), nil
+}
+
+// URLRefreshAfter returns the time until the presigned URL of the owner
+// should be renewed, once less than half of its validity remains.
+func (s *S3) URLRefreshAfter(owner client.Object) time.Duration {
+	if s.PresignExpiry <= 0 {
+		return 0
+	}
+	s.presignedMu.Lock()
+	defer s.presignedMu.Unlock()
+	cached, ok := s.presigned[s.objectKey(owner.GetName())]
+	if !ok {
+		return 0
+	}
+	if refreshAfter := time.Until(cached.refreshAt); refreshAfter > 0 {
+		return refreshAfter
+	}
+	// The URL is due to be renewed already.
+	return time.Second
+}
+
+func (s *S3) presign(ctx context.Context, key string) (string, error) {
+	s.presignedMu.Lock()
+	defer s.presignedMu.Unlock()
+
+	now := time.Now()
+	if cached, ok := s.presigned[key]; ok && now.Before(cached.refreshAt) {
+		return cached.url, nil
+	}
+	u, err := s.Client.PresignedGetObject(ctx, s.Bucket, key, s.PresignExpiry, nil)
+	if err != nil {
+		return "", fmt.Errorf("presign object %q: %v", key, err)
+	}
+	if s.presigned == nil {
+		s.presigned = map[string]presignedURL{}
+	}
+	s.presigned[key] = presignedURL{url: u.String(), refreshAt: now.Add(s.PresignExpiry / 2)}
+	return u.String(), nil
+}
+
+func (s *S3) objectKey(bundleName string) string {
+	return fmt.Sprintf("%s%s.tgz", s.Prefix, bundleName)
+}
+
+// objectError returns the error of a request for the object of the named
+// bundle, which wraps fs.ErrNotExist if the object does not exist.
+func (s *S3) objectError(bundleName string, err error) error {
+	if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+		return fmt.Errorf("get object %q: %w", s.objectKey(bundleName), fs.ErrNotExist)
+	}
+	return fmt.Errorf("get object %q: %v", s.objectKey(bundleName), err)
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rukpakv1alpha1 "github.com/operator-framework/rukpak/api/v1alpha1"
+	"github.com/operator-framework/rukpak/test/testutil"
+)
+
+var _ = Describe("S3", func() {
+	var (
+		ctx     context.Context
+		server  *testutil.S3Server
+		owner   *rukpakv1alpha1.Bundle
+		store   *S3
+		testFS  fs.FS
+		baseURL *url.URL
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		server = testutil.NewS3Server("test-access-key")
+		DeferCleanup(server.Close)
+		GinkgoT().Setenv("AWS_ACCESS_KEY_ID", "test-access-key")
+		GinkgoT().Setenv("AWS_SECRET_ACCESS_KEY", "test-secret-key")
+
+		var err error
+		baseURL, err = url.Parse("https://core.rukpak-system.svc/bundles/")
+		Expect(err).NotTo(HaveOccurred())
+		store, err = NewS3(S3Config{Endpoint: server.URL, Bucket: "rukpak", Prefix: "core/"}, *baseURL, http.DefaultTransport)
+		Expect(err).NotTo(HaveOccurred())
+		owner = &rukpakv1alpha1.Bundle{ObjectMeta: metav1.ObjectMeta{Name: "test-bundle"}}
+		testFS = generateFS()
+	})
+
+	It("should fail to load a bundle that is not stored", func() {
+		_, err := store.Load(ctx, owner)
+		Expect(errors.Is(err, fs.ErrNotExist)).To(BeTrue())
+	})
+
+	It("should store, load and delete bundles as objects in the bucket", func() {
+		Expect(store.Store(ctx, owner, testFS)).To(Succeed())
+		_, ok := server.GetObject("rukpak", "core/test-bundle.tgz")
+		Expect(ok).To(BeTrue())
+
+		loadedFS, err := store.Load(ctx, owner)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fsEqual(testFS, loadedFS)).To(BeTrue())
+
+		Expect(store.Delete(ctx, owner)).To(Succeed())
+		_, ok = server.GetObject("rukpak", "core/test-bundle.tgz")
+		Expect(ok).To(BeFalse())
+		Expect(store.Delete(ctx, owner)).To(Succeed())
+	})
+
+	It("should check that the object of a bundle exists and matches its digest", func() {
+		Expect(errors.Is(store.Check(ctx, owner), fs.ErrNotExist)).To(BeTrue())
+
+		digest, err := store.StoreWithDigest(ctx, owner, testFS)
+		Expect(err).NotTo(HaveOccurred())
+		owner.Status.ContentDigest = digest
+		Expect(store.Check(ctx, owner)).To(Succeed())
+
+		owner.Status.ContentDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+		var mismatchErr *DigestMismatchError
+		Expect(errors.As(store.Check(ctx, owner), &mismatchErr)).To(BeTrue())
+		Expect(mismatchErr.Actual).To(Equal(digest))
+	})
+
+	It("should serve stored bundles at their URLs", func() {
+		Expect(store.Store(ctx, owner, testFS)).To(Succeed())
+		contentURL, err := store.URLFor(ctx, owner)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(contentURL).To(Equal("https://core.rukpak-system.svc/bundles/test-bundle.tgz"))
+
+		resp := httptest.NewRecorder()
+		store.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/bundles/test-bundle.tgz", nil))
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		data, ok := server.GetObject("rukpak", "core/test-bundle.tgz")
+		Expect(ok).To(BeTrue())
+		Expect(resp.Body.Bytes()).To(Equal(data))
+
+		resp = httptest.NewRecorder()
+		store.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/bundles/other-bundle.tgz", nil))
+		Expect(resp.Code).To(Equal(http.StatusNotFound))
+	})
+
+	Context("with presigned URLs", func() {
+		BeforeEach(func() {
+			store.PresignExpiry = time.Hour
+		})
+
+		It("should return stable presigned URLs that load the bundle", func() {
+			Expect(store.Store(ctx, owner, testFS)).To(Succeed())
+			contentURL, err := store.URLFor(ctx, owner)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(contentURL).To(HavePrefix(server.URL + "/rukpak/core/test-bundle.tgz?"))
+			Expect(store.URLFor(ctx, owner)).To(Equal(contentURL))
+			Expect(URLRefreshAfter(WithFallbackLoader(store, NewHTTP()), owner)).To(BeNumerically("~", 30*time.Minute, time.Minute))
+
+			// The bearer token is not sent with presigned URLs.
+			owner.Status.ContentURL = contentURL
+			loadedFS, err := NewHTTP(WithBearerToken("test-token")).Load(ctx, owner)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fsEqual(testFS, loadedFS)).To(BeTrue())
+		})
+	})
+})
@@ -2,8 +2,10 @@ package storage
 
 import (
 	"context"
+	"io"
 	"io/fs"
 	"net/http"
+	"time"
 
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -14,9 +16,21 @@ type Storage interface {
 }
 
 type Loader interface {
+	// Load loads the content of the owner. The returned FS may hold open
+	// files, in which case it implements io.Closer, so callers must close it
+	// with CloseFS when they are done with it.
 	Load(ctx context.Context, owner client.Object) (fs.FS, error)
 }
 
+// CloseFS closes an FS that was returned by a Loader if it implements
+// io.Closer.
+func CloseFS(fsys fs.FS) error {
+	if c, ok := fsys.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
 type Storer interface {
 	Store(ctx context.Context, owner client.Object, bundle fs.FS) error
 	Delete(ctx context.Context, owner client.Object) error
@@ -25,6 +39,80 @@ type Storer interface {
 	URLFor(ctx context.Context, owner client.Object) (string, error)
 }
 
+// DigestStorer is implemented by storages that compute the digest of the
+// content that they store.
+type DigestStorer interface {
+	// StoreWithDigest stores the content of the owner like Store, and returns
+	// the digest of the stored content, which loaders verify the content
+	// against.
+	StoreWithDigest(ctx context.Context, owner client.Object, bundle fs.FS) (string, error)
+}
+
+// StoreContent stores the content of the owner in s, and returns the digest
+// of the stored content if s implements DigestStorer, or an empty string
+// otherwise.
+func StoreContent(ctx context.Context, s Storer, owner client.Object, bundle fs.FS) (string, error) {
+	if d, ok := s.(DigestStorer); ok {
+		return d.StoreWithDigest(ctx, owner, bundle)
+	}
+	return "", s.Store(ctx, owner, bundle)
+}
+
+// ExpiringURLStorer is implemented by storages whose content URLs expire, so
+// that they need to be requested again with URLFor before they do.
+type ExpiringURLStorer interface {
+	// URLRefreshAfter returns the time after which the URL of the content of
+	// the owner that was last returned by URLFor should be requested again,
+	// or zero if it does not expire.
+	URLRefreshAfter(owner client.Object) time.Duration
+}
+
+// URLRefreshAfter returns the time after which the URL of the content of the
+// owner in s should be requested again, or zero if it does not expire.
+func URLRefreshAfter(s Storer, owner client.Object) time.Duration {
+	if e, ok := s.(ExpiringURLStorer); ok {
+		return e.URLRefreshAfter(owner)
+	}
+	return 0
+}
+
+// Checker is implemented by storages that can check whether the content of
+// an owner is stored without loading it.
+type Checker interface {
+	// Check returns an error if the content of the owner is not stored, or if
+	// it does not match the digest that it was stored with.
+	Check(ctx context.Context, owner client.Object) error
+}
+
+// CheckContent checks whether the content of the owner is stored in s. If s
+// does not implement Checker, the content is loaded instead.
+func CheckContent(ctx context.Context, s Loader, owner client.Object) error {
+	if c, ok := s.(Checker); ok {
+		return c.Check(ctx, owner)
+	}
+	fsys, err := s.Load(ctx, owner)
+	if err != nil {
+		return err
+	}
+	return CloseFS(fsys)
+}
+
+// Backend selects where a provisioner stores bundle contents.
+type Backend string
+
+const (
+	// BackendLocal stores bundle contents in a local directory of the
+	// provisioner, with LocalDirectory or ContentAddressableDirectory.
+	BackendLocal Backend = "local"
+
+	// BackendS3 stores bundle contents in a bucket of an S3-compatible object
+	// storage service, with S3.
+	BackendS3 Backend = "s3"
+)
+
+// Backends is the list of supported storage backends.
+var Backends = []Backend{BackendLocal, BackendS3}
+
 type fallbackLoaderStorage struct {
 	Storage
 	fallbackLoader Loader
@@ -44,3 +132,17 @@ func (s *fallbackLoaderStorage) Load(ctx context.Context, owner client.Object) (
 	}
 	return fsys, nil
 }
+
+func (s *fallbackLoaderStorage) StoreWithDigest(ctx context.Context, owner client.Object, bundle fs.FS) (string, error) {
+	return StoreContent(ctx, s.Storage, owner, bundle)
+}
+
+func (s *fallbackLoaderStorage) URLRefreshAfter(owner client.Object) time.Duration {
+	return URLRefreshAfter(s.Storage, owner)
+}
+
+// Check checks the content of the owner in the storage only, because the
+// fallback loader does not store content.
+func (s *fallbackLoaderStorage) Check(ctx context.Context, owner client.Object) error {
+	return CheckContent(ctx, s.Storage, owner)
+}
@@ -0,0 +1,338 @@
+package storage
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	_ io.Closer     = &tarGZFS{}
+	_ fs.ReadDirFS  = &tarGZFS{}
+	_ fs.ReadFileFS = &tarGZFS{}
+	_ fs.StatFS     = &tarGZFS{}
+)
+
+// tarGZFS is a read-only fs.FS of the regular files and directories in a
+// tar.gz archive. The first time the FS is used, the archive is decompressed
+// into an unlinked temporary file and indexed. The contents of files are then
+// read from that file on demand, so that only the index of the archive is
+// held in memory. The FS must be closed to remove that file.
+type tarGZFS struct {
+	src      io.ReadCloser
+	spoolDir string
+
+	// digest is the digest that the archive is verified against while it is
+	// read, unless it is empty.
+	digest string
+
+	once    sync.Once
+	err     error
+	tarFile *os.File
+	entries map[string]*tarEntry
+}
+
+type tarEntry struct {
+	header *tar.Header
+	offset int64
+
+	// children are the sorted names of the entries in a directory.
+	children []string
+}
+
+// newTarGZFS returns an FS of the tar.gz archive that is read from src, which
+// it closes once the archive is indexed. The archive is decompressed into
+// spoolDir, or into the default directory for temporary files if spoolDir is
+// empty.
+func newTarGZFS(src io.ReadCloser, spoolDir string) *tarGZFS {
+	return &tarGZFS{src: src, spoolDir: spoolDir}
+}
+
+// loadTarGZ returns an FS of the tar.gz archive that is read from src. Unlike
+// newTarGZFS, it reads the archive immediately, so that src does not need to
+// remain open, e.g. if it is the body of a response. If digest is not empty,
+// the archive is verified against it, and a DigestMismatchError is returned if
+// it does not match.
+func loadTarGZ(src io.ReadCloser, spoolDir, digest string) (fs.FS, error) {
+	fsys := newTarGZFS(src, spoolDir)
+	fsys.digest = digest
+	if err := fsys.index(); err != nil {
+		return nil, err
+	}
+	return fsys, nil
+}
+
+// index decompresses and indexes the archive once, and returns the error of
+// doing so.
+func (t *tarGZFS) index() error {
+	t.once.Do(func() {
+		defer t.src.Close()
+		t.err = t.spool()
+		if t.err != nil && t.tarFile != nil {
+			t.tarFile.Close()
+			t.tarFile = nil
+		}
+	})
+	return t.err
+}
+
+func (t *tarGZFS) spool() error {
+	tarFile, err := os.CreateTemp(t.spoolDir, "bundle-*.tar")
+	if err != nil {
+		return err
+	}
+	t.tarFile = tarFile
+	// The file is unlinked right away, so that it is removed when it is closed,
+	// which happens when the FS is closed.
+	if err := os.Remove(tarFile.Name()); err != nil {
+		return err
+	}
+
+	if t.digest == "" {
+		if err := decompress(tarFile, t.src); err != nil {
+			return err
+		}
+	} else {
+		src := &errRecorder{r: t.src}
+		h := newDigester()
+		r := io.TeeReader(src, h)
+		err := decompress(tarFile, r)
+		// The rest of the archive is read even if it cannot be decompressed,
+		// so that truncated and corrupted archives are reported as digest
+		// mismatches, unless the archive itself cannot be read.
+		_, _ = io.Copy(io.Discard, r)
+		if src.err != nil {
+			return src.err
+		}
+		if err := verifyDigest(t.digest, h); err != nil {
+			return err
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := tarFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	t.entries = map[string]*tarEntry{".": {header: dirHeader(".")}}
+	tr := tar.NewReader(tarFile)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read bundle content: %v", err)
+		}
+		name := path.Clean(strings.TrimPrefix(h.Name, "/"))
+		if !fs.ValidPath(name) {
+			continue
+		}
+		switch h.Typeflag {
+		case tar.TypeReg, tar.TypeDir:
+		default:
+			continue
+		}
+		// The position of the reader in the file is the start of the data of
+		// the entry, because tar readers do not read ahead.
+		offset, err := tarFile.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		h.Name = name
+		t.addEntry(name, &tarEntry{header: h, offset: offset})
+	}
+	for _, e := range t.entries {
+		sort.Strings(e.children)
+	}
+	return nil
+}
+
+func decompress(dst io.Writer, src io.Reader) error {
+	gzr, err := gzip.NewReader(src)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, gzr); err != nil {
+		return fmt.Errorf("decompress bundle content: %v", err)
+	}
+	return nil
+}
+
+// errRecorder records the first error of reading from r other than io.EOF.
+type errRecorder struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errRecorder) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) && e.err == nil {
+		e.err = err
+	}
+	return n, err
+}
+
+// addEntry adds the entry with the given name, and any parent directories of
+// it that are not in the archive.
+func (t *tarGZFS) addEntry(name string, e *tarEntry) {
+	if existing, ok := t.entries[name]; ok {
+		if existing.header.Typeflag == tar.TypeDir && e.header.Typeflag == tar.TypeDir {
+			existing.header = e.header
+			return
+		}
+		// A later entry with the same name replaces the earlier one.
+		e.children = existing.children
+		t.entries[name] = e
+		return
+	}
+	t.entries[name] = e
+	if name == "." {
+		return
+	}
+	dir := path.Dir(name)
+	if _, ok := t.entries[dir]; !ok {
+		t.addEntry(dir, &tarEntry{header: dirHeader(dir)})
+	}
+	parent := t.entries[dir]
+	parent.children = append(parent.children, path.Base(name))
+}
+
+func dirHeader(name string) *tar.Header {
+	return &tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: 0755}
+}
+
+// Close closes the archive if it has not been indexed yet, and the file that
+// it is decompressed into. The FS cannot be used once it is closed.
+func (t *tarGZFS) Close() error {
+	t.once.Do(func() {
+		t.src.Close()
+	})
+	t.err = fs.ErrClosed
+	if t.tarFile == nil {
+		return nil
+	}
+	tarFile := t.tarFile
+	t.tarFile = nil
+	return tarFile.Close()
+}
+
+func (t *tarGZFS) lookup(op, name string) (*tarEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if err := t.index(); err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	e, ok := t.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return e, nil
+}
+
+func (t *tarGZFS) Open(name string) (fs.File, error) {
+	e, err := t.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if e.header.Typeflag == tar.TypeDir {
+		return &tarDir{fsys: t, entry: e}, nil
+	}
+	return &tarFile{entry: e, SectionReader: io.NewSectionReader(t.tarFile, e.offset, e.header.Size)}, nil
+}
+
+func (t *tarGZFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	e, err := t.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if e.header.Typeflag != tar.TypeDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return t.dirEntries(e), nil
+}
+
+func (t *tarGZFS) ReadFile(name string) ([]byte, error) {
+	e, err := t.lookup("read", name)
+	if err != nil {
+		return nil, err
+	}
+	if e.header.Typeflag == tar.TypeDir {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+	data := make([]byte, e.header.Size)
+	if _, err := t.tarFile.ReadAt(data, e.offset); err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+func (t *tarGZFS) Stat(name string) (fs.FileInfo, error) {
+	e, err := t.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return e.header.FileInfo(), nil
+}
+
+func (t *tarGZFS) dirEntries(e *tarEntry) []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(e.children))
+	for _, child := range e.children {
+		childEntry := t.entries[path.Join(e.header.Name, child)]
+		entries = append(entries, fs.FileInfoToDirEntry(childEntry.header.FileInfo()))
+	}
+	return entries
+}
+
+type tarFile struct {
+	*io.SectionReader
+	entry *tarEntry
+}
+
+func (f *tarFile) Stat() (fs.FileInfo, error) { return f.entry.header.FileInfo(), nil }
+func (f *tarFile) Close() error               { return nil }
+
+type tarDir struct {
+	fsys    *tarGZFS
+	entry   *tarEntry
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *tarDir) Stat() (fs.FileInfo, error) { return d.entry.header.FileInfo(), nil }
+func (d *tarDir) Close() error               { return nil }
+
+func (d *tarDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.entry.header.Name, Err: errors.New("is a directory")}
+}
+
+func (d *tarDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		d.entries = d.fsys.dirEntries(d.entry)
+		d.read = true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
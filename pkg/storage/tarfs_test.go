@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"testing/fstest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/operator-framework/rukpak/internal/util"
+)
+
+var _ = Describe("tarGZFS", func() {
+	var (
+		testFS   fs.FS
+		spoolDir string
+	)
+
+	tarGZ := func(fsys fs.FS) io.ReadCloser {
+		buf := &bytes.Buffer{}
+		Expect(util.FSToTarGZ(buf, fsys)).To(Succeed())
+		return io.NopCloser(buf)
+	}
+
+	BeforeEach(func() {
+		testFS = generateFS()
+		spoolDir = GinkgoT().TempDir()
+	})
+
+	It("should read the files of the archive", func() {
+		fsys := newTarGZFS(tarGZ(testFS), spoolDir)
+		Expect(fsEqual(testFS, fsys)).To(BeTrue())
+
+		var names []string
+		Expect(fs.WalkDir(testFS, ".", func(path string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				names = append(names, path)
+			}
+			return err
+		})).To(Succeed())
+		Expect(fstest.TestFS(fsys, names...)).To(Succeed())
+	})
+
+	It("should only read the archive when it is first used", func() {
+		src := &countingReadCloser{ReadCloser: tarGZ(testFS)}
+		fsys := newTarGZFS(src, spoolDir)
+		Expect(src.n).To(BeZero())
+
+		_, err := fs.ReadDir(fsys, ".")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(src.n).NotTo(BeZero())
+		Expect(src.closed).To(BeTrue())
+	})
+
+	It("should not leave the decompressed archive in the spool directory", func() {
+		fsys, err := loadTarGZ(tarGZ(testFS), spoolDir, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fsEqual(testFS, fsys)).To(BeTrue())
+		Expect(os.ReadDir(spoolDir)).To(BeEmpty())
+	})
+
+	It("should close the archive and fail to be used once it is closed", func() {
+		src := &countingReadCloser{ReadCloser: tarGZ(testFS)}
+		fsys := newTarGZFS(src, spoolDir)
+		Expect(fsys.Close()).To(Succeed())
+		Expect(src.n).To(BeZero())
+		Expect(src.closed).To(BeTrue())
+		_, err := fsys.Open(".")
+		Expect(errors.Is(err, fs.ErrClosed)).To(BeTrue())
+
+		loadedFS, err := loadTarGZ(tarGZ(testFS), spoolDir, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(CloseFS(loadedFS)).To(Succeed())
+		_, err = fs.ReadDir(loadedFS, ".")
+		Expect(errors.Is(err, fs.ErrClosed)).To(BeTrue())
+	})
+
+	It("should fail to load an invalid archive", func() {
+		_, err := loadTarGZ(io.NopCloser(bytes.NewBufferString("not a tar.gz")), spoolDir, "")
+		Expect(err).To(HaveOccurred())
+
+		_, err = newTarGZFS(io.NopCloser(bytes.NewBufferString("not a tar.gz")), spoolDir).Open(".")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+type countingReadCloser struct {
+	io.ReadCloser
+	n      int
+	closed bool
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += n
+	return n, err
+}
+
+func (r *countingReadCloser) Close() error {
+	r.closed = true
+	return r.ReadCloser.Close()
+}
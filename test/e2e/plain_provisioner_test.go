@@ -1049,6 +1049,83 @@ var _ = Describe("plain provisioner bundle", func() {
 		})
 	})
 
+	When("the bundle is backed by a valid secret", func() {
+		var (
+			bundle *rukpakv1alpha1.Bundle
+			secret *corev1.Secret
+			ctx    context.Context
+		)
+
+		BeforeEach(func() {
+			ctx = context.Background()
+
+			data := map[string][]byte{}
+			err := filepath.Walk(filepath.Join(testdataDir, "bundles/plain-v0/valid/manifests"), func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.IsDir() {
+					return nil
+				}
+				c, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				data[info.Name()] = c
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			secret = &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "bundle-secret-valid-",
+					Namespace:    defaultSystemNamespace,
+				},
+				Data:      data,
+				Immutable: pointer.Bool(true),
+			}
+			err = c.Create(ctx, secret)
+			Expect(err).ToNot(HaveOccurred())
+			bundle = &rukpakv1alpha1.Bundle{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "combo-local-",
+				},
+				Spec: rukpakv1alpha1.BundleSpec{
+					ProvisionerClassName: plain.ProvisionerID,
+					Source: rukpakv1alpha1.BundleSource{
+						Type: rukpakv1alpha1.SourceTypeSecrets,
+						Secrets: []rukpakv1alpha1.SecretSource{{
+							Secret: corev1.LocalObjectReference{Name: secret.ObjectMeta.Name},
+							Path:   "manifests",
+						}},
+					},
+				},
+			}
+			err = c.Create(ctx, bundle)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			Expect(client.IgnoreNotFound(c.Delete(ctx, bundle))).To(Succeed())
+			Expect(client.IgnoreNotFound(c.Delete(ctx, secret))).To(Succeed())
+		})
+
+		It("Can create and unpack the bundle successfully", func() {
+			Eventually(func() error {
+				if err := c.Get(ctx, client.ObjectKeyFromObject(bundle), bundle); err != nil {
+					return err
+				}
+				if bundle.Status.Phase != rukpakv1alpha1.PhaseUnpacked {
+					unpackedCondition := meta.FindStatusCondition(bundle.Status.Conditions, rukpakv1alpha1.TypeUnpacked)
+					if unpackedCondition == nil {
+						return errors.New("bundle is not unpacked")
+					}
+					return fmt.Errorf("bundle is not unpacked: %s", unpackedCondition.Message)
+				}
+				return nil
+			}).Should(BeNil())
+		})
+	})
+
 	When("the bundle is uploaded", func() {
 		var (
 			bundle *rukpakv1alpha1.Bundle
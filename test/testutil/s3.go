@@ -0,0 +1,255 @@
+package testutil
+
+import (
+	"bufio"
+	"crypto/md5" // nolint:gosec
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3Server is an in-memory stand-in for an S3-compatible object storage
+// service, for use in tests. It serves path-style requests to get, put and
+// delete objects in versioned buckets, and requires requests to be signed with the configured
+// access key ID (but does not verify the signatures).
+type S3Server struct {
+	*httptest.Server
+	AccessKeyID string
+
+	mu      sync.Mutex
+	objects map[string][]*s3Object
+	version int
+}
+
+type s3Object struct {
+	data        []byte
+	etag        string
+	versionID   string
+	contentType string
+	metadata    http.Header
+	modTime     time.Time
+}
+
+// NewS3Server starts an S3Server that requires requests to be signed with
+// the given access key ID. If accessKeyID is empty, anonymous requests are
+// allowed.
+func NewS3Server(accessKeyID string) *S3Server {
+	s := &S3Server{
+		AccessKeyID: accessKeyID,
+		objects:     map[string][]*s3Object{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// PutObject stores a new version of the object with the given key in the
+// given bucket, and returns the entity tag and version ID of the object.
+func (s *S3Server) PutObject(bucket, key string, data []byte, contentType string) (string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.putObject(bucket, key, data, contentType, nil)
+}
+
+func (s *S3Server) putObject(bucket, key string, data []byte, contentType string, metadata http.Header) (string, string) {
+	sum := md5.Sum(data) // nolint:gosec
+	s.version++
+	obj := &s3Object{
+		data:        data,
+		etag:        hex.EncodeToString(sum[:]),
+		versionID:   fmt.Sprintf("v%d", s.version),
+		contentType: contentType,
+		metadata:    metadata,
+		modTime:     time.Now().UTC().Truncate(time.Second),
+	}
+	objKey := bucket + "/" + key
+	s.objects[objKey] = append(s.objects[objKey], obj)
+	return obj.etag, obj.versionID
+}
+
+// GetObject returns the data of the latest version of the object with the
+// given key in the given bucket, or false if the object does not exist.
+func (s *S3Server) GetObject(bucket, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj := s.getObject(bucket, key, "")
+	if obj == nil {
+		return nil, false
+	}
+	return obj.data, true
+}
+
+func (s *S3Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("X-Amz-Credential") && r.Header.Get("Authorization") != "" {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "Only one auth mechanism allowed; only the X-Amz-Algorithm query parameter, Signature query string parameter or the Authorization header should be specified")
+		return
+	}
+	if !s.authorized(r) {
+		writeS3Error(w, http.StatusForbidden, "AccessDenied", "Access Denied")
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if _, ok := r.URL.Query()["location"]; ok && key == "" {
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></LocationConstraint>`))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		obj := s.getObject(bucket, key, r.URL.Query().Get("versionId"))
+		if obj == nil {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+		if match := r.Header.Get("If-Match"); match != "" && strings.Trim(match, `"`) != obj.etag {
+			writeS3Error(w, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf("%q", obj.etag))
+		w.Header().Set("Last-Modified", obj.modTime.Format(http.TimeFormat))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(obj.data)))
+		w.Header().Set("x-amz-version-id", obj.versionID)
+		if obj.contentType != "" {
+			w.Header().Set("Content-Type", obj.contentType)
+		}
+		for k, v := range obj.metadata {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(obj.data)
+		}
+	case http.MethodPut:
+		body := io.Reader(r.Body)
+		if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			body = &awsChunkedReader{r: bufio.NewReader(r.Body)}
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+		metadata := http.Header{}
+		for k, v := range r.Header {
+			if strings.HasPrefix(k, "X-Amz-Meta-") {
+				metadata[k] = v
+			}
+		}
+		etag, versionID := s.putObject(bucket, key, data, r.Header.Get("Content-Type"), metadata)
+		w.Header().Set("ETag", fmt.Sprintf("%q", etag))
+		w.Header().Set("x-amz-version-id", versionID)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		// Deleting an object that does not exist succeeds, as it does in S3.
+		objKey := bucket + "/" + key
+		if versionID := r.URL.Query().Get("versionId"); versionID != "" {
+			versions := s.objects[objKey][:0]
+			for _, obj := range s.objects[objKey] {
+				if obj.versionID != versionID {
+					versions = append(versions, obj)
+				}
+			}
+			s.objects[objKey] = versions
+		} else {
+			delete(s.objects, objKey)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource.")
+	}
+}
+
+func (s *S3Server) getObject(bucket, key, versionID string) *s3Object {
+	versions := s.objects[bucket+"/"+key]
+	if len(versions) == 0 {
+		return nil
+	}
+	if versionID == "" {
+		return versions[len(versions)-1]
+	}
+	for _, obj := range versions {
+		if obj.versionID == versionID {
+			return obj
+		}
+	}
+	return nil
+}
+
+// authorized returns whether the request is signed with the server's access
+// key ID, either in the Authorization header or in the query parameters of a
+// presigned URL.
+func (s *S3Server) authorized(r *http.Request) bool {
+	if s.AccessKeyID == "" {
+		return true
+	}
+	credential := fmt.Sprintf("Credential=%s/", s.AccessKeyID)
+	return strings.Contains(r.Header.Get("Authorization"), credential) ||
+		strings.HasPrefix(r.URL.Query().Get("X-Amz-Credential"), s.AccessKeyID+"/")
+}
+
+// awsChunkedReader decodes the aws-chunked encoding of the bodies of requests
+// with streaming signatures, which consists of chunks of the form
+// "<hex size>;chunk-signature=<signature>\r\n<data>\r\n" that end with a
+// chunk of size 0. The chunk signatures are not verified.
+type awsChunkedReader struct {
+	r         *bufio.Reader
+	remaining int64
+	done      bool
+}
+
+func (c *awsChunkedReader) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if c.remaining == 0 && err == nil {
+		_, err = c.r.Discard(2) // The CRLF after the chunk data.
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (c *awsChunkedReader) nextChunk() error {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("read chunk header: %v", err)
+	}
+	sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+	size, err := strconv.ParseInt(sizeHex, 16, 64)
+	if err != nil {
+		return fmt.Errorf("parse chunk size %q: %v", sizeHex, err)
+	}
+	c.remaining = size
+	c.done = size == 0
+	return nil
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+}